@@ -0,0 +1,189 @@
+// This file is the admin CLI's client for the server's admin API (see
+// cmd/server/admin.go). It knows the endpoints and the JSON shapes; main.go
+// decides what to print.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - A small API client type wrapping http.Client
+//   - Decoding JSON into caller-supplied values (any)
+//   - Reading a Server-Sent Events stream with bufio.Scanner
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The types below mirror the server's admin API responses. As with the chat
+// client's Message, they are duplicated rather than shared because each
+// command is its own package main.
+
+type connectionInfo struct {
+	User        string        `json:"user"`
+	ID          string        `json:"id"`
+	ConnectedAt time.Time     `json:"connected_at"`
+	LastPong    time.Time     `json:"last_pong,omitzero"`
+	RTT         time.Duration `json:"rtt_ns"`
+}
+
+type onlineUser struct {
+	User    string           `json:"user"`
+	Devices []connectionInfo `json:"devices"`
+}
+
+type roomInfo struct {
+	ID         string   `json:"id"`
+	Type       string   `json:"type"`
+	Name       string   `json:"name"`
+	Topic      string   `json:"topic,omitempty"`
+	Owner      string   `json:"owner"`
+	Admins     []string `json:"admins,omitempty"`
+	Members    []string `json:"members"`
+	Visibility string   `json:"visibility"`
+}
+
+type event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	Conn   string    `json:"conn,omitempty"`
+	Room   string    `json:"room,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// client calls the admin API at base (e.g. http://127.0.0.1:8081) with the
+// admin token.
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// do sends a request and, if out is non-nil, decodes the JSON response into
+// it. Any status outside 2xx becomes an error carrying the server's message.
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *client) users(ctx context.Context) ([]onlineUser, error) {
+	var users []onlineUser
+	return users, c.do(ctx, "GET", "/admin/users", nil, &users)
+}
+
+func (c *client) connections(ctx context.Context) ([]connectionInfo, error) {
+	var conns []connectionInfo
+	return conns, c.do(ctx, "GET", "/admin/connections", nil, &conns)
+}
+
+func (c *client) rooms(ctx context.Context) ([]roomInfo, error) {
+	var rooms []roomInfo
+	return rooms, c.do(ctx, "GET", "/admin/rooms", nil, &rooms)
+}
+
+func (c *client) room(ctx context.Context, name string) (roomInfo, error) {
+	var room roomInfo
+	return room, c.do(ctx, "GET", "/admin/rooms/"+url.PathEscape(name), nil, &room)
+}
+
+// kick closes all of a user's connections and returns how many there were.
+func (c *client) kick(ctx context.Context, user string) (int, error) {
+	var out struct {
+		Disconnected int `json:"disconnected"`
+	}
+	err := c.do(ctx, "POST", "/admin/users/"+url.PathEscape(user)+"/disconnect", nil, &out)
+	return out.Disconnected, err
+}
+
+func (c *client) removeMember(ctx context.Context, room, user string) error {
+	return c.do(ctx, "DELETE", "/admin/rooms/"+url.PathEscape(room)+"/members/"+url.PathEscape(user), nil, nil)
+}
+
+func (c *client) deleteRoom(ctx context.Context, room string) error {
+	return c.do(ctx, "DELETE", "/admin/rooms/"+url.PathEscape(room), nil, nil)
+}
+
+// announce sends text to everyone online and returns how many devices got it.
+func (c *client) announce(ctx context.Context, text string) (int, error) {
+	var out struct {
+		Delivered int `json:"delivered"`
+	}
+	err := c.do(ctx, "POST", "/admin/announce", map[string]string{"content": text}, &out)
+	return out.Delivered, err
+}
+
+// events streams the server's event feed, calling fn for each event, until
+// ctx is cancelled or the server ends the stream.
+//
+// LEARNING POINT — Parsing Server-Sent Events:
+// An SSE stream is text: each event is one or more "field: value" lines
+// followed by a blank line, and lines starting with ":" are comments (the
+// server sends those as keep-alives). The server puts one JSON event on each
+// "data:" line, so reading line by line and decoding those is all it takes.
+func (c *client) events(ctx context.Context, fn func(event)) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.base+"/admin/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+		fn(ev)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,138 @@
+// This file implements the client's account commands: signing up, logging
+// in, and keeping the resulting token on disk so the chat command can use it.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Making HTTP requests with http.NewRequestWithContext
+//   - os.UserConfigDir for per-user application data
+//   - File permissions (0o600) for secrets
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runAccountCommand runs "signup" or "login" for user: it prompts for a
+// password, asks the server for a token, and saves the token for later chat
+// sessions.
+func runAccountCommand(ctx context.Context, command, user string) error {
+	password, err := promptPassword()
+	if err != nil {
+		return err
+	}
+	token, err := requestToken(ctx, "/"+command, user, password)
+	if err != nil {
+		return err
+	}
+	if err := saveToken(user, token); err != nil {
+		return err
+	}
+	fmt.Printf("Logged in as %s. Start chatting with: go run ./cmd/client %s\n", user, user)
+	return nil
+}
+
+// promptPassword reads a password from stdin.
+//
+// The standard library has no portable way to turn off terminal echo, so
+// the password is visible as it's typed. Piping it in works too:
+//
+//	echo "$PASSWORD" | go run ./cmd/client login alice
+func promptPassword() (string, error) {
+	fmt.Print("Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// requestToken posts a username and password to one of the server's account
+// endpoints and returns the token from its response.
+//
+// LEARNING POINT — Response Bodies:
+// An http.Response body is a stream that must always be closed, even when
+// you don't read it, or the underlying TCP connection can't be reused.
+// "defer resp.Body.Close()" right after the error check is the idiom.
+func requestToken(ctx context.Context, path, user, password string) (string, error) {
+	body, err := json.Marshal(map[string]string{"user": user, "password": password})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", httpURL(serverURL)+path, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.Token, nil
+}
+
+// tokenPath returns where the token for user is kept, e.g.
+// ~/.config/whatsapp-clone/alice.token on Linux.
+//
+// LEARNING POINT — os.UserConfigDir:
+// Each OS has its own convention for per-user settings ($XDG_CONFIG_HOME or
+// ~/.config on Linux, ~/Library/Application Support on macOS, %AppData% on
+// Windows). os.UserConfigDir picks the right one so we don't have to.
+func tokenPath(user string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "whatsapp-clone", user+".token"), nil
+}
+
+// saveToken stores a user's token. The file is readable only by its owner,
+// since anyone holding the token can connect as that user until it expires.
+func saveToken(user, token string) error {
+	path, err := tokenPath(user)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(token), 0o600)
+}
+
+// loadToken reads the token saved for user.
+func loadToken(user string) (string, error) {
+	path, err := tokenPath(user)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// httpURL converts a ws:// or wss:// base URL to its http:// or https://
+// equivalent.
+func httpURL(base string) string {
+	return "http" + strings.TrimPrefix(base, "ws")
+}
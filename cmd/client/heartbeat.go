@@ -0,0 +1,64 @@
+// This file implements the client's side of connection heartbeats. The
+// server pings us to find out whether we are still there; we ping the server
+// for the same reason. Without it, a connection whose network silently died
+// would leave the client sitting at its prompt, sending messages nowhere,
+// with no sign that anything is wrong.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - time.Ticker for periodic work
+//   - WebSocket ping/pong control frames
+//   - Measuring elapsed time with time.Since
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	// defaultPingInterval is how often the client pings the server.
+	defaultPingInterval = 30 * time.Second
+
+	// defaultPingTimeout is how long the client waits for a pong before
+	// giving up on the connection.
+	defaultPingTimeout = 10 * time.Second
+)
+
+// ping sends one ping and waits for the pong, returning the round-trip time.
+//
+// LEARNING POINT — Pings Need a Reader:
+// The pong arrives on the same connection as chat messages, and c.Ping only
+// sees it because the read goroutine in main is inside c.Read at the time.
+// Without a concurrent reader, every ping would time out.
+func ping(ctx context.Context, c *websocket.Conn, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	if err := c.Ping(ctx); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// heartbeat pings the server every interval until ctx is cancelled. If a ping
+// goes unanswered the connection is closed, which makes the read goroutine
+// report the disconnect.
+func heartbeat(ctx context.Context, c *websocket.Conn, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := ping(ctx, c, timeout); err != nil {
+				log.Printf("Server stopped responding: %v", err)
+				c.CloseNow()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -5,7 +5,7 @@
 // inviting users, and sending room messages.
 //
 // KEY GO CONCEPTS IN THIS FILE:
-//   - os.Args for command-line argument parsing
+//   - The flag package for options, and flag.Args for positional arguments
 //   - Goroutines for concurrent read/write on the same connection
 //   - bufio.Scanner for line-by-line stdin reading
 //   - strings package for text parsing and manipulation
@@ -29,6 +29,9 @@ import (
 	// put shared types in a separate package to avoid duplication. This is fine
 	// for a small project but would become a maintenance burden at scale.
 	"encoding/json"
+
+	// flag parses command-line options such as -ping-interval.
+	"flag"
 	"fmt"
 
 	// log provides simple logging with timestamps and automatic newlines.
@@ -37,16 +40,25 @@ import (
 	// adds a timestamp prefix.
 	"log"
 
-	// os provides platform-independent OS functionality. os.Args contains
-	// command-line arguments (os.Args[0] is the program name). os.Stdin is
-	// the standard input stream.
+	// net/http supplies the header type used to send the login token with
+	// the WebSocket upgrade request.
+	"net/http"
+
+	// os provides platform-independent OS functionality. os.Stdin is the
+	// standard input stream.
 	"os"
 
+	// strconv parses the number of uses given to /link.
+	"strconv"
+
 	// strings provides functions for manipulating UTF-8 encoded strings.
 	// Common functions used here: HasPrefix (check prefix), TrimPrefix (remove
 	// prefix), SplitN (split into at most N parts), TrimSpace (strip whitespace).
 	"strings"
 
+	// time is needed for the server-assigned Message.Timestamp field.
+	"time"
+
 	"nhooyr.io/websocket"
 )
 
@@ -61,11 +73,95 @@ import (
 //   - Use code generation (protobuf, OpenAPI) to generate types for both
 //   - For small projects like this, duplicating the struct is acceptable
 type Message struct {
-	Type      string `json:"type"`
-	Sender    string `json:"sender"`
-	Recipient string `json:"recipient"`
-	Content   string `json:"content"`
-	Room      string `json:"room,omitempty"`
+	Type       string          `json:"type"`
+	ID         string          `json:"id,omitempty"`
+	Sender     string          `json:"sender"`
+	Recipient  string          `json:"recipient"`
+	Content    string          `json:"content"`
+	Room       string          `json:"room,omitempty"`
+	Timestamp  time.Time       `json:"timestamp,omitzero"`
+	Seq        uint64          `json:"seq,omitempty"`
+	Receipt    *Receipt        `json:"receipt,omitempty"`
+	RetryAfter int             `json:"retry_after,omitempty"`
+	MaxUses    int             `json:"max_uses,omitempty"`
+	ExpiresIn  int             `json:"expires_in,omitempty"`
+	Visibility string          `json:"visibility,omitempty"`
+	Rooms      []RoomSummary   `json:"rooms,omitempty"`
+	Details    *RoomDetails    `json:"details,omitempty"`
+	Update     map[string]any  `json:"update,omitempty"`
+	RoomType   string          `json:"room_type,omitempty"`
+	List       string          `json:"list,omitempty"`
+	Recipients []string        `json:"recipients,omitempty"`
+	Lists      []BroadcastList `json:"lists,omitempty"`
+}
+
+// Receipt mirrors the server's per-message delivery counts, sent with
+// "delivered" and "read" notifications.
+type Receipt struct {
+	Delivered int `json:"delivered"`
+	Read      int `json:"read"`
+	Total     int `json:"total"`
+}
+
+// RoomSummary mirrors one entry of the server's "room_list" reply to /rooms.
+type RoomSummary struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Topic      string `json:"topic,omitempty"`
+	Visibility string `json:"visibility"`
+	Members    int    `json:"members"`
+	Joined     bool   `json:"joined"`
+	Role       string `json:"role,omitempty"`
+}
+
+// BroadcastList mirrors one of the user's broadcast lists, as sent in reply
+// to /lists.
+type BroadcastList struct {
+	Name       string   `json:"name"`
+	Recipients []string `json:"recipients"`
+}
+
+// RoomDetails mirrors the server's description of a room, sent in reply to
+// /info and with every change to the room.
+type RoomDetails struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Name        string    `json:"name"`
+	Topic       string    `json:"topic,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	CreatedBy   string    `json:"created_by"`
+	Visibility  string    `json:"visibility"`
+	Settings    struct {
+		WhoCanPost     string `json:"who_can_post"`
+		WhoCanEditInfo string `json:"who_can_edit_info"`
+		MaxMembers     int    `json:"max_members"`
+	} `json:"settings"`
+	MemberCount int `json:"member_count"`
+	Members     []struct {
+		User string `json:"user"`
+		Role string `json:"role"`
+	} `json:"members,omitempty"`
+}
+
+// defaultServerURL is used when neither -server nor CHAT_SERVER is given.
+const defaultServerURL = "ws://localhost:8080"
+
+// serverURL is the base address of the chat server, set from -server (or
+// CHAT_SERVER) in main. The HTTP endpoints live under http:// (or https://)
+// and the WebSocket endpoint under ws:// (or wss://) on the same host.
+var serverURL = defaultServerURL
+
+// usage prints the command-line synopsis.
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  go run ./cmd/client signup <username>   - create an account and log in")
+	fmt.Println("  go run ./cmd/client login <username>    - log in to an existing account")
+	fmt.Println("  go run ./cmd/client <username>          - chat as a logged-in user")
+	fmt.Println()
+	fmt.Println("Options (before the command):")
+	flag.PrintDefaults()
 }
 
 // main is the entry point for the chat client. It connects to the server,
@@ -80,17 +176,26 @@ type Message struct {
 //  4. Run the main event loop in the foreground
 //  5. Clean up with defer statements
 func main() {
-	// LEARNING POINT — os.Args:
-	// os.Args is a []string slice. os.Args[0] is the program name, and
-	// os.Args[1:] are the user-provided arguments. Unlike flags.Parse(),
-	// this is manual argument handling — suitable for simple CLIs with
-	// one or two positional arguments. For complex CLIs, use the "flag"
-	// package or third-party libraries like cobra or urfave/cli.
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run cmd/client/main.go <username>")
+	// LEARNING POINT — flag.Args:
+	// flag.Parse consumes the options at the start of the command line
+	// ("-ping-interval 10s") and leaves the positional arguments — the
+	// subcommand and username — in flag.Args(). Options must come before the
+	// positional arguments. For complex CLIs with nested subcommands,
+	// third-party libraries like cobra or urfave/cli are popular.
+	if env := os.Getenv("CHAT_SERVER"); env != "" {
+		serverURL = env
+	}
+	flag.StringVar(&serverURL, "server", serverURL, "server base URL, ws:// or wss:// (env CHAT_SERVER)")
+	pingInterval := flag.Duration("ping-interval", defaultPingInterval, "how often to ping the server (0 disables heartbeats)")
+	pingTimeout := flag.Duration("ping-timeout", defaultPingTimeout, "how long to wait for the server's pong")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	if len(args) < 1 {
+		usage()
 		return
 	}
-	username := os.Args[1]
 
 	// LEARNING POINT — context.Background():
 	// context.Background() returns an empty, non-nil context. It's the
@@ -100,13 +205,41 @@ func main() {
 	// from this one (or from an HTTP request context).
 	ctx := context.Background()
 
+	// "signup" and "login" are one-shot subcommands that store a token and
+	// exit. Anything else is a username to chat as.
+	switch args[0] {
+	case "signup", "login":
+		if len(args) < 2 {
+			usage()
+			return
+		}
+		if err := runAccountCommand(ctx, args[0], args[1]); err != nil {
+			log.Fatalf("%s failed: %v", args[0], err)
+		}
+		return
+	}
+	username := args[0]
+
+	// The server only accepts WebSocket connections that present a token it
+	// issued, so use the one saved by the last signup or login.
+	token, err := loadToken(username)
+	if err != nil {
+		log.Fatalf("no saved login for %s (run: go run ./cmd/client login %s): %v", username, username, err)
+	}
+
 	// LEARNING POINT — WebSocket Dial:
 	// websocket.Dial initiates a WebSocket connection. It performs the HTTP
 	// upgrade handshake and returns a *websocket.Conn. The second return
-	// value (*http.Response) contains the server's upgrade response — we
-	// discard it here with _ since we don't need the response headers.
-	url := "ws://localhost:8080/ws?user=" + username
-	c, _, err := websocket.Dial(ctx, url, nil)
+	// value (*http.Response) contains the server's upgrade response, which we
+	// use to tell an expired or revoked login apart from a network failure.
+	// DialOptions.HTTPHeader adds headers to that upgrade request, which is
+	// how the token reaches the server.
+	c, resp, err := websocket.Dial(ctx, serverURL+"/ws", &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": []string{"Bearer " + token}},
+	})
+	if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		log.Fatalf("server rejected the saved login for %s (%s); run: go run ./cmd/client login %s", username, resp.Status, username)
+	}
 	if err != nil {
 		// log.Fatalf logs the error message and immediately exits with
 		// status code 1. Use it for fatal startup errors where continuing
@@ -124,9 +257,39 @@ func main() {
 	fmt.Printf("Connected to server as %s\n", username)
 	fmt.Println("Commands:")
 	fmt.Println("  <recipient> <message>          - send direct message")
-	fmt.Println("  /create <room>                 - create a chat room")
+	fmt.Println("  /create <room> [public]        - create a chat room (private unless public)")
+	fmt.Println("  /channel <room> [public]       - create a channel, where only admins post")
+	fmt.Println("  /follow <room>                 - subscribe to a public channel")
+	fmt.Println("  /unfollow <room>               - unsubscribe from a channel")
+	fmt.Println("  /rooms                         - list your rooms and public rooms")
+	fmt.Println("  /visibility <room> <mode>      - make a room public or private")
+	fmt.Println("  /info <room>                   - show a room's name, topic, settings and members")
+	fmt.Println("  /set <room> <field> <value>    - change name, topic, description, post, edit-info or max-members")
 	fmt.Println("  /invite <room> <user>          - invite user to a room")
+	fmt.Println("  /accept <room>                 - accept an invitation to a room")
+	fmt.Println("  /decline <room>                - decline an invitation to a room")
+	fmt.Println("  /revoke <room> <user>          - withdraw an invitation you sent")
+	fmt.Println("  /link <room> [uses] [expiry]   - make an invite link, e.g. /link devteam 1 2h")
+	fmt.Println("  /unlink <code>                 - cancel an invite link")
+	fmt.Println("  /join <code|room>              - join with an invite link, or join a public room")
 	fmt.Println("  /room <room> <message>         - send message to a room")
+	fmt.Println("  /leave <room>                  - leave a room")
+	fmt.Println("  /kick <room> <user>            - remove a member from a room (admins)")
+	fmt.Println("  /promote <room> <user>         - make a member an admin (owner)")
+	fmt.Println("  /demote <room> <user>          - make an admin a plain member (owner)")
+	fmt.Println("  /transfer <room> <user>        - hand ownership of a room to a member (owner)")
+	fmt.Println("  /delete <room>                 - delete a room (owner)")
+	fmt.Println("  /lists                         - show your broadcast lists")
+	fmt.Println("  /list new <list> [users...]    - create a broadcast list")
+	fmt.Println("  /list add <list> <users...>    - add contacts to a broadcast list")
+	fmt.Println("  /list remove <list> <users...> - take contacts off a broadcast list")
+	fmt.Println("  /list delete <list>            - delete a broadcast list")
+	fmt.Println("  /broadcast <list> <message>    - send a message to everyone on a list, one by one")
+	fmt.Println("  /ping                          - measure round-trip time to the server")
+
+	if *pingInterval > 0 {
+		go heartbeat(ctx, c, *pingInterval, *pingTimeout)
+	}
 
 	// LEARNING POINT — Goroutines:
 	// "go func() { ... }()" launches a new goroutine — a lightweight thread
@@ -145,6 +308,11 @@ func main() {
 	// by reference. This is the standard way to launch goroutines with access
 	// to local variables.
 	go func() {
+		// seen records the IDs of messages already shown, so a message the
+		// server delivers twice (for example, a queued message retried after
+		// a failed flush) is only printed once. Only this goroutine touches
+		// the map, so it needs no lock.
+		seen := make(map[string]bool)
 		for {
 			// Block until a message arrives from the server.
 			_, p, err := c.Read(ctx)
@@ -157,6 +325,27 @@ func main() {
 				log.Printf("Error decoding message: %v", err)
 				continue
 			}
+			// A message whose sender is us was typed on another of our
+			// devices; the server copies it here so every device shows the
+			// whole conversation.
+			mine := msg.Sender == username
+			if isChat(msg) && msg.ID != "" {
+				if seen[msg.ID] {
+					continue
+				}
+				seen[msg.ID] = true
+
+				// Printing the message below counts as reading it, so tell
+				// the server; it relays this to the sender as a read
+				// receipt. Writing from this goroutine while main writes
+				// user input is safe: websocket.Conn serializes writes.
+				// Our own messages need no receipt.
+				if !mine {
+					if err := send(ctx, c, Message{Type: "read", ID: msg.ID}); err != nil {
+						log.Printf("Error sending read receipt: %v", err)
+					}
+				}
+			}
 
 			// LEARNING POINT — switch without a Condition:
 			// Go's switch can match on any expression, not just a single
@@ -169,16 +358,42 @@ func main() {
 			// after printing the incoming message, since the message
 			// interrupts the user's typing line.
 			switch msg.Type {
+			case "ack":
+				fmt.Printf("\n  ✓ sent %s\n> ", describe(msg))
+			case "delivered":
+				fmt.Printf("\n  ✓✓ delivered %s\n> ", describe(msg))
+			case "read":
+				fmt.Printf("\n  ✓✓ read by %s %s\n> ", msg.Sender, describe(msg))
 			case "room_msg":
-				fmt.Printf("\n[%s][%s]: %s\n> ", msg.Room, msg.Sender, msg.Content)
-			case "room_created", "invite_sent":
+				fmt.Printf("\n%s [%s][%s]: %s\n> ", clock(msg), msg.Room, msg.Sender, msg.Content)
+			case "room_created", "invite_sent", "queued":
 				fmt.Printf("\n[server]: %s\n> ", msg.Content)
+			case "room_list":
+				printRooms(msg.Rooms)
+			case "room_info":
+				printRoomInfo(msg.Details)
+			case "lists":
+				printLists(msg.Lists)
+			case "list_updated", "list_deleted", "broadcast_sent":
+				fmt.Printf("\n[server]: %s\n> ", msg.Content)
+			case "join_code":
+				fmt.Printf("\n[server]: invite link for %s: %s (%s) — others can join with /join %s\n> ",
+					msg.Room, msg.Content, describeJoinCode(msg), msg.Content)
 			case "invited":
+				fmt.Printf("\n[server]: %s — /accept %s or /decline %s\n> ", msg.Content, msg.Room, msg.Room)
+			case "missed_messages", "server_shutdown", "room_deleted", "member_joined", "member_left", "member_removed",
+				"role_changed", "invite_declined", "invite_revoked", "join_code_revoked", "room_updated":
 				fmt.Printf("\n[server]: %s\n> ", msg.Content)
+			case "announcement":
+				fmt.Printf("\n[announcement]: %s\n> ", msg.Content)
 			case "error":
 				fmt.Printf("\n[error]: %s\n> ", msg.Content)
 			default:
-				fmt.Printf("\n[%s]: %s\n> ", msg.Sender, msg.Content)
+				if mine {
+					fmt.Printf("\n%s [me → %s]: %s\n> ", clock(msg), msg.Recipient, msg.Content)
+					continue
+				}
+				fmt.Printf("\n%s [%s]: %s\n> ", clock(msg), msg.Sender, msg.Content)
 			}
 		}
 	}()
@@ -192,6 +407,7 @@ func main() {
 	fmt.Print("> ")
 	for scanner.Scan() {
 		line := scanner.Text()
+		command, args, _ := strings.Cut(line, " ")
 		var msg Message
 
 		// LEARNING POINT — switch with Conditions (Expression Switch):
@@ -205,18 +421,118 @@ func main() {
 		// string starts with a given prefix. TrimPrefix removes the prefix.
 		// Using these together is a common pattern for simple command parsing.
 		switch {
-		case strings.HasPrefix(line, "/create "):
-			roomName := strings.TrimPrefix(line, "/create ")
-			roomName = strings.TrimSpace(roomName)
-			if roomName == "" {
-				fmt.Println("Usage: /create <room>")
+		case line == "/ping":
+			rtt, err := ping(ctx, c, *pingTimeout)
+			if err != nil {
+				fmt.Printf("[error]: ping failed: %v\n> ", err)
+			} else {
+				fmt.Printf("[server]: pong in %v\n> ", rtt.Round(time.Microsecond))
+			}
+			continue
+
+		case command == "/create", command == "/channel":
+			parts := strings.Fields(args)
+			if len(parts) < 1 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "public") {
+				fmt.Printf("Usage: %s <room> [public]\n", command)
 				fmt.Print("> ")
 				continue
 			}
 			msg = Message{
 				Type:    "create_room",
 				Sender:  username,
-				Content: roomName,
+				Content: parts[0],
+			}
+			if len(parts) == 2 {
+				msg.Visibility = "public"
+			}
+			if command == "/channel" {
+				msg.RoomType = "channel"
+			}
+
+		case line == "/lists":
+			msg = Message{Type: "get_lists", Sender: username}
+
+		case command == "/list":
+			// "/list <action> <list> [users...]"
+			parts := strings.Fields(args)
+			if len(parts) < 2 || listCommands[parts[0]] == "" ||
+				(len(parts) == 2 && (parts[0] == "add" || parts[0] == "remove")) ||
+				(len(parts) > 2 && parts[0] == "delete") {
+				fmt.Println("Usage: /list <new|add|remove|delete> <list> [users...]")
+				fmt.Print("> ")
+				continue
+			}
+			msg = Message{
+				Type:       listCommands[parts[0]],
+				Sender:     username,
+				List:       parts[1],
+				Recipients: parts[2:],
+			}
+
+		case command == "/broadcast":
+			parts := strings.SplitN(args, " ", 2)
+			if len(parts) < 2 || parts[1] == "" {
+				fmt.Println("Usage: /broadcast <list> <message>")
+				fmt.Print("> ")
+				continue
+			}
+			msg = Message{
+				Type:    "broadcast",
+				Sender:  username,
+				List:    parts[0],
+				Content: parts[1],
+			}
+
+		case line == "/rooms":
+			msg = Message{Type: "list_rooms", Sender: username}
+
+		case command == "/visibility":
+			parts := strings.Fields(args)
+			if len(parts) != 2 {
+				fmt.Println("Usage: /visibility <room> <public|private>")
+				fmt.Print("> ")
+				continue
+			}
+			msg = Message{
+				Type:       "set_visibility",
+				Sender:     username,
+				Room:       parts[0],
+				Visibility: parts[1],
+			}
+
+		case command == "/set":
+			// The value is the rest of the line, so topics and descriptions
+			// can contain spaces. Leaving it out clears the topic or
+			// description.
+			parts := strings.SplitN(args, " ", 3)
+			var field string
+			if len(parts) >= 2 {
+				field = roomFields[parts[1]]
+			}
+			clearable := field == "topic" || field == "description"
+			if field == "" || (len(parts) == 2 && !clearable) {
+				fmt.Println("Usage: /set <room> <name|topic|description|post|edit-info|max-members> <value>")
+				fmt.Print("> ")
+				continue
+			}
+			var value any = ""
+			if len(parts) == 3 {
+				value = parts[2]
+			}
+			if field == "max_members" {
+				n, err := strconv.Atoi(parts[2])
+				if err != nil {
+					fmt.Println("max-members must be a number (0 for no limit)")
+					fmt.Print("> ")
+					continue
+				}
+				value = n
+			}
+			msg = Message{
+				Type:   "update_room",
+				Sender: username,
+				Room:   parts[0],
+				Update: map[string]any{field: value},
 			}
 
 		case strings.HasPrefix(line, "/invite "):
@@ -252,6 +568,87 @@ func main() {
 				Content: parts[1],
 			}
 
+		case command == "/link":
+			// "/link <room> [uses] [expiry]": uses defaults to unlimited and
+			// expiry (a duration such as 30m or 48h) to the server's choice.
+			parts := strings.Fields(args)
+			if len(parts) < 1 || len(parts) > 3 {
+				fmt.Println("Usage: /link <room> [uses] [expiry]")
+				fmt.Print("> ")
+				continue
+			}
+			msg = Message{
+				Type:   "create_join_code",
+				Sender: username,
+				Room:   parts[0],
+			}
+			var err error
+			if len(parts) > 1 {
+				msg.MaxUses, err = strconv.Atoi(parts[1])
+			}
+			if err == nil && len(parts) > 2 {
+				var d time.Duration
+				d, err = time.ParseDuration(parts[2])
+				msg.ExpiresIn = int(d.Seconds())
+			}
+			if err != nil {
+				fmt.Printf("[error]: %v\n", err)
+				fmt.Print("> ")
+				continue
+			}
+
+		case command == "/join" || command == "/unlink":
+			code := strings.TrimSpace(args)
+			if code == "" {
+				fmt.Printf("Usage: %s <code>\n", command)
+				fmt.Print("> ")
+				continue
+			}
+			// /join takes either an invite link or the name of a public
+			// room; the server never lets a room name start like a link.
+			msgType := "join_by_code"
+			switch {
+			case command == "/unlink":
+				msgType = "revoke_join_code"
+			case !strings.HasPrefix(code, "inv-"):
+				msgType = "join_room"
+			}
+			msg = Message{
+				Type:    msgType,
+				Sender:  username,
+				Room:    code,
+				Content: code,
+			}
+
+		case roomCommands[command] != "":
+			// These all take just "<room>" and differ only in message type.
+			roomName := strings.TrimSpace(args)
+			if roomName == "" {
+				fmt.Printf("Usage: %s <room>\n", command)
+				fmt.Print("> ")
+				continue
+			}
+			msg = Message{
+				Type:   roomCommands[command],
+				Sender: username,
+				Room:   roomName,
+			}
+
+		case memberCommands[command] != "":
+			// These all take "<room> <user>" and differ only in message type.
+			parts := strings.Fields(args)
+			if len(parts) != 2 {
+				fmt.Printf("Usage: %s <room> <user>\n", command)
+				fmt.Print("> ")
+				continue
+			}
+			msg = Message{
+				Type:      memberCommands[command],
+				Sender:    username,
+				Room:      parts[0],
+				Recipient: parts[1],
+			}
+
 		default:
 			// Direct message (original behavior): "<recipient> <message>"
 			parts := strings.SplitN(line, " ", 2)
@@ -267,21 +664,9 @@ func main() {
 			}
 		}
 
-		// LEARNING POINT — json.Marshal:
-		// json.Marshal converts a Go struct to JSON bytes ([]byte). It uses the
-		// struct tags we defined on Message to determine the JSON field names.
-		// It returns ([]byte, error) — the error is non-nil if the struct contains
-		// types that can't be serialized to JSON (channels, functions, etc.).
-		p, err := json.Marshal(msg)
-		if err != nil {
-			log.Printf("Error encoding message: %v", err)
-			continue
-		}
-
-		// Write sends the JSON message over the WebSocket. If writing fails
-		// (server disconnected), we break out of the input loop.
-		err = c.Write(ctx, websocket.MessageText, p)
-		if err != nil {
+		// send encodes and writes the message. If writing fails (server
+		// disconnected), we break out of the input loop.
+		if err := send(ctx, c, msg); err != nil {
 			log.Printf("Error sending message: %v", err)
 			break
 		}
@@ -297,3 +682,184 @@ func main() {
 		log.Printf("Error reading from stdin: %v", err)
 	}
 }
+
+// roomCommands maps the commands that take just a room name to the message
+// type the server expects.
+var roomCommands = map[string]string{
+	"/leave":    "leave_room",
+	"/delete":   "delete_room",
+	"/accept":   "accept_invite",
+	"/decline":  "decline_invite",
+	"/info":     "room_info",
+	"/follow":   "follow",
+	"/unfollow": "unfollow",
+}
+
+// listCommands maps the actions /list accepts to the message type the
+// server expects.
+var listCommands = map[string]string{
+	"new":    "create_list",
+	"add":    "add_to_list",
+	"remove": "remove_from_list",
+	"delete": "delete_list",
+}
+
+// roomFields maps the field names /set accepts to the keys of the server's
+// "update_room" message.
+//
+// LEARNING POINT — map[string]any for Partial JSON:
+// The server only changes the fields an update mentions. Sending a
+// map[string]any with a single key produces exactly {"topic": "..."},
+// without having to mirror the server's struct of pointer fields.
+var roomFields = map[string]string{
+	"name":        "name",
+	"topic":       "topic",
+	"description": "description",
+	"post":        "who_can_post",
+	"edit-info":   "who_can_edit_info",
+	"max-members": "max_members",
+}
+
+// memberCommands maps the room commands that act on one member to the
+// message type the server expects.
+var memberCommands = map[string]string{
+	"/kick":     "kick",
+	"/promote":  "promote",
+	"/demote":   "demote",
+	"/transfer": "transfer_ownership",
+	"/revoke":   "revoke_invite",
+}
+
+// printRooms prints the reply to /rooms: one line per room, the user's own
+// rooms first.
+func printRooms(rooms []RoomSummary) {
+	if len(rooms) == 0 {
+		fmt.Print("\n[server]: no rooms yet — /create one\n> ")
+		return
+	}
+	fmt.Println()
+	for _, r := range rooms {
+		status, noun := "/join "+r.ID, "members"
+		if r.Type == "channel" {
+			status, noun = "/follow "+r.ID, "followers"
+		}
+		if r.Joined {
+			status = r.Role
+		}
+		fmt.Printf("  %-20s %-8s %-8s %4d %-9s  %s\n", r.ID, r.Type, r.Visibility, r.Members, noun, status)
+		if r.Name != r.ID {
+			fmt.Printf("    %s\n", r.Name)
+		}
+		if r.Topic != "" {
+			fmt.Printf("    %s\n", r.Topic)
+		}
+	}
+	fmt.Print("> ")
+}
+
+// printLists prints the reply to /lists.
+func printLists(lists []BroadcastList) {
+	if len(lists) == 0 {
+		fmt.Print("\n[server]: no broadcast lists yet — /list new <list> <users...>\n> ")
+		return
+	}
+	fmt.Println()
+	for _, l := range lists {
+		fmt.Printf("  %-20s %s\n", l.Name, strings.Join(l.Recipients, ", "))
+	}
+	fmt.Print("> ")
+}
+
+// printRoomInfo prints the reply to /info.
+func printRoomInfo(d *RoomDetails) {
+	if d == nil {
+		return
+	}
+	fmt.Printf("\n  %s (%s, %s %s)\n", d.Name, d.ID, d.Visibility, d.Type)
+	if d.Topic != "" {
+		fmt.Printf("  Topic: %s\n", d.Topic)
+	}
+	if d.Description != "" {
+		fmt.Printf("  %s\n", d.Description)
+	}
+	fmt.Printf("  Created by %s on %s\n", d.CreatedBy, d.CreatedAt.Local().Format(time.DateOnly))
+	limit := "no member limit"
+	if d.Settings.MaxMembers > 0 {
+		limit = fmt.Sprintf("at most %d members", d.Settings.MaxMembers)
+	}
+	fmt.Printf("  %s may post, %s may edit info, %s\n", d.Settings.WhoCanPost, d.Settings.WhoCanEditInfo, limit)
+	if d.Type == "channel" {
+		// Subscribers are counted, never listed; Members is just the admins.
+		fmt.Printf("  %d followers; run by", d.MemberCount)
+	} else {
+		fmt.Printf("  %d members", d.MemberCount)
+	}
+	for i, m := range d.Members {
+		if i == 0 {
+			fmt.Print(":")
+		}
+		fmt.Printf(" %s (%s)", m.User, m.Role)
+	}
+	fmt.Print("\n> ")
+}
+
+// describeJoinCode summarizes a join code's limits, e.g.
+// "single use, expires in 2h0m0s".
+func describeJoinCode(msg Message) string {
+	uses := "unlimited uses"
+	switch {
+	case msg.MaxUses == 1:
+		uses = "single use"
+	case msg.MaxUses > 1:
+		uses = fmt.Sprintf("%d uses", msg.MaxUses)
+	}
+	return fmt.Sprintf("%s, expires in %v", uses, time.Duration(msg.ExpiresIn)*time.Second)
+}
+
+// clock formats a message's server timestamp as local wall-clock time for
+// display. Messages without a timestamp show a blank placeholder of the same
+// width so columns stay aligned.
+func clock(msg Message) string {
+	if msg.Timestamp.IsZero() {
+		return "     "
+	}
+	return msg.Timestamp.Local().Format("15:04")
+}
+
+// send encodes msg as JSON and writes it to the server.
+//
+// LEARNING POINT — json.Marshal:
+// json.Marshal converts a Go struct to JSON bytes ([]byte). It uses the
+// struct tags we defined on Message to determine the JSON field names.
+// It returns ([]byte, error) — the error is non-nil if the struct contains
+// types that can't be serialized to JSON (channels, functions, etc.).
+func send(ctx context.Context, c *websocket.Conn, msg Message) error {
+	p, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.Write(ctx, websocket.MessageText, p)
+}
+
+// isChat reports whether msg is a chat message from another user (as opposed
+// to a server notice or receipt). Direct messages have no type.
+func isChat(msg Message) bool {
+	return msg.Type == "" || msg.Type == "room_msg"
+}
+
+// describe renders the target of a receipt, e.g. "to bob" or
+// "in devteam (3/5)", for the tick lines printed by the read loop.
+func describe(msg Message) string {
+	target := "to " + msg.Recipient
+	if msg.Room != "" {
+		target = "in " + msg.Room
+	}
+	if msg.Receipt == nil || msg.Room == "" {
+		return target
+	}
+	n := msg.Receipt.Delivered
+	if msg.Type == "read" {
+		n = msg.Receipt.Read
+	}
+	return fmt.Sprintf("%s (%d/%d)", target, n, msg.Receipt.Total)
+}
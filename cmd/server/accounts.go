@@ -0,0 +1,397 @@
+// This file implements the user registry: the list of accounts that may log
+// in, with their password hashes. It is persisted to a local JSON file so
+// accounts survive restarts.
+//
+// Passwords are never stored. Instead we store a salted PBKDF2-SHA256 hash,
+// and verifying a login means re-deriving the hash from the submitted
+// password and comparing.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - crypto/pbkdf2 for deliberately slow password hashing
+//   - Atomic file replacement (write a temp file, then os.Rename)
+//   - crypto/subtle.ConstantTimeCompare
+//   - Sentinel errors mapped to HTTP status codes
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHashIterations is the PBKDF2 work factor. OWASP recommends at
+	// least 600,000 iterations for PBKDF2-HMAC-SHA256; each login costs the
+	// server a few hundred milliseconds of CPU, and costs an attacker with a
+	// stolen accounts file the same for every single guess.
+	defaultHashIterations = 600_000
+
+	// minPasswordLength is the shortest password accepted at signup.
+	minPasswordLength = 8
+)
+
+var (
+	errUserExists         = errors.New("user already exists")
+	errInvalidUsername    = errors.New("username must be 1-32 letters, digits, '.', '_' or '-'")
+	errWeakPassword       = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	errInvalidCredentials = errors.New("invalid username or password")
+	errAccountDisabled    = errors.New("account is disabled")
+)
+
+// validUsername restricts usernames to characters that are safe everywhere a
+// user ID appears: URLs, log lines, file names and the CLI's space-separated
+// commands.
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// Account is one registered user as persisted in the accounts file.
+//
+// LEARNING POINT — []byte in JSON:
+// encoding/json encodes []byte fields as base64 strings automatically, so the
+// raw salt and hash bytes can be stored without any manual encoding.
+type Account struct {
+	User       string    `json:"user"`
+	Salt       []byte    `json:"salt"`
+	Hash       []byte    `json:"hash"`
+	Iterations int       `json:"iterations"`
+	Disabled   bool      `json:"disabled,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// UserRegistry holds every account and persists changes to a JSON file.
+type UserRegistry struct {
+	mu         sync.RWMutex
+	path       string
+	iterations int
+	accounts   map[string]*Account
+}
+
+// OpenUserRegistry loads the accounts file at path, creating an empty registry
+// if the file does not exist yet. An empty path gives a registry that lives
+// only in memory, which is what the tests use.
+func OpenUserRegistry(path string) (*UserRegistry, error) {
+	r := &UserRegistry{
+		path:       path,
+		iterations: defaultHashIterations,
+		accounts:   make(map[string]*Account),
+	}
+	if path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read accounts file: %w", err)
+	}
+	var accounts []*Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("decode accounts file: %w", err)
+	}
+	for _, a := range accounts {
+		r.accounts[a.User] = a
+	}
+	return r, nil
+}
+
+// Create registers a new account.
+func (r *UserRegistry) Create(user, password string) error {
+	if !validUsername.MatchString(user) {
+		return errInvalidUsername
+	}
+	if len(password) < minPasswordLength {
+		return errWeakPassword
+	}
+	salt, hash, err := r.hash(password)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.accounts[user]; exists {
+		return errUserExists
+	}
+	return r.put(&Account{
+		User:       user,
+		Salt:       salt,
+		Hash:       hash,
+		Iterations: r.iterations,
+		CreatedAt:  time.Now().UTC(),
+	})
+}
+
+// Authenticate checks a username and password. It returns
+// errInvalidCredentials for an unknown user or wrong password (without saying
+// which) and errAccountDisabled for a disabled account with the right
+// password.
+//
+// LEARNING POINT — Avoiding User Enumeration:
+// If unknown users failed instantly while known users paid for a slow hash,
+// an attacker could time responses to learn which usernames exist. Hashing
+// against a dummy salt in the unknown-user case makes both paths take the
+// same time.
+func (r *UserRegistry) Authenticate(user, password string) error {
+	r.mu.RLock()
+	acct, ok := r.accounts[user]
+	var a Account
+	if ok {
+		a = *acct
+	}
+	r.mu.RUnlock()
+
+	if !ok {
+		a = Account{Salt: make([]byte, 16), Iterations: r.iterations}
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, a.Salt, a.Iterations, 32)
+	if err != nil {
+		return err
+	}
+	if !ok || subtle.ConstantTimeCompare(hash, a.Hash) != 1 {
+		return errInvalidCredentials
+	}
+	if a.Disabled {
+		return errAccountDisabled
+	}
+	return nil
+}
+
+// ChangePassword replaces a user's password after verifying the current one.
+func (r *UserRegistry) ChangePassword(user, oldPassword, newPassword string) error {
+	if err := r.Authenticate(user, oldPassword); err != nil {
+		return err
+	}
+	if len(newPassword) < minPasswordLength {
+		return errWeakPassword
+	}
+	salt, hash, err := r.hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	acct, ok := r.accounts[user]
+	if !ok {
+		return errInvalidCredentials
+	}
+	updated := *acct
+	updated.Salt, updated.Hash, updated.Iterations = salt, hash, r.iterations
+	return r.put(&updated)
+}
+
+// Disable marks an account as disabled. Disabled users cannot log in or open
+// new connections, but their account (and username) is kept.
+func (r *UserRegistry) Disable(user string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	acct, ok := r.accounts[user]
+	if !ok {
+		return errInvalidCredentials
+	}
+	updated := *acct
+	updated.Disabled = true
+	return r.put(&updated)
+}
+
+// Active reports whether user has an account that is not disabled.
+func (r *UserRegistry) Active(user string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	acct, ok := r.accounts[user]
+	return ok && !acct.Disabled
+}
+
+// hash derives a new random salt and the PBKDF2 hash of password.
+func (r *UserRegistry) hash(password string) (salt, hash []byte, err error) {
+	salt = make([]byte, 16)
+	rand.Read(salt)
+	hash, err = pbkdf2.Key(sha256.New, password, salt, r.iterations, 32)
+	return salt, hash, err
+}
+
+// put stores acct, replacing any account with the same name, and saves the
+// registry. If the save fails the previous account (or none) is put back, so
+// memory never holds a change the file doesn't. The caller must hold r.mu.
+//
+// LEARNING POINT — Change Memory Only What Disk Accepted:
+// Mutating the stored *Account in place and then failing to save would leave
+// the two disagreeing until a restart: a "failed" signup would answer 409 on
+// retry, and a "failed" password change would quietly be in force. Callers
+// instead build the new Account as a copy and hand it here, where undoing is
+// just putting the old pointer back.
+func (r *UserRegistry) put(acct *Account) error {
+	prev, existed := r.accounts[acct.User]
+	r.accounts[acct.User] = acct
+	if err := r.save(); err != nil {
+		if existed {
+			r.accounts[acct.User] = prev
+		} else {
+			delete(r.accounts, acct.User)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes every account to the registry file. The caller must hold r.mu.
+func (r *UserRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	accounts := make([]*Account, 0, len(r.accounts))
+	for _, a := range r.accounts {
+		accounts = append(accounts, a)
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode accounts file: %w", err)
+	}
+	if err := writeFileAtomic(r.path, data); err != nil {
+		return fmt.Errorf("save accounts file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data. It is shared by
+// everything the server keeps in a JSON file (accounts, broadcast lists).
+//
+// LEARNING POINT — Atomic File Replacement:
+// Writing the file in place would leave a truncated, unreadable file if the
+// process died halfway through. Instead we write a temporary file in the
+// same directory and rename it over the old one. On POSIX systems rename is
+// atomic: readers see either the complete old file or the complete new one,
+// never a mix.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the rename has succeeded
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// credentialsRequest is the JSON body accepted by the account endpoints.
+// NewPassword is only used by POST /password.
+type credentialsRequest struct {
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password,omitempty"`
+}
+
+// decodeCredentials reads a credentialsRequest from the request body,
+// writing a 400 response and returning false if it is malformed.
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (credentialsRequest, bool) {
+	var req credentialsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil || req.User == "" {
+		http.Error(w, `request body must be JSON with "user" and "password"`, http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// accountError writes the HTTP response for an error from the registry.
+//
+// LEARNING POINT — Mapping Errors to Status Codes:
+// Handlers shouldn't each invent their own status codes. Funneling every
+// registry error through one switch keeps the API consistent: a wrong
+// password is always 401, a duplicate username is always 409, and anything
+// unexpected is a 500 whose details stay in the server log.
+func accountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidUsername), errors.Is(err, errWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errInvalidCredentials):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, errAccountDisabled):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errUserExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		slog.Error("account operation failed", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+// signupHandler creates an account and logs the new user in.
+func (s *Server) signupHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if err := s.users.Create(req.User, req.Password); err != nil {
+		accountError(w, err)
+		return
+	}
+	slog.Info("account created", "user", req.User)
+	s.writeToken(w, http.StatusCreated, req.User)
+}
+
+// loginHandler checks a username and password and issues a token.
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if err := s.users.Authenticate(req.User, req.Password); err != nil {
+		accountError(w, err)
+		return
+	}
+	s.writeToken(w, http.StatusOK, req.User)
+}
+
+// changePasswordHandler replaces a user's password. The current password is
+// required, so a stolen token alone is not enough to take over an account.
+func (s *Server) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if err := s.users.ChangePassword(req.User, req.Password, req.NewPassword); err != nil {
+		accountError(w, err)
+		return
+	}
+	slog.Info("password changed", "user", req.User)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// disableHandler disables the caller's own account after re-checking their
+// password.
+func (s *Server) disableHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if err := s.users.Authenticate(req.User, req.Password); err != nil {
+		accountError(w, err)
+		return
+	}
+	if err := s.users.Disable(req.User); err != nil {
+		accountError(w, err)
+		return
+	}
+	slog.Info("account disabled", "user", req.User)
+	w.WriteHeader(http.StatusNoContent)
+}
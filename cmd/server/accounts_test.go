@@ -0,0 +1,122 @@
+// This file contains unit tests for the user registry (defined in
+// accounts.go).
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestRegistry returns a registry persisted under a temporary directory,
+// with a minimal hash work factor so the tests stay fast.
+func newTestRegistry(t *testing.T, path string) *UserRegistry {
+	t.Helper()
+	r, err := OpenUserRegistry(path)
+	if err != nil {
+		t.Fatalf("open registry: %v", err)
+	}
+	r.iterations = 1
+	return r
+}
+
+// TestUserRegistryPersists verifies that accounts survive reopening the
+// registry file and that the file never contains the plaintext password.
+func TestUserRegistryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	r := newTestRegistry(t, path)
+	if err := r.Create("alice", "s3cret-password"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "s3cret-password") {
+		t.Error("accounts file contains the plaintext password")
+	}
+
+	r = newTestRegistry(t, path)
+	if err := r.Authenticate("alice", "s3cret-password"); err != nil {
+		t.Errorf("expected alice to authenticate after reopen, got %v", err)
+	}
+	if err := r.Authenticate("alice", "wrong-password"); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("expected errInvalidCredentials for wrong password, got %v", err)
+	}
+}
+
+// TestUserRegistryDisable verifies that a disabled account is inactive and
+// cannot authenticate, even with the right password.
+func TestUserRegistryDisable(t *testing.T) {
+	r := newTestRegistry(t, "")
+	r.Create("alice", "s3cret-password")
+
+	if !r.Active("alice") {
+		t.Fatal("expected new account to be active")
+	}
+	if err := r.Disable("alice"); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if r.Active("alice") {
+		t.Error("expected disabled account to be inactive")
+	}
+	if err := r.Authenticate("alice", "s3cret-password"); !errors.Is(err, errAccountDisabled) {
+		t.Errorf("expected errAccountDisabled, got %v", err)
+	}
+	if r.Active("nobody") {
+		t.Error("expected unknown user to be inactive")
+	}
+}
+
+// TestUserRegistryChangePassword verifies that changing a password requires
+// the old one and replaces it.
+func TestUserRegistryChangePassword(t *testing.T) {
+	r := newTestRegistry(t, "")
+	r.Create("alice", "old-password")
+
+	if err := r.ChangePassword("alice", "not-the-password", "new-password"); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("expected change with wrong password to fail, got %v", err)
+	}
+	if err := r.ChangePassword("alice", "old-password", "new-password"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if err := r.Authenticate("alice", "new-password"); err != nil {
+		t.Errorf("expected new password to work, got %v", err)
+	}
+}
+
+// TestUserRegistrySaveFailure verifies that a change the registry couldn't
+// save is not kept in memory either.
+func TestUserRegistrySaveFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	os.Mkdir(dir, 0o700)
+	r := newTestRegistry(t, filepath.Join(dir, "accounts.json"))
+	if err := r.Create("alice", "old-password"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// With the directory gone, the temporary file can't be created, so every
+	// save fails (even for root, which a read-only directory wouldn't stop).
+	os.RemoveAll(dir)
+	if err := r.Create("bob", "bobs-password"); err == nil {
+		t.Fatal("expected create to fail")
+	}
+	if err := r.ChangePassword("alice", "old-password", "new-password"); err == nil {
+		t.Error("expected change password to fail")
+	}
+	if err := r.Disable("alice"); err == nil {
+		t.Error("expected disable to fail")
+	}
+	if r.Active("bob") {
+		t.Error("expected bob not to exist after a failed signup")
+	}
+	if err := r.Authenticate("alice", "old-password"); err != nil {
+		t.Errorf("expected alice's old password to still work and her account to be active, got %v", err)
+	}
+
+	os.Mkdir(dir, 0o700)
+	if err := r.Create("bob", "bobs-password"); err != nil {
+		t.Errorf("expected retrying the signup to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+// This file implements the admin API: a small JSON-over-HTTP interface for
+// operators to see and manage the server's live state.
+//
+//	GET    /admin/users                          online users and their devices
+//	GET    /admin/connections                    every live connection
+//	GET    /admin/rooms                          rooms and their members
+//	GET    /admin/rooms/{room}                   one room
+//	POST   /admin/users/{user}/disconnect        close all of a user's connections
+//	DELETE /admin/rooms/{room}                   delete a room
+//	DELETE /admin/rooms/{room}/members/{user}    remove a member from a room
+//	POST   /admin/announce                       send {"content": "..."} to everyone
+//	GET    /admin/events                         live event feed (see events.go)
+//
+// cmd/admin is a command-line client for all of these.
+//
+// The API is served on its own listener (admin_addr), never on the public
+// one, so it can be bound to localhost or a private network and firewalled
+// separately. Every request must also carry the admin token:
+//
+//	Authorization: Bearer <admin_token>
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Middleware: wrapping an http.Handler to run code before every request
+//   - Go 1.22 ServeMux patterns with methods and {wildcards}, and
+//     Request.PathValue to read them
+//   - crypto/subtle for comparing secrets in constant time
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// errAdminDisconnect is the cancellation cause of a connection closed through
+// the admin API.
+var errAdminDisconnect = errors.New("disconnected by an administrator")
+
+// AdminRouter returns the handler for the admin listener. Every route
+// requires the admin token.
+//
+// LEARNING POINT — Method and Wildcard Patterns:
+// Since Go 1.22, a ServeMux pattern can name the HTTP method and contain
+// wildcards: "DELETE /admin/rooms/{room}" only matches DELETE requests, and
+// r.PathValue("room") returns whatever the {room} segment held. Requests
+// with the wrong method get a 405 automatically.
+func AdminRouter(s *Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/users", s.adminUsersHandler)
+	mux.HandleFunc("GET /admin/connections", s.adminConnectionsHandler)
+	mux.HandleFunc("GET /admin/rooms", s.adminRoomsHandler)
+	mux.HandleFunc("GET /admin/rooms/{room}", s.adminRoomHandler)
+	mux.HandleFunc("POST /admin/users/{user}/disconnect", s.adminDisconnectHandler)
+	mux.HandleFunc("DELETE /admin/rooms/{room}", s.adminDeleteRoomHandler)
+	mux.HandleFunc("DELETE /admin/rooms/{room}/members/{user}", s.adminRemoveMemberHandler)
+	mux.HandleFunc("POST /admin/announce", s.adminAnnounceHandler)
+	mux.HandleFunc("GET /admin/events", s.adminEventsHandler)
+	return s.requireAdmin(mux)
+}
+
+// requireAdmin rejects requests that don't carry the admin token.
+//
+// LEARNING POINT — Middleware:
+// A middleware is a function that takes a handler and returns a handler that
+// does something extra before (or after) calling it. Wrapping the whole mux
+// means no admin route can be added without authentication by mistake.
+//
+// LEARNING POINT — Constant-Time Comparison:
+// token == s.config.AdminToken stops at the first differing byte, so how long
+// it takes leaks how much of a guess was right. subtle.ConstantTimeCompare
+// always looks at every byte.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := adminToken(r)
+		if !ok || s.config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "a valid admin token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminToken returns the bearer token from the Authorization header. Unlike
+// user tokens (see bearerToken), the admin token is never accepted as a
+// query parameter, where it would end up in proxy logs and shell history.
+func adminToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// onlineUser describes a connected user for GET /admin/users.
+type onlineUser struct {
+	User    string           `json:"user"`
+	Devices []connectionInfo `json:"devices"`
+}
+
+// roomInfo describes a room for the admin API.
+type roomInfo struct {
+	ID         string     `json:"id"`
+	Type       RoomType   `json:"type"`
+	Name       string     `json:"name"`
+	Topic      string     `json:"topic,omitempty"`
+	Owner      string     `json:"owner"`
+	Admins     []string   `json:"admins,omitempty"`
+	Members    []string   `json:"members"`
+	Visibility Visibility `json:"visibility"`
+}
+
+func (s *Server) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users := []onlineUser{}
+	// connectionStats is sorted by user, so each user's devices are
+	// adjacent.
+	for _, info := range s.hub.connectionStats() {
+		if n := len(users); n > 0 && users[n-1].User == info.User {
+			users[n-1].Devices = append(users[n-1].Devices, info)
+			continue
+		}
+		users = append(users, onlineUser{User: info.User, Devices: []connectionInfo{info}})
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) adminConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.hub.connectionStats())
+}
+
+func (s *Server) adminRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.hub.roomList())
+}
+
+func (s *Server) adminRoomHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("room")
+	for _, room := range s.hub.roomList() {
+		if room.ID == name {
+			writeJSON(w, http.StatusOK, room)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("room %q does not exist", name), http.StatusNotFound)
+}
+
+// adminDisconnectHandler closes every connection the user has. It does not
+// stop them reconnecting; disable the account for that.
+func (s *Server) adminDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	n := s.hub.disconnect(user, errAdminDisconnect)
+	if n == 0 {
+		http.Error(w, fmt.Sprintf("%s is not connected", user), http.StatusNotFound)
+		return
+	}
+	slog.Info("admin disconnected user", "user", user, "connections", n)
+	s.hub.events.publish(Event{Type: "user_disconnected", User: user, Detail: errAdminDisconnect.Error()})
+	writeJSON(w, http.StatusOK, map[string]int{"disconnected": n})
+}
+
+// adminDeleteRoomHandler deletes a room and tells its connected members.
+func (s *Server) adminDeleteRoomHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("room")
+	members, errMsg := s.hub.deleteRoom(name)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+	s.notifyMembers(members, Message{
+		Type:    "room_deleted",
+		Sender:  "server",
+		Room:    name,
+		Content: fmt.Sprintf("room %q was deleted by an administrator", name),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminRemoveMemberHandler removes a user from a room and tells them and the
+// members who remain, and who took over if the user owned the room.
+func (s *Server) adminRemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	name, user := r.PathValue("room"), r.PathValue("user")
+	remaining, succ, errMsg := s.hub.removeFromRoom(name, user)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+	s.notifyMembers(append(remaining, user), Message{
+		Type:      "member_removed",
+		Sender:    "server",
+		Recipient: user,
+		Room:      name,
+		Content:   fmt.Sprintf("%s was removed from room %q by an administrator", user, name),
+	})
+	s.announceSuccession(name, succ)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// announceRequest is the JSON body of POST /admin/announce.
+type announceRequest struct {
+	Content string `json:"content"`
+}
+
+// adminAnnounceHandler sends a system announcement to every connected
+// device. Users who are offline do not get it later.
+func (s *Server) adminAnnounceHandler(w http.ResponseWriter, r *http.Request) {
+	var req announceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil || req.Content == "" {
+		http.Error(w, `request body must be JSON with "content"`, http.StatusBadRequest)
+		return
+	}
+	n := s.hub.broadcast(Message{Type: "announcement", Sender: "server", Content: req.Content})
+	slog.Info("admin announcement sent", "connections", n)
+	s.hub.events.publish(Event{Type: "announcement", Detail: req.Content})
+	writeJSON(w, http.StatusOK, map[string]int{"delivered": n})
+}
+
+// writeJSON writes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// roomList returns every room with its members, both sorted by name.
+func (h *Hub) roomList() []roomInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rooms := make([]roomInfo, 0, len(h.rooms))
+	for id, room := range h.rooms {
+		info := roomInfo{
+			ID:         id,
+			Type:       room.Type,
+			Name:       room.Name,
+			Topic:      room.Topic,
+			Owner:      room.owner(),
+			Admins:     room.admins(),
+			Members:    memberList(room),
+			Visibility: room.Visibility,
+		}
+		sort.Strings(info.Admins)
+		sort.Strings(info.Members)
+		rooms = append(rooms, info)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms
+}
+
+// disconnect stops every connection a user has, with cause as the reason,
+// and reports how many there were. Each connection's writer closes its
+// socket, which ends its read loop and unregisters it (see finish).
+func (h *Hub) disconnect(user string, cause error) int {
+	conns := h.devices(user)
+	for _, conn := range conns {
+		conn.cancel(cause)
+	}
+	return len(conns)
+}
+
+// broadcast queues msg for every connected device and reports how many
+// accepted it.
+func (h *Hub) broadcast(msg Message) int {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("marshal message", "type", msg.Type, "err", err)
+		return 0
+	}
+	h.mu.RLock()
+	var conns []*connection
+	for id := range h.clients {
+		conns = append(conns, h.snapshot(id)...)
+	}
+	h.mu.RUnlock()
+	return deliver(conns, outbound{data: data, kind: msg.Type}, nil)
+}
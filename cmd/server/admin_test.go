@@ -0,0 +1,164 @@
+// This file contains tests for the admin API (defined in admin.go). Requests
+// go through AdminRouter with httptest.NewRecorder, while the users whose
+// state is being inspected are connected to the public router over real
+// WebSockets.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// testAdminToken is the admin token newAdminTestServer configures.
+const testAdminToken = "admin-token-for-tests"
+
+// newAdminTestServer returns a test server with the admin token set, plus a
+// public httptest server for WebSocket clients.
+func newAdminTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	s := newTestServer()
+	s.config.AdminToken = testAdminToken
+	server := httptest.NewServer(SetupRouter(s))
+	t.Cleanup(server.Close)
+	return s, strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+}
+
+// adminDo sends an authenticated request through the admin router.
+func adminDo(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rr := httptest.NewRecorder()
+	AdminRouter(s).ServeHTTP(rr, req)
+	return rr
+}
+
+// TestAdminRequiresToken verifies that every way of not presenting the admin
+// token is refused.
+func TestAdminRequiresToken(t *testing.T) {
+	s, _ := newAdminTestServer(t)
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"no token", httptest.NewRequest("GET", "/admin/users", nil)},
+		{"wrong token", httptest.NewRequest("GET", "/admin/users", nil)},
+		{"token in query", httptest.NewRequest("GET", "/admin/users?token="+testAdminToken, nil)},
+	}
+	tests[1].req.Header.Set("Authorization", "Bearer not-the-admin-token")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			AdminRouter(s).ServeHTTP(rr, tt.req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("expected 401, got %d", rr.Code)
+			}
+		})
+	}
+
+	// With no admin token configured, nothing is accepted, not even an
+	// empty bearer token.
+	s.config.AdminToken = ""
+	req := httptest.NewRequest("GET", "/admin/users", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rr := httptest.NewRecorder()
+	AdminRouter(s).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a configured token, got %d", rr.Code)
+	}
+}
+
+// TestAdminInspectAndManage walks through the admin API: listing users and
+// rooms, removing a member, announcing, deleting a room and disconnecting a
+// user.
+func TestAdminInspectAndManage(t *testing.T) {
+	s, wsURL := newAdminTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.CloseNow()
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.CloseNow()
+
+	for _, msg := range []Message{
+		{Type: "create_room", Content: "general"},
+		{Type: "invite", Room: "general", Recipient: "bob"},
+	} {
+		data, _ := json.Marshal(msg)
+		alice.Write(ctx, websocket.MessageText, data)
+	}
+	readUntil(t, ctx, alice, "invite_sent")
+	readUntil(t, ctx, bob, "invited")
+	data, _ := json.Marshal(Message{Type: "accept_invite", Room: "general"})
+	bob.Write(ctx, websocket.MessageText, data)
+	readUntil(t, ctx, bob, "member_joined")
+
+	var users []onlineUser
+	json.Unmarshal(adminDo(s, "GET", "/admin/users", "").Body.Bytes(), &users)
+	if len(users) != 2 || users[0].User != "alice" || len(users[0].Devices) != 1 || users[1].User != "bob" {
+		t.Errorf("unexpected users %+v", users)
+	}
+
+	var room roomInfo
+	json.Unmarshal(adminDo(s, "GET", "/admin/rooms/general", "").Body.Bytes(), &room)
+	if strings.Join(room.Members, ",") != "alice,bob" || room.Owner != "alice" {
+		t.Errorf("unexpected room %+v", room)
+	}
+	if rr := adminDo(s, "GET", "/admin/rooms/nope", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown room, got %d", rr.Code)
+	}
+
+	if rr := adminDo(s, "DELETE", "/admin/rooms/general/members/bob", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("remove member: %d %s", rr.Code, rr.Body)
+	}
+	if got := readUntil(t, ctx, bob, "member_removed"); got.Room != "general" {
+		t.Errorf("expected bob to be told which room, got %+v", got)
+	}
+	if members := s.hub.getRoomMembers("general", "alice"); len(members) != 1 {
+		t.Errorf("expected only alice left in the room, got %v", members)
+	}
+
+	if rr := adminDo(s, "POST", "/admin/announce", `{"content":"maintenance at noon"}`); rr.Code != http.StatusOK {
+		t.Fatalf("announce: %d %s", rr.Code, rr.Body)
+	}
+	if got := readUntil(t, ctx, bob, "announcement"); got.Content != "maintenance at noon" {
+		t.Errorf("unexpected announcement %+v", got)
+	}
+
+	if rr := adminDo(s, "DELETE", "/admin/rooms/general", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete room: %d %s", rr.Code, rr.Body)
+	}
+	readUntil(t, ctx, alice, "room_deleted")
+	if rooms := s.hub.roomList(); len(rooms) != 0 {
+		t.Errorf("expected no rooms, got %+v", rooms)
+	}
+
+	if rr := adminDo(s, "POST", "/admin/users/bob/disconnect", ""); rr.Code != http.StatusOK {
+		t.Fatalf("disconnect: %d %s", rr.Code, rr.Body)
+	}
+	for {
+		if _, _, err = bob.Read(ctx); err != nil {
+			break
+		}
+	}
+	if status := websocket.CloseStatus(err); status != websocket.StatusPolicyViolation {
+		t.Errorf("expected StatusPolicyViolation, got %v (%v)", status, err)
+	}
+	eventually(t, "bob to be unregistered", func() bool { return s.hub.devices("bob") == nil })
+	if rr := adminDo(s, "POST", "/admin/users/bob/disconnect", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 disconnecting an offline user, got %d", rr.Code)
+	}
+}
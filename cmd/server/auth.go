@@ -0,0 +1,174 @@
+// This file implements signed, expiring access tokens. A client logs in over
+// plain HTTP (POST /login, see accounts.go), receives a token, and presents
+// it when upgrading to a WebSocket. The server then knows who is on the other
+// end of every connection without trusting anything the client claims later.
+//
+// Tokens are HMAC-signed rather than stored: any server holding the secret
+// can verify one offline, with no session table and no database lookup.
+//
+// Token format (both parts base64url-encoded, without padding):
+//
+//	<claims JSON>.<HMAC-SHA256 of the encoded claims>
+//
+// This is the same idea as a JWT, minus the header and the algorithm
+// negotiation that JWT libraries have historically gotten wrong.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - crypto/hmac and crypto/sha256 for message authentication
+//   - hmac.Equal for constant-time comparison
+//   - Sentinel errors created with errors.New and checked with errors.Is
+//   - encoding/base64's URL-safe, unpadded encoding
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultTokenTTL is how long an issued token stays valid.
+const defaultTokenTTL = 24 * time.Hour
+
+// LEARNING POINT — Sentinel Errors:
+// Package-level error values let callers test for a specific failure with
+// errors.Is(err, errTokenExpired) instead of comparing message strings. They
+// are created once, so identity comparison works even after wrapping.
+var (
+	errTokenMalformed = errors.New("malformed token")
+	errTokenSignature = errors.New("invalid token signature")
+	errTokenExpired   = errors.New("token expired")
+)
+
+// tokenClaims is the signed payload of a token.
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// TokenIssuer creates and verifies access tokens with a shared secret.
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewTokenIssuer creates a TokenIssuer that signs with secret and issues
+// tokens valid for ttl.
+func NewTokenIssuer(secret []byte, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{secret: secret, ttl: ttl, now: time.Now}
+}
+
+// randomSecret returns a fresh 256-bit signing secret. It is used when no
+// secret is configured; tokens signed with it stop working when the server
+// restarts.
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
+
+// b64 is the encoding used for both halves of a token. URL-safe so tokens can
+// travel in a query string; unpadded so there are no '=' characters to escape.
+var b64 = base64.RawURLEncoding
+
+// Issue returns a signed token for user and the time it expires.
+func (ti *TokenIssuer) Issue(user string) (string, time.Time, error) {
+	now := ti.now()
+	exp := now.Add(ti.ttl)
+	payload, err := json.Marshal(tokenClaims{
+		Subject:   user,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: exp.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	encoded := b64.EncodeToString(payload)
+	return encoded + "." + b64.EncodeToString(ti.sign(encoded)), exp, nil
+}
+
+// Verify checks a token's signature and expiry and returns the user it was
+// issued to.
+//
+// LEARNING POINT — Constant-Time Comparison:
+// Comparing signatures with bytes.Equal would return as soon as the first
+// byte differs, and an attacker measuring response times could use that to
+// forge a signature one byte at a time. hmac.Equal always looks at every
+// byte, so its running time reveals nothing about where the mismatch is.
+func (ti *TokenIssuer) Verify(token string) (string, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errTokenMalformed
+	}
+	gotSig, err := b64.DecodeString(sig)
+	if err != nil {
+		return "", errTokenMalformed
+	}
+	if !hmac.Equal(gotSig, ti.sign(encoded)) {
+		return "", errTokenSignature
+	}
+
+	// Only decode the claims after the signature checks out, so we never
+	// parse attacker-controlled JSON.
+	payload, err := b64.DecodeString(encoded)
+	if err != nil {
+		return "", errTokenMalformed
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return "", errTokenMalformed
+	}
+	if ti.now().Unix() >= claims.ExpiresAt {
+		return "", errTokenExpired
+	}
+	return claims.Subject, nil
+}
+
+// sign computes the HMAC-SHA256 of the encoded claims.
+func (ti *TokenIssuer) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, ti.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// bearerToken extracts the token from a request. It accepts the standard
+// "Authorization: Bearer <token>" header, and falls back to a "token" query
+// parameter because browsers cannot set headers on WebSocket requests.
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return token
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// loginResponse is the JSON body returned by a successful signup or login.
+type loginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// writeToken issues a token for user and writes it as the JSON response.
+//
+// LEARNING POINT — json.NewEncoder:
+// json.NewEncoder(w).Encode(v) streams JSON straight into the response
+// writer, with no intermediate []byte. Headers must be set before the first
+// write (WriteHeader or Encode), because that is when they are sent.
+func (s *Server) writeToken(w http.ResponseWriter, status int, user string) {
+	token, exp, err := s.tokens.Issue(user)
+	if err != nil {
+		http.Error(w, "could not issue token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(loginResponse{Token: token, ExpiresAt: exp})
+}
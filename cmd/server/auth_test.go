@@ -0,0 +1,78 @@
+// This file contains unit tests for token issuing and verification (defined
+// in auth.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - errors.Is for asserting on sentinel errors
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTokenRoundTrip verifies that an issued token verifies to the same user.
+func TestTokenRoundTrip(t *testing.T) {
+	ti := NewTokenIssuer([]byte("secret"), time.Hour)
+	token, exp, err := ti.Issue("alice")
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if !exp.After(time.Now()) {
+		t.Errorf("expected expiry in the future, got %v", exp)
+	}
+
+	user, err := ti.Verify(token)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if user != "alice" {
+		t.Errorf("expected alice, got %q", user)
+	}
+}
+
+// TestTokenRejected verifies the failure modes of Verify.
+//
+// LEARNING POINT — Asserting on Sentinel Errors:
+// errors.Is checks whether an error is (or wraps) a specific sentinel value.
+// Tests that match on err.Error() text break whenever a message is reworded;
+// tests that use errors.Is only break when the behavior changes.
+func TestTokenRejected(t *testing.T) {
+	ti := NewTokenIssuer([]byte("secret"), time.Hour)
+	token, _, _ := ti.Issue("alice")
+
+	other := NewTokenIssuer([]byte("different-secret"), time.Hour)
+	forged, _, _ := other.Issue("alice")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"empty", "", errTokenMalformed},
+		{"no signature", "abc", errTokenMalformed},
+		{"wrong secret", forged, errTokenSignature},
+		{"tampered claims", "x" + token, errTokenSignature},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ti.Verify(tc.token); !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// TestTokenExpired verifies that a token stops working after its TTL.
+func TestTokenExpired(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ti := NewTokenIssuer([]byte("secret"), time.Hour)
+	ti.now = func() time.Time { return now }
+
+	token, _, _ := ti.Issue("alice")
+	now = now.Add(2 * time.Hour)
+
+	if _, err := ti.Verify(token); !errors.Is(err, errTokenExpired) {
+		t.Errorf("expected errTokenExpired, got %v", err)
+	}
+}
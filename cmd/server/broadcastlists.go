@@ -0,0 +1,272 @@
+// This file implements broadcast lists: named lists of contacts that a user
+// keeps for themselves, to send one message to many people at once.
+//
+// Unlike a room, a broadcast list is private to its owner. The people on it
+// don't know it exists and don't see each other: a "broadcast" is delivered
+// as a separate direct message to each of them, exactly as if it had been
+// typed once per person, so each reply comes back in an ordinary one-to-one
+// conversation.
+//
+// Lists are managed with "create_list", "add_to_list", "remove_from_list"
+// and "delete_list" (List, and Recipients for the contacts), and listed with
+// "get_lists". Like accounts, they are kept in a JSON file (see
+// broadcast_lists_path in config.go) so they survive restarts.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Keeping a slice sorted and duplicate-free with slices.Sort and
+//     slices.Compact
+//   - Reusing an existing handler rather than duplicating its logic
+//   - Undoing an in-memory change when it can't be saved
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxBroadcastLists is how many lists one user may have.
+	maxBroadcastLists = 50
+
+	// maxBroadcastRecipients is how many contacts one list may hold.
+	maxBroadcastRecipients = 256
+
+	// maxBroadcastListName is the longest a list name may be, in characters.
+	maxBroadcastListName = 32
+)
+
+// BroadcastList is one of a user's lists, as sent in "lists" and
+// "list_updated" replies.
+type BroadcastList struct {
+	Name       string   `json:"name"`
+	Recipients []string `json:"recipients"`
+}
+
+// errListsNotSaved is the message users get when a change to their lists
+// couldn't be written to disk (and so was not made).
+const errListsNotSaved = "your lists could not be saved; please try again later"
+
+// broadcastListStore holds every user's broadcast lists and persists changes
+// to a JSON file, written the same way as the accounts file.
+type broadcastListStore struct {
+	mu    sync.Mutex
+	path  string
+	lists map[string]map[string][]string // owner -> list name -> recipients, sorted
+}
+
+// newBroadcastListStore returns an empty store that lives only in memory,
+// which is what NewHub and the tests use.
+func newBroadcastListStore() *broadcastListStore {
+	return &broadcastListStore{lists: make(map[string]map[string][]string)}
+}
+
+// openBroadcastListStore loads the lists file at path, starting empty if the
+// file does not exist yet.
+func openBroadcastListStore(path string) (*broadcastListStore, error) {
+	s := newBroadcastListStore()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read broadcast lists file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.lists); err != nil {
+		return nil, fmt.Errorf("decode broadcast lists file: %w", err)
+	}
+	return s, nil
+}
+
+// checkListName returns an error message string unless name can be used for
+// a list. Names can't contain spaces, so "/broadcast <list> <message>" can
+// tell where the name ends.
+func checkListName(name string) string {
+	switch {
+	case name == "":
+		return "a list name is required"
+	case utf8.RuneCountInString(name) > maxBroadcastListName:
+		return fmt.Sprintf("list names can be at most %d characters", maxBroadcastListName)
+	case strings.ContainsFunc(name, unicode.IsSpace):
+		return "list names cannot contain spaces"
+	}
+	return ""
+}
+
+// create makes a new list for owner holding recipients. Returns the list, or
+// an error message string on failure.
+func (s *broadcastListStore) create(owner, name string, recipients []string) (BroadcastList, string) {
+	if errMsg := checkListName(name); errMsg != "" {
+		return BroadcastList{}, errMsg
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.lists[owner][name]; exists {
+		return BroadcastList{}, fmt.Sprintf("you already have a list called %q", name)
+	}
+	if len(s.lists[owner]) >= maxBroadcastLists {
+		return BroadcastList{}, fmt.Sprintf("you can have at most %d lists", maxBroadcastLists)
+	}
+	merged, errMsg := mergeRecipients(owner, nil, recipients)
+	if errMsg != "" {
+		return BroadcastList{}, errMsg
+	}
+	if errMsg := s.commitLocked(owner, name, merged, true); errMsg != "" {
+		return BroadcastList{}, errMsg
+	}
+	return BroadcastList{Name: name, Recipients: slices.Clone(merged)}, ""
+}
+
+// add puts recipients on owner's list. Anyone already on it is left alone.
+// Returns the list as it now is, or an error message string on failure.
+func (s *broadcastListStore) add(owner, name string, recipients []string) (BroadcastList, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, exists := s.lists[owner][name]
+	if !exists {
+		return BroadcastList{}, fmt.Sprintf("you have no list called %q", name)
+	}
+	merged, errMsg := mergeRecipients(owner, current, recipients)
+	if errMsg != "" {
+		return BroadcastList{}, errMsg
+	}
+	if errMsg := s.commitLocked(owner, name, merged, true); errMsg != "" {
+		return BroadcastList{}, errMsg
+	}
+	return BroadcastList{Name: name, Recipients: slices.Clone(merged)}, ""
+}
+
+// remove takes recipients off owner's list. Returns the list as it now is,
+// or an error message string on failure.
+func (s *broadcastListStore) remove(owner, name string, recipients []string) (BroadcastList, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, exists := s.lists[owner][name]
+	if !exists {
+		return BroadcastList{}, fmt.Sprintf("you have no list called %q", name)
+	}
+	// DeleteFunc works in place, so it is given a copy: the stored list must
+	// stay as it is until the change has been saved.
+	kept := slices.DeleteFunc(slices.Clone(current), func(r string) bool { return slices.Contains(recipients, r) })
+	if errMsg := s.commitLocked(owner, name, kept, true); errMsg != "" {
+		return BroadcastList{}, errMsg
+	}
+	return BroadcastList{Name: name, Recipients: slices.Clone(kept)}, ""
+}
+
+// deleteList removes owner's list. Returns an empty string on success, or an
+// error message string if there is no such list.
+func (s *broadcastListStore) deleteList(owner, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.lists[owner][name]; !exists {
+		return fmt.Sprintf("you have no list called %q", name)
+	}
+	return s.commitLocked(owner, name, nil, false)
+}
+
+// get returns a copy of owner's list, or reports false if there is none.
+func (s *broadcastListStore) get(owner, name string) (BroadcastList, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recipients, exists := s.lists[owner][name]
+	if !exists {
+		return BroadcastList{}, false
+	}
+	return BroadcastList{Name: name, Recipients: slices.Clone(recipients)}, true
+}
+
+// all returns copies of owner's lists, sorted by name.
+func (s *broadcastListStore) all(owner string) []BroadcastList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lists := make([]BroadcastList, 0, len(s.lists[owner]))
+	for name, recipients := range s.lists[owner] {
+		lists = append(lists, BroadcastList{Name: name, Recipients: slices.Clone(recipients)})
+	}
+	slices.SortFunc(lists, func(a, b BroadcastList) int { return strings.Compare(a.Name, b.Name) })
+	return lists
+}
+
+// commitLocked sets owner's list name to recipients, or deletes it if keep is
+// false, and saves the store. If saving fails the change is undone and an
+// error message string is returned, so that memory never holds lists the
+// file doesn't. The caller must hold s.mu.
+func (s *broadcastListStore) commitLocked(owner, name string, recipients []string, keep bool) string {
+	prev, existed := s.lists[owner][name]
+	s.setLocked(owner, name, recipients, keep)
+	if err := s.save(); err != nil {
+		slog.Error("save broadcast lists", "path", s.path, "err", err)
+		s.setLocked(owner, name, prev, existed)
+		return errListsNotSaved
+	}
+	return ""
+}
+
+// setLocked stores or (if keep is false) deletes one list, creating and
+// removing owner's map of lists as needed. The caller must hold s.mu.
+func (s *broadcastListStore) setLocked(owner, name string, recipients []string, keep bool) {
+	if !keep {
+		delete(s.lists[owner], name)
+		if len(s.lists[owner]) == 0 {
+			delete(s.lists, owner)
+		}
+		return
+	}
+	if s.lists[owner] == nil {
+		s.lists[owner] = make(map[string][]string)
+	}
+	s.lists[owner][name] = recipients
+}
+
+// save writes every list to the store's file (see writeFileAtomic). A store
+// without a path lives only in memory. The caller must hold s.mu.
+func (s *broadcastListStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.lists, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode broadcast lists file: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("save broadcast lists file: %w", err)
+	}
+	return nil
+}
+
+// mergeRecipients returns current with added merged in, sorted and without
+// duplicates, or an error message string if that would put owner on their
+// own list or make it too long.
+//
+// LEARNING POINT — slices.Sort + slices.Compact:
+// slices.Compact removes consecutive duplicates, so after sorting it removes
+// all of them. Together they turn any slice into a sorted set in place,
+// without a map.
+func mergeRecipients(owner string, current, added []string) ([]string, string) {
+	merged := slices.Clone(current)
+	for _, r := range added {
+		r = strings.TrimSpace(r)
+		switch r {
+		case "":
+			continue
+		case owner:
+			return nil, "you cannot add yourself to a broadcast list"
+		}
+		merged = append(merged, r)
+	}
+	slices.Sort(merged)
+	merged = slices.Compact(merged)
+	if len(merged) > maxBroadcastRecipients {
+		return nil, fmt.Sprintf("a list can hold at most %d contacts", maxBroadcastRecipients)
+	}
+	return merged, ""
+}
@@ -0,0 +1,188 @@
+// This file contains tests for broadcast lists (defined in
+// broadcastlists.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Checking that a returned slice is a copy, not the store's own
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TestBroadcastListStore exercises creating, editing and deleting lists.
+func TestBroadcastListStore(t *testing.T) {
+	s := newBroadcastListStore()
+
+	list, errMsg := s.create("alice", "team", []string{"carol", "bob", "carol", " "})
+	if errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if !slices.Equal(list.Recipients, []string{"bob", "carol"}) {
+		t.Errorf("expected a sorted list without duplicates, got %v", list.Recipients)
+	}
+	list.Recipients[0] = "mallory"
+	if got, _ := s.get("alice", "team"); got.Recipients[0] != "bob" {
+		t.Error("expected create to return a copy of the list")
+	}
+
+	for name, errMsg := range map[string]string{
+		"duplicate name": func() string { _, e := s.create("alice", "team", nil); return e }(),
+		"space in name":  func() string { _, e := s.create("alice", "my team", nil); return e }(),
+		"self":           func() string { _, e := s.add("alice", "team", []string{"alice"}); return e }(),
+		"unknown list":   func() string { _, e := s.add("alice", "nope", []string{"dave"}); return e }(),
+	} {
+		if errMsg == "" {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, errMsg := s.create("bob", "team", nil); errMsg != "" {
+		t.Errorf("expected list names to be per user: %s", errMsg)
+	}
+
+	s.add("alice", "team", []string{"dave", "bob"})
+	list, _ = s.remove("alice", "team", []string{"carol"})
+	if !slices.Equal(list.Recipients, []string{"bob", "dave"}) {
+		t.Errorf("unexpected recipients %v", list.Recipients)
+	}
+
+	if errMsg := s.deleteList("alice", "team"); errMsg != "" {
+		t.Fatalf("unexpected error deleting: %s", errMsg)
+	}
+	if got := s.all("alice"); len(got) != 0 {
+		t.Errorf("expected no lists left, got %+v", got)
+	}
+}
+
+// TestBroadcastListsPersist verifies that lists survive reopening the store,
+// and that a change that couldn't be saved isn't kept either.
+func TestBroadcastListsPersist(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	os.Mkdir(dir, 0o700)
+	path := filepath.Join(dir, "broadcast_lists.json")
+
+	s, err := openBroadcastListStore(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	s.create("alice", "team", []string{"bob", "carol"})
+	s.create("alice", "family", nil)
+
+	s, err = openBroadcastListStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := s.all("alice"); len(got) != 2 || !slices.Equal(got[1].Recipients, []string{"bob", "carol"}) {
+		t.Fatalf("expected both lists back after reopening, got %+v", got)
+	}
+
+	// With the directory gone, every save fails.
+	os.RemoveAll(dir)
+	if _, errMsg := s.remove("alice", "team", []string{"bob"}); errMsg == "" {
+		t.Error("expected remove to fail")
+	}
+	if errMsg := s.deleteList("alice", "family"); errMsg == "" {
+		t.Error("expected delete to fail")
+	}
+	if got := s.all("alice"); len(got) != 2 || len(got[1].Recipients) != 2 {
+		t.Errorf("expected the failed changes to be undone, got %+v", got)
+	}
+}
+
+// TestBroadcastViaWebSocket sends a broadcast and checks that each recipient
+// gets their own direct message, that the sender gets one confirmation
+// rather than an ack per recipient, and that a reply goes back to the sender
+// alone. It also checks that only people with accounts can be sent to.
+func TestBroadcastViaWebSocket(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dial := func(user string) *websocket.Conn {
+		c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, user), nil)
+		if err != nil {
+			t.Fatalf("%s failed to dial: %v", user, err)
+		}
+		t.Cleanup(func() { c.Close(websocket.StatusNormalClosure, "") })
+		return c
+	}
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+	alice, bob, carol := dial("alice"), dial("bob"), dial("carol")
+
+	send(alice, Message{Type: "create_list", List: "team", Recipients: []string{"bob", "carol"}})
+	if got := readUntil(t, ctx, alice, "list_updated"); len(got.Lists) != 1 || len(got.Lists[0].Recipients) != 2 {
+		t.Fatalf("unexpected reply %+v", got)
+	}
+
+	send(alice, Message{Type: "create_list", List: "others", Recipients: []string{"bob", "nobody"}})
+	if got := readUntil(t, ctx, alice, "error"); !strings.Contains(got.Content, `"nobody"`) {
+		t.Errorf("expected a list with an unknown user on it to be refused, got %+v", got)
+	}
+
+	send(alice, Message{Type: "broadcast", List: "team", Content: "lunch at noon"})
+	for {
+		_, p, err := alice.Read(ctx)
+		if err != nil {
+			t.Fatalf("failed waiting for the confirmation: %v", err)
+		}
+		var got Message
+		json.Unmarshal(p, &got)
+		if got.Type == "ack" || got.Type == "queued" {
+			t.Errorf("expected no per-recipient %s, got %+v", got.Type, got)
+		}
+		if got.Type == "broadcast_sent" {
+			if !strings.Contains(got.Content, "2 contacts") {
+				t.Errorf("unexpected confirmation %+v", got)
+			}
+			break
+		}
+	}
+	ids := map[string]bool{}
+	for name, c := range map[string]*websocket.Conn{"bob": bob, "carol": carol} {
+		got := readUntil(t, ctx, c, "")
+		if got.Sender != "alice" || got.Recipient != name || got.Content != "lunch at noon" {
+			t.Errorf("unexpected message for %s: %+v", name, got)
+		}
+		ids[got.ID] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected each recipient to get a message of their own, got IDs %v", ids)
+	}
+
+	send(bob, Message{Recipient: "alice", Content: "see you there"})
+	if got := readUntil(t, ctx, alice, ""); got.Sender != "bob" || got.Recipient != "alice" {
+		t.Errorf("unexpected reply %+v", got)
+	}
+
+	send(alice, Message{Type: "get_lists"})
+	if got := readUntil(t, ctx, alice, "lists"); len(got.Lists) != 1 || got.Lists[0].Name != "team" {
+		t.Errorf("unexpected lists %+v", got.Lists)
+	}
+
+	// Once carol's account is gone, the list can't be sent to until she is
+	// taken off it, and bob doesn't get the message either.
+	s.users.Disable("carol")
+	send(alice, Message{Type: "broadcast", List: "team", Content: "still on?"})
+	if got := readUntil(t, ctx, alice, "error"); !strings.Contains(got.Content, `"carol"`) {
+		t.Errorf("expected the broadcast to be refused, got %+v", got)
+	}
+	if msgs, _ := s.store.Conversation(directConversation("alice", "bob")); len(msgs) != 2 {
+		t.Errorf("expected only the broadcast and the reply to be stored, got %d messages", len(msgs))
+	}
+}
@@ -0,0 +1,180 @@
+// This file implements channels: rooms where only the owner and admins post
+// and everyone else just follows along, like a newsletter.
+//
+// A channel is a Room whose Type is RoomTypeChannel, so invitations, join
+// codes, roles and visibility all work as they do for groups. What differs:
+//
+//   - Only the owner and admins can post, whatever WhoCanPost says.
+//   - Plain members are subscribers. They join with "follow" and leave with
+//     "unfollow", nobody else is told when they do, and room_info counts
+//     them without listing them.
+//   - A subscriber never becomes the owner: only an admin can succeed one
+//     who leaves (see checkHandover).
+//   - Posts fan out to every subscriber in one pass (fanOutChannel) rather
+//     than one member at a time, and without per-recipient receipts, so
+//     that a post to thousands of subscribers stays cheap.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Doing a whole batch under one lock instead of locking per item
+//   - Sharing one read-only value (the marshaled post) between goroutines
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// RoomType says what kind of conversation a room is. It is chosen when the
+// room is created and never changes.
+type RoomType string
+
+const (
+	RoomTypeGroup   RoomType = "group"
+	RoomTypeChannel RoomType = "channel"
+)
+
+// valid reports whether t is a known room type.
+func (t RoomType) valid() bool {
+	return t == RoomTypeGroup || t == RoomTypeChannel
+}
+
+// createChannel creates a channel owned by creator. Returns an empty string
+// on success, or an error message string on failure.
+func (h *Hub) createChannel(name, creator string) string {
+	return h.newRoom(name, creator, RoomTypeChannel)
+}
+
+// staff returns the room's owner and admins, in no particular order.
+func (r *Room) staff() []string {
+	staff := make([]string, 0, len(r.Roles))
+	for m := range r.Roles {
+		staff = append(staff, m)
+	}
+	return staff
+}
+
+// noticeList returns who should hear that subject joined, left or was
+// removed: every member of a group, but in a channel only subject
+// themselves, or the owner and admins if subject is one of them. Call it
+// after subject joins and before they are removed.
+func (r *Room) noticeList(subject string) []string {
+	if r.Type != RoomTypeChannel {
+		return memberList(r)
+	}
+	if _, isStaff := r.Roles[subject]; isStaff {
+		return r.staff()
+	}
+	if r.Members[subject] {
+		return []string{subject}
+	}
+	return nil
+}
+
+// roleNoticeList returns who should hear about subject's role changing:
+// every member of a group, but in a channel only the owner, the admins and
+// subject.
+func (r *Room) roleNoticeList(subject string) []string {
+	if r.Type != RoomTypeChannel {
+		return memberList(r)
+	}
+	list := r.staff()
+	if !slices.Contains(list, subject) {
+		list = append(list, subject)
+	}
+	return list
+}
+
+// checkHandover returns an error message string if taking member out of room
+// would leave a channel with followers but nobody to run it: member is its
+// owner and there is no admin to succeed them. They must transfer ownership
+// or delete the channel instead.
+func checkHandover(room *Room, member string) string {
+	if room.Type != RoomTypeChannel || room.role(member) != RoleOwner ||
+		len(room.Members) == 1 || len(room.admins()) > 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s owns channel %q and there is no admin to take it over; transfer ownership or delete the channel first", member, room.ID)
+}
+
+// without returns list with user taken out.
+func without(list []string, user string) []string {
+	return slices.DeleteFunc(list, func(m string) bool { return m == user })
+}
+
+// isChannel reports whether roomID is a channel.
+func (h *Hub) isChannel(roomID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room, exists := h.rooms[roomID]
+	return exists && room.Type == RoomTypeChannel
+}
+
+// followChannel subscribes user to a public channel. It returns who to tell,
+// or an error message string on failure.
+func (h *Hub) followChannel(roomID, user string) ([]string, string) {
+	// A room's type never changes, so checking it before joinPublicRoom
+	// takes the lock leaves no gap for it to change in.
+	if !h.isChannel(roomID) {
+		return nil, fmt.Sprintf("there is no public channel %q", roomID)
+	}
+	return h.joinPublicRoom(roomID, user)
+}
+
+// unfollowChannel unsubscribes user from a channel. It returns who else to
+// tell and any new owner, as leaveRoom does, or an error message string on
+// failure.
+func (h *Hub) unfollowChannel(roomID, user string) ([]string, *succession, string) {
+	if !h.isChannel(roomID) {
+		return nil, nil, fmt.Sprintf("you are not following a channel %q", roomID)
+	}
+	return h.leaveRoom(roomID, user)
+}
+
+// fanOutChannel delivers a post to every subscriber and admin of a channel
+// except sender, and to sender's devices other than skip. Subscribers who
+// are offline have it queued in their backlog for the channel. It reports
+// how many connections it was queued on.
+//
+// LEARNING POINT — Batching Under One Lock:
+// handleRoomMessage delivers group messages one member at a time, taking the
+// hub's lock and the offline queue's lock for each. That is fine for a
+// dozen members, but a channel may have thousands of subscribers. Here the
+// hub's read lock is taken once to collect every live connection and every
+// offline subscriber, and the offline queue's lock once for the whole batch
+// (pushRoomAll). The writes themselves happen after the lock is released:
+// enqueue never blocks, but there's no reason to hold up people connecting
+// and disconnecting while thousands of them are made.
+//
+// Every connection is handed the same data slice and the same *Message.
+// Nothing writes to either after this point, so sharing them between the
+// writer goroutines is safe and saves a copy per subscriber.
+func (h *Hub) fanOutChannel(roomID, sender string, msg Message, data []byte, skip *connection) int {
+	h.mu.RLock()
+	room, exists := h.rooms[roomID]
+	if !exists {
+		h.mu.RUnlock()
+		return 0
+	}
+	var conns []*connection
+	var offline []string
+	for m := range room.Members {
+		if m == sender {
+			continue
+		}
+		if set := h.clients[m]; len(set) > 0 {
+			for conn := range set {
+				conns = append(conns, conn)
+			}
+		} else {
+			offline = append(offline, m)
+		}
+	}
+	h.offline.pushRoomAll(offline, msg)
+	own := h.snapshot(sender)
+	h.mu.RUnlock()
+
+	slog.Debug("channel post", "room", roomID, "user", sender, "connections", len(conns), "queued", len(offline))
+	return deliver(conns, outbound{data: data, kind: msg.Type, msg: &msg}, nil) +
+		deliver(own, outbound{data: data, kind: msg.Type}, skip)
+}
@@ -0,0 +1,148 @@
+// This file contains tests for channels (defined in channels.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Setting up part of a scenario directly on the hub and the rest over
+//     WebSocket
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// newChannelTestHub returns a hub with a public channel "news" owned by
+// alice, with bob as an admin and carol and dave following it.
+func newChannelTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := NewHub()
+	h.createChannel("news", "alice")
+	h.setVisibility("news", "alice", VisibilityPublic)
+	for _, m := range []string{"bob", "carol", "dave"} {
+		if _, errMsg := h.followChannel("news", m); errMsg != "" {
+			t.Fatalf("follow %s: %s", m, errMsg)
+		}
+	}
+	if _, errMsg := h.setRole("news", "alice", "bob", RoleAdmin); errMsg != "" {
+		t.Fatalf("promote bob: %s", errMsg)
+	}
+	return h
+}
+
+// TestChannelRules checks who may post in a channel, that subscribers are
+// neither listed nor told about each other, and that the channel is never
+// handed to a subscriber.
+func TestChannelRules(t *testing.T) {
+	h := newChannelTestHub(t)
+
+	for user, want := range map[string]bool{"alice": true, "bob": true, "carol": false} {
+		if got := h.checkRoomPermission("news", user, permPost) == ""; got != want {
+			t.Errorf("%s may post: got %v, want %v", user, got, want)
+		}
+	}
+	if _, _, errMsg := h.updateRoom("news", "alice", RoomUpdate{WhoCanPost: ptr(AudienceMembers)}); errMsg == "" {
+		t.Error("expected a channel not to allow opening up posting")
+	}
+
+	details, _ := h.roomDetails("news", "carol")
+	if details.MemberCount != 4 || len(details.Members) != 2 {
+		t.Errorf("expected 4 members with only the 2 admins listed, got %+v", details)
+	}
+
+	if got := h.rooms["news"].noticeList("carol"); len(got) != 1 || got[0] != "carol" {
+		t.Errorf("expected only carol to hear about carol, got %v", got)
+	}
+	if notify, _, errMsg := h.unfollowChannel("news", "dave"); errMsg != "" || len(notify) != 0 {
+		t.Errorf("expected dave to leave unannounced, got %v %q", notify, errMsg)
+	}
+	if _, errMsg := h.followChannel("news", "dave"); errMsg != "" {
+		t.Errorf("expected dave to be able to follow again: %s", errMsg)
+	}
+
+	// alice can hand over to admin bob, but bob has no admin to hand over to.
+	if _, succ, errMsg := h.unfollowChannel("news", "alice"); errMsg != "" || succ == nil || succ.owner != "bob" {
+		t.Fatalf("expected bob to take over from alice, got %+v %q", succ, errMsg)
+	}
+	if _, _, errMsg := h.leaveRoom("news", "bob"); !strings.Contains(errMsg, "no admin") {
+		t.Errorf("expected the last admin to be kept from leaving, got %q", errMsg)
+	}
+	if _, _, errMsg := h.removeFromRoom("news", "bob"); errMsg == "" {
+		t.Error("expected the last admin to be kept from being removed")
+	}
+	if owner := h.rooms["news"].owner(); owner != "bob" {
+		t.Errorf("expected bob to still own the channel, got %q", owner)
+	}
+
+	h.createRoom("general", "alice")
+	h.setVisibility("general", "alice", VisibilityPublic)
+	if _, errMsg := h.followChannel("general", "carol"); errMsg == "" {
+		t.Error("expected following a group to fail")
+	}
+}
+
+// TestChannelFanOut posts to a channel with subscribers online and offline,
+// and checks that a subscriber can't post.
+func TestChannelFanOut(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dial := func(user string) *websocket.Conn {
+		c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, user), nil)
+		if err != nil {
+			t.Fatalf("%s failed to dial: %v", user, err)
+		}
+		t.Cleanup(func() { c.Close(websocket.StatusNormalClosure, "") })
+		return c
+	}
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+	alice, bob, carol := dial("alice"), dial("bob"), dial("carol")
+
+	send(alice, Message{Type: "create_room", Content: "news", RoomType: RoomTypeChannel, Visibility: VisibilityPublic})
+	readUntil(t, ctx, alice, "room_created")
+	for _, c := range []*websocket.Conn{bob, carol} {
+		send(c, Message{Type: "follow", Room: "news"})
+		if got := readUntil(t, ctx, c, "member_joined"); !strings.Contains(got.Content, "following") {
+			t.Errorf("unexpected notice %+v", got)
+		}
+	}
+	// dave follows while offline, so the post has to wait for him.
+	if _, errMsg := s.hub.followChannel("news", "dave"); errMsg != "" {
+		t.Fatalf("follow dave: %s", errMsg)
+	}
+
+	send(bob, Message{Type: "room_msg", Room: "news", Content: "me too"})
+	if got := readUntil(t, ctx, bob, "error"); !strings.Contains(got.Content, "not allowed to post") {
+		t.Errorf("unexpected error %+v", got)
+	}
+
+	send(alice, Message{Type: "room_msg", Room: "news", Content: "v2 is out"})
+	ack := readUntil(t, ctx, alice, "ack")
+	for name, c := range map[string]*websocket.Conn{"bob": bob, "carol": carol} {
+		if got := readUntil(t, ctx, c, "room_msg"); got.Content != "v2 is out" || got.Sender != "alice" {
+			t.Errorf("unexpected post for %s: %+v", name, got)
+		}
+	}
+	dave := dial("dave")
+	if got := readUntil(t, ctx, dave, "room_msg"); got.Content != "v2 is out" {
+		t.Errorf("unexpected post for dave: %+v", got)
+	}
+	s.hub.receipts.mu.Lock()
+	_, tracked := s.hub.receipts.entries[ack.ID]
+	s.hub.receipts.mu.Unlock()
+	if tracked {
+		t.Error("expected channel posts not to track receipts")
+	}
+}
@@ -0,0 +1,374 @@
+// This file implements the server's configuration. Every setting has a
+// built-in default and can be overridden, in increasing order of precedence,
+// by:
+//
+//  1. a JSON config file (-config path, or CHAT_CONFIG),
+//  2. an environment variable (CHAT_ plus the flag name in upper case, with
+//     dashes as underscores: -ping-interval becomes CHAT_PING_INTERVAL), and
+//  3. a command-line flag.
+//
+// The merged configuration is validated before the server starts, and logged
+// on boot with secrets redacted so operators can see what the server is
+// actually running with.
+//
+// Example config file:
+//
+//	{
+//	  "listen_addr": ":8443",
+//	  "tls_cert_file": "cert.pem",
+//	  "tls_key_file": "key.pem",
+//	  "allowed_origins": ["chat.example.com"],
+//	  "ping_interval": "20s"
+//	}
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - The flag.Value interface for custom flag types
+//   - flag.FlagSet and FlagSet.Visit to find which flags were actually set
+//   - Custom JSON (un)marshaling for time.Duration
+//   - errors.Join to report every validation problem at once
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every server setting.
+type Config struct {
+	ListenAddr     string   `json:"listen_addr"`
+	TLSCertFile    string   `json:"tls_cert_file,omitempty"`
+	TLSKeyFile     string   `json:"tls_key_file,omitempty"`
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+
+	MaxMessageBytes int `json:"max_message_bytes"`
+
+	SendBuffer        int      `json:"send_buffer"`
+	SlowConsumer      string   `json:"slow_consumer"`
+	OfflineQueueLimit int      `json:"offline_queue_limit"`
+	RoomBacklogLimit  int      `json:"room_backlog_limit"`
+	OfflineQueueTTL   Duration `json:"offline_queue_ttl"`
+	InviteTTL         Duration `json:"invite_ttl"`
+	JoinCodeTTL       Duration `json:"join_code_ttl"`
+
+	PingInterval    Duration `json:"ping_interval"`
+	PingTimeout     Duration `json:"ping_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	MessageLogPath     string `json:"message_log_path"`
+	AccountsPath       string `json:"accounts_path"`
+	BroadcastListsPath string `json:"broadcast_lists_path"`
+
+	TokenSecret string   `json:"token_secret,omitempty"`
+	TokenTTL    Duration `json:"token_ttl"`
+
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+
+	AdminAddr  string `json:"admin_addr,omitempty"`
+	AdminToken string `json:"admin_token,omitempty"`
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden.
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:         ":8080",
+		MaxMessageBytes:    32 << 10,
+		SendBuffer:         defaultOutboundBuffer,
+		SlowConsumer:       string(policySpill),
+		OfflineQueueLimit:  defaultOfflineQueueLimit,
+		RoomBacklogLimit:   defaultRoomBacklogLimit,
+		OfflineQueueTTL:    Duration(defaultOfflineQueueTTL),
+		InviteTTL:          Duration(defaultInviteTTL),
+		JoinCodeTTL:        Duration(defaultJoinCodeTTL),
+		PingInterval:       Duration(defaultPingInterval),
+		PingTimeout:        Duration(defaultPingTimeout),
+		ShutdownTimeout:    Duration(defaultShutdownTimeout),
+		MessageLogPath:     "messages.log",
+		AccountsPath:       "accounts.json",
+		BroadcastListsPath: "broadcast_lists.json",
+		TokenTTL:           Duration(defaultTokenTTL),
+		LogFormat:          logFormatText,
+		LogLevel:           "info",
+	}
+}
+
+// minAdminTokenLen is the shortest admin token accepted. The admin API can
+// disconnect users and delete rooms, so its token must not be guessable.
+const minAdminTokenLen = 16
+
+// Duration is a time.Duration that reads and writes JSON as a string such as
+// "30s" or "1h30m", rather than as a number of nanoseconds.
+type Duration time.Duration
+
+// MarshalJSON writes d in time.Duration's String format.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON parses a duration string.
+//
+// LEARNING POINT — Custom JSON Decoding:
+// encoding/json calls UnmarshalJSON on any field whose type has that method,
+// passing the raw JSON bytes. Here we first decode those bytes as a string,
+// then hand the string to time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// setting describes one configurable value: its flag name, help text, and
+// how to bind a flag.Value to the corresponding field of a Config. The
+// environment variable name is derived from the flag name (see envName).
+type setting struct {
+	name   string
+	usage  string
+	secret bool // never accepted as a flag, so it can't leak via ps
+	bind   func(*Config) flag.Value
+}
+
+// settings lists every configurable value. Adding a setting means adding a
+// Config field and one line here; flags, environment variables and the
+// config file then all pick it up.
+var settings = []setting{
+	{name: "listen-addr", usage: "address to listen on", bind: func(c *Config) flag.Value { return (*stringValue)(&c.ListenAddr) }},
+	{name: "tls-cert-file", usage: "TLS certificate file (serves HTTPS/WSS when set with -tls-key-file)", bind: func(c *Config) flag.Value { return (*stringValue)(&c.TLSCertFile) }},
+	{name: "tls-key-file", usage: "TLS private key file", bind: func(c *Config) flag.Value { return (*stringValue)(&c.TLSKeyFile) }},
+	{name: "allowed-origins", usage: "comma-separated Origin host patterns browsers may connect from (default: same origin only)", bind: func(c *Config) flag.Value { return (*listValue)(&c.AllowedOrigins) }},
+	{name: "max-message-bytes", usage: "largest WebSocket message accepted from a client", bind: func(c *Config) flag.Value { return (*intValue)(&c.MaxMessageBytes) }},
+	{name: "send-buffer", usage: "messages queued per connection before the slow-consumer policy applies", bind: func(c *Config) flag.Value { return (*intValue)(&c.SendBuffer) }},
+	{name: "slow-consumer", usage: "when a connection's queue is full: drop_oldest, disconnect or spill", bind: func(c *Config) flag.Value { return (*stringValue)(&c.SlowConsumer) }},
+	{name: "offline-queue-limit", usage: "direct messages held for an offline user", bind: func(c *Config) flag.Value { return (*intValue)(&c.OfflineQueueLimit) }},
+	{name: "room-backlog-limit", usage: "messages held per room for an offline member", bind: func(c *Config) flag.Value { return (*intValue)(&c.RoomBacklogLimit) }},
+	{name: "offline-queue-ttl", usage: "how long queued messages stay deliverable", bind: func(c *Config) flag.Value { return (*durationValue)(&c.OfflineQueueTTL) }},
+	{name: "invite-ttl", usage: "how long room invitations can be accepted", bind: func(c *Config) flag.Value { return (*durationValue)(&c.InviteTTL) }},
+	{name: "join-code-ttl", usage: "how long join codes last when their creator doesn't say", bind: func(c *Config) flag.Value { return (*durationValue)(&c.JoinCodeTTL) }},
+	{name: "ping-interval", usage: "how often to ping each client (0 disables heartbeats)", bind: func(c *Config) flag.Value { return (*durationValue)(&c.PingInterval) }},
+	{name: "ping-timeout", usage: "how long to wait for a pong before dropping a client", bind: func(c *Config) flag.Value { return (*durationValue)(&c.PingTimeout) }},
+	{name: "shutdown-timeout", usage: "how long a graceful shutdown may take before connections are cut off", bind: func(c *Config) flag.Value { return (*durationValue)(&c.ShutdownTimeout) }},
+	{name: "message-log-path", usage: "file the message log is kept in", bind: func(c *Config) flag.Value { return (*stringValue)(&c.MessageLogPath) }},
+	{name: "accounts-path", usage: "file user accounts are kept in", bind: func(c *Config) flag.Value { return (*stringValue)(&c.AccountsPath) }},
+	{name: "broadcast-lists-path", usage: "file users' broadcast lists are kept in", bind: func(c *Config) flag.Value { return (*stringValue)(&c.BroadcastListsPath) }},
+	{name: "token-secret", secret: true, bind: func(c *Config) flag.Value { return (*stringValue)(&c.TokenSecret) }},
+	{name: "token-ttl", usage: "how long login tokens stay valid", bind: func(c *Config) flag.Value { return (*durationValue)(&c.TokenTTL) }},
+	{name: "log-format", usage: "log output format: text or json", bind: func(c *Config) flag.Value { return (*stringValue)(&c.LogFormat) }},
+	{name: "log-level", usage: "lowest level logged: debug, info, warn or error (message content is only logged at debug)", bind: func(c *Config) flag.Value { return (*stringValue)(&c.LogLevel) }},
+	{name: "admin-addr", usage: "address for the admin API, e.g. 127.0.0.1:8081 (disabled when empty)", bind: func(c *Config) flag.Value { return (*stringValue)(&c.AdminAddr) }},
+	{name: "admin-token", secret: true, bind: func(c *Config) flag.Value { return (*stringValue)(&c.AdminToken) }},
+}
+
+// envName returns the environment variable for a setting, e.g.
+// "ping-interval" -> "CHAT_PING_INTERVAL".
+func envName(name string) string {
+	return "CHAT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadConfig builds the configuration from defaults, the config file,
+// environment variables (looked up with getenv) and the command-line
+// arguments (without the program name), in that order of precedence.
+//
+// LEARNING POINT — Knowing Which Flags Were Set:
+// A flag's value can't tell you whether the user typed it: "-ping-interval
+// 30s" and no flag at all both leave 30s behind. FlagSet.Visit walks only the
+// flags that were actually set on the command line, which is exactly the set
+// that should override the file and the environment.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	defaults := DefaultConfig()
+
+	// Flags are parsed into a scratch copy first; only the ones that were
+	// set are applied on top of the file and environment below.
+	parsed := defaults
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", getenv("CHAT_CONFIG"), "JSON config file (env CHAT_CONFIG)")
+	for _, s := range settings {
+		if !s.secret {
+			fs.Var(s.bind(&parsed), s.name, fmt.Sprintf("%s (env %s)", s.usage, envName(s.name)))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg := defaults
+	if *configPath != "" {
+		f, err := os.Open(*configPath)
+		if err != nil {
+			return Config{}, fmt.Errorf("open config file: %w", err)
+		}
+		// Rejecting unknown keys turns a typo like "ping_intreval" into a
+		// startup error instead of a setting that silently does nothing.
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+		f.Close()
+		if err != nil {
+			return Config{}, fmt.Errorf("decode config file %s: %w", *configPath, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(envName(s.name)); v != "" {
+			if err := s.bind(&cfg).Set(v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", envName(s.name), err)
+			}
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name {
+				s.bind(&cfg).Set(f.Value.String())
+			}
+		}
+	})
+	return cfg, nil
+}
+
+// Validate checks the configuration for values the server cannot run with.
+// It reports every problem, not just the first.
+//
+// LEARNING POINT — errors.Join:
+// errors.Join (Go 1.20) combines several errors into one whose message lists
+// them all, one per line, and which still matches each of them with
+// errors.Is. It returns nil if every argument is nil, so problems can simply
+// be appended to a slice as they are found.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.ListenAddr != "", "listen_addr is required")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "tls_cert_file and tls_key_file must be set together")
+	check(c.MaxMessageBytes > 0, "max_message_bytes must be positive")
+	check(c.SendBuffer > 0, "send_buffer must be positive")
+	if _, err := parseSlowConsumerPolicy(c.SlowConsumer); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.OfflineQueueLimit > 0, "offline_queue_limit must be positive")
+	check(c.RoomBacklogLimit > 0, "room_backlog_limit must be positive")
+	check(c.OfflineQueueTTL > 0, "offline_queue_ttl must be positive")
+	check(c.InviteTTL > 0, "invite_ttl must be positive")
+	check(c.JoinCodeTTL > 0 && c.JoinCodeTTL <= Duration(maxJoinCodeTTL), "join_code_ttl must be positive and at most %v", maxJoinCodeTTL)
+	check(c.PingInterval >= 0, "ping_interval must not be negative")
+	check(c.PingInterval == 0 || c.PingTimeout > 0, "ping_timeout must be positive when heartbeats are enabled")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.MessageLogPath != "", "message_log_path is required")
+	check(c.AccountsPath != "", "accounts_path is required")
+	check(c.BroadcastListsPath != "", "broadcast_lists_path is required")
+	check(c.TokenTTL > 0, "token_ttl must be positive")
+	check(c.AdminAddr == "" || len(c.AdminToken) >= minAdminTokenLen, "admin_token (CHAT_ADMIN_TOKEN) of at least %d characters is required when admin_addr is set", minAdminTokenLen)
+	check(c.AdminAddr == "" || c.AdminAddr != c.ListenAddr, "admin_addr must differ from listen_addr")
+	if _, err := newLogger(io.Discard, c.LogFormat, c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of c that is safe to print or log.
+func (c Config) Redacted() Config {
+	if c.TokenSecret != "" {
+		c.TokenSecret = "REDACTED"
+	}
+	if c.AdminToken != "" {
+		c.AdminToken = "REDACTED"
+	}
+	return c
+}
+
+// LogValue makes a logged Config appear as a group with one field per
+// setting, secrets redacted.
+//
+// LEARNING POINT — slog.LogValuer:
+// When a value passed to a logger implements LogValue, slog calls it and logs
+// the result instead. Redacting here means no call site can forget to, and
+// reusing the settings table keeps the field names the same as the flags.
+func (c Config) LogValue() slog.Value {
+	c = c.Redacted()
+	attrs := make([]slog.Attr, 0, len(settings))
+	for _, s := range settings {
+		attrs = append(attrs, slog.String(s.name, s.bind(&c).String()))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// newHub creates a Hub sized and tuned according to c. c must be valid.
+func (c Config) newHub() *Hub {
+	h := NewHub()
+	h.offline = newOfflineQueue(c.OfflineQueueLimit, c.RoomBacklogLimit, time.Duration(c.OfflineQueueTTL))
+	h.invitations = newInvitationStore(time.Duration(c.InviteTTL))
+	h.joinCodes = newJoinCodeStore(time.Duration(c.JoinCodeTTL))
+	h.outboundBuffer = c.SendBuffer
+	h.slowConsumer = slowConsumerPolicy(c.SlowConsumer)
+	h.pingInterval = time.Duration(c.PingInterval)
+	h.pingTimeout = time.Duration(c.PingTimeout)
+	return h
+}
+
+// LEARNING POINT — The flag.Value Interface:
+// Any type with String() string and Set(string) error can be a flag. The
+// types below are named pointer types, so (*intValue)(&cfg.SendBuffer) is a
+// flag.Value that writes straight into the Config field. The standard library
+// does the same internally for flag.Int, flag.String and friends.
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type durationValue Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+// listValue is a comma-separated list of strings.
+type listValue []string
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// This file contains tests for configuration loading and validation (defined
+// in config.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Passing a fake getenv instead of mutating the real environment
+//   - os.WriteFile into t.TempDir for throwaway config files
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// fakeEnv returns a getenv function backed by a map.
+//
+// LEARNING POINT — Injecting the Environment:
+// LoadConfig takes getenv as a parameter instead of calling os.Getenv itself,
+// so tests can supply any environment they like without touching the
+// process's real one (which would leak between tests running in parallel).
+func fakeEnv(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+// TestLoadConfigPrecedence verifies that the config file overrides defaults,
+// the environment overrides the file, and flags override both.
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"listen_addr": ":9000",
+		"ping_interval": "5s",
+		"send_buffer": 64,
+		"allowed_origins": ["chat.example.com"]
+	}`), 0o600)
+
+	env := fakeEnv(map[string]string{
+		"CHAT_CONFIG":        path,
+		"CHAT_PING_INTERVAL": "7s",
+		"CHAT_SEND_BUFFER":   "128",
+	})
+	cfg, err := LoadConfig([]string{"-send-buffer", "256"}, env)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if cfg.ListenAddr != ":9000" {
+		t.Errorf("expected listen_addr from the file, got %q", cfg.ListenAddr)
+	}
+	if cfg.PingInterval != Duration(7*time.Second) {
+		t.Errorf("expected ping_interval from the environment, got %v", time.Duration(cfg.PingInterval))
+	}
+	if cfg.SendBuffer != 256 {
+		t.Errorf("expected send_buffer from the flag, got %d", cfg.SendBuffer)
+	}
+	if len(cfg.AllowedOrigins) != 1 || cfg.AllowedOrigins[0] != "chat.example.com" {
+		t.Errorf("expected allowed_origins from the file, got %v", cfg.AllowedOrigins)
+	}
+	if cfg.PingTimeout != Duration(defaultPingTimeout) {
+		t.Errorf("expected untouched settings to keep their defaults, got ping_timeout %v", time.Duration(cfg.PingTimeout))
+	}
+}
+
+// TestLoadConfigErrors covers inputs LoadConfig must reject.
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	typo := filepath.Join(dir, "typo.json")
+	os.WriteFile(typo, []byte(`{"ping_intreval": "5s"}`), 0o600)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"unknown file key", []string{"-config", typo}, nil},
+		{"missing file", []string{"-config", filepath.Join(dir, "nope.json")}, nil},
+		{"bad env duration", nil, map[string]string{"CHAT_PING_TIMEOUT": "soon"}},
+		{"bad flag int", []string{"-send-buffer", "lots"}, nil},
+		{"secret as flag", []string{"-token-secret", "hunter2"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadConfig(tt.args, fakeEnv(tt.env)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestConfigValidate verifies that the defaults are valid and that every
+// problem in a bad configuration is reported.
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("expected defaults to be valid, got %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.TLSCertFile = "cert.pem"
+	cfg.SlowConsumer = "block"
+	cfg.SendBuffer = 0
+	cfg.AdminAddr = "127.0.0.1:8081"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, want := range []string{"tls_key_file", "slow-consumer", "send_buffer", "admin_token"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to mention %s, got:\n%v", want, err)
+		}
+	}
+}
+
+// TestConfigRedactsSecrets verifies that logging the configuration never
+// reveals the token secret.
+func TestConfigRedactsSecrets(t *testing.T) {
+	cfg, err := LoadConfig(nil, fakeEnv(map[string]string{"CHAT_TOKEN_SECRET": "hunter2"}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.TokenSecret != "hunter2" {
+		t.Fatalf("expected the secret to be loaded from the environment, got %q", cfg.TokenSecret)
+	}
+	var buf bytes.Buffer
+	log, _ := newLogger(&buf, logFormatJSON, "info")
+	log.Info("configuration loaded", "config", cfg)
+	if out := buf.String(); strings.Contains(out, "hunter2") || !strings.Contains(out, `"token-secret":"REDACTED"`) {
+		t.Errorf("expected the secret to be redacted, got:\n%s", out)
+	}
+}
+
+// TestMaxMessageBytes verifies that a client sending a message over the
+// configured limit is disconnected.
+func TestMaxMessageBytes(t *testing.T) {
+	s := newTestServer()
+	s.config.MaxMessageBytes = 64
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.CloseNow()
+
+	alice.Write(ctx, websocket.MessageText, []byte(`{"recipient":"bob","content":"`+strings.Repeat("x", 100)+`"}`))
+	_, _, err = alice.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusMessageTooBig {
+		t.Errorf("expected StatusMessageTooBig, got %v (%v)", status, err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected the server to close the connection, not a timeout")
+	}
+}
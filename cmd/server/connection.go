@@ -0,0 +1,278 @@
+// This file implements the per-connection writer. Handlers never write to a
+// WebSocket themselves; they put the bytes on the connection's outbound queue
+// and move on, and a goroutine dedicated to that connection does the actual
+// writing.
+//
+// That has two benefits. A slow recipient (a phone on a bad network, a
+// terminal that has stopped reading) only ever blocks its own writer, never
+// the sender whose message is being fanned out. And because exactly one
+// goroutine writes to each socket, messages go out in the order they were
+// queued.
+//
+// The queue is bounded. When it fills up, the hub's slow-consumer policy
+// decides what gives:
+//
+//	drop_oldest  discard the oldest queued message to make room
+//	disconnect   close the connection; the client can reconnect and catch up
+//	spill        move chat messages to the offline queue, then disconnect
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Buffered channels as bounded queues
+//   - select with default for non-blocking sends and receives
+//   - context.WithCancelCause to stop a goroutine and say why
+//   - Callbacks (func()) to run code after an asynchronous step completes
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	// defaultOutboundBuffer is how many messages may wait in a connection's
+	// outbound queue before the slow-consumer policy kicks in.
+	defaultOutboundBuffer = 256
+
+	// writeTimeout bounds a single WebSocket write. A client that cannot
+	// accept one message in this long is treated as gone.
+	writeTimeout = 10 * time.Second
+)
+
+// slowConsumerPolicy says what to do when a connection's outbound queue is
+// full.
+type slowConsumerPolicy string
+
+const (
+	policyDropOldest slowConsumerPolicy = "drop_oldest"
+	policyDisconnect slowConsumerPolicy = "disconnect"
+	policySpill      slowConsumerPolicy = "spill"
+)
+
+// parseSlowConsumerPolicy validates a policy name from configuration.
+func parseSlowConsumerPolicy(s string) (slowConsumerPolicy, error) {
+	switch p := slowConsumerPolicy(s); p {
+	case policyDropOldest, policyDisconnect, policySpill:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown slow-consumer policy %q (want drop_oldest, disconnect or spill)", s)
+}
+
+// Cancellation causes for a connection's context, saying why it stopped.
+var (
+	errSlowConsumer   = errors.New("slow consumer")
+	errServerShutdown = errors.New("server shutting down")
+)
+
+// outbound is one message waiting in a connection's queue.
+type outbound struct {
+	data []byte
+	kind string // the message type, for metrics
+
+	// msg is set for chat messages addressed to this connection's user. Only
+	// those are worth keeping if the connection cannot take them: spilling an
+	// ack or a receipt to the offline queue would only confuse the client
+	// later.
+	msg *Message
+
+	// onWritten, if set, runs on the writer goroutine once data has been
+	// written to the socket. It is how delivery receipts are sent: a message
+	// is "delivered" when it leaves the server, not when it is queued.
+	onWritten func()
+
+	// goingAway marks the end of the queue during a graceful shutdown: the
+	// writer closes the socket with StatusGoingAway instead of writing.
+	goingAway bool
+}
+
+// connection wraps a WebSocket connection. This thin wrapper struct is a common
+// Go pattern — it lets you attach additional per-connection state later (e.g.,
+// send channels, metadata) without changing the Hub's interface.
+//
+// In Go, lowercase struct names (unexported) are only visible within the same
+// package. This is intentional: connection is an internal implementation detail.
+//
+// id distinguishes a user's devices from one another in logs, and user is the
+// authenticated owner of the connection. log carries both as fields, so every
+// record about this connection can be found by either. send is the outbound
+// queue drained by writeLoop; ctx is cancelled to stop the writer and the
+// heartbeat, and done is closed once the writer has exited.
+type connection struct {
+	id          string
+	user        string
+	ws          *websocket.Conn
+	connectedAt time.Time
+	log         *slog.Logger
+
+	hub    *Hub
+	policy slowConsumerPolicy
+	send   chan outbound
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	// mu serializes enqueue, so that making room under drop_oldest cannot
+	// race with another sender filling the slot first.
+	mu sync.Mutex
+
+	// rtt (nanoseconds) and lastPong (Unix nanoseconds) are written by the
+	// heartbeat goroutine and read by connectionStats (see heartbeat.go).
+	rtt      atomic.Int64
+	lastPong atomic.Int64
+}
+
+// newConnection wraps ws for user with the hub's queue size and policy. The
+// caller starts the writer with go conn.writeLoop() (and the heartbeat with
+// go conn.heartbeat()) once the connection is registered.
+func (h *Hub) newConnection(parent context.Context, user string, ws *websocket.Conn) *connection {
+	ctx, cancel := context.WithCancelCause(parent)
+	id := newID()
+	return &connection{
+		id:          id,
+		user:        user,
+		ws:          ws,
+		connectedAt: time.Now().UTC(),
+		log:         slog.With("user", user, "conn", id),
+		hub:         h,
+		policy:      h.slowConsumer,
+		send:        make(chan outbound, h.outboundBuffer),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// enqueue adds a message to the outbound queue without blocking, applying the
+// slow-consumer policy if the queue is full. It reports whether the message
+// was queued.
+//
+// LEARNING POINT — Non-Blocking Channel Operations:
+// A plain "c.send <- out" would block the caller until the writer made room,
+// which is exactly the stall we are trying to avoid. Wrapping the send in a
+// select with a default case turns it into "send if there is room, otherwise
+// fall through immediately".
+func (c *connection) enqueue(out outbound) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A stopped connection has no writer left to drain the queue.
+	if c.ctx.Err() != nil {
+		c.spill(out)
+		return false
+	}
+
+	select {
+	case c.send <- out:
+		return true
+	default:
+	}
+
+	switch c.policy {
+	case policyDropOldest:
+		select {
+		case <-c.send:
+			c.log.Warn("outbound queue full; dropped oldest message")
+			c.hub.metrics.dropped.inc(dropQueueFull)
+		default:
+		}
+		// Other senders are locked out by c.mu and the writer only ever
+		// takes from the queue, so there is room now; the select is just
+		// a guarantee that this can never block.
+		select {
+		case c.send <- out:
+			return true
+		default:
+			return false
+		}
+	case policySpill:
+		c.spill(out)
+		fallthrough
+	default: // policyDisconnect
+		c.log.Warn("outbound queue full; disconnecting", "policy", c.policy)
+		c.cancel(errSlowConsumer)
+		return false
+	}
+}
+
+// spill moves a chat message that this connection could not take to the
+// user's offline queue, so it is delivered on their next connect. It does
+// nothing unless the policy is spill.
+func (c *connection) spill(out outbound) {
+	if c.policy != policySpill || out.msg == nil {
+		return
+	}
+	c.hub.offline.push(c.user, *out.msg)
+}
+
+// writeLoop drains the outbound queue onto the socket until the connection is
+// stopped or a write fails. It is the only goroutine that writes to c.ws once
+// the connection is live.
+func (c *connection) writeLoop() {
+	defer close(c.done)
+	defer c.finish()
+	for {
+		select {
+		case out := <-c.send:
+			if out.goingAway {
+				c.cancel(errServerShutdown)
+				c.ws.Close(websocket.StatusGoingAway, "server shutting down")
+				return
+			}
+			ctx, cancel := context.WithTimeout(c.ctx, writeTimeout)
+			start := time.Now()
+			err := c.ws.Write(ctx, websocket.MessageText, out.data)
+			cancel()
+			c.hub.metrics.observeWrite(out.kind, time.Since(start), err)
+			if err != nil {
+				c.log.Info("write failed", "err", err)
+				c.spill(out)
+				c.cancel(err)
+				return
+			}
+			if out.onWritten != nil {
+				out.onWritten()
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// finish runs when the writer exits. Under the spill policy, whatever is
+// still queued goes to the offline queue. A connection stopped for being too
+// slow, for missing a heartbeat, or by an administrator is closed here, which
+// also ends its read loop in wsHandler.
+func (c *connection) finish() {
+	c.mu.Lock()
+	for drained := false; !drained; {
+		select {
+		case out := <-c.send:
+			c.spill(out)
+		default:
+			drained = true
+		}
+	}
+	c.mu.Unlock()
+
+	switch cause := context.Cause(c.ctx); {
+	case errors.Is(cause, errSlowConsumer):
+		c.ws.Close(websocket.StatusPolicyViolation, "slow consumer")
+	case errors.Is(cause, errAdminDisconnect):
+		c.ws.Close(websocket.StatusPolicyViolation, "disconnected by an administrator")
+	case errors.Is(cause, errMissedHeartbeat):
+		// The client isn't answering, so there is no point waiting for it
+		// to acknowledge a close frame.
+		c.ws.CloseNow()
+	}
+}
+
+// stop tells the writer to exit. It is safe to call more than once.
+func (c *connection) stop() {
+	c.cancel(nil)
+}
@@ -0,0 +1,114 @@
+// This file contains unit tests for the per-connection outbound queue and its
+// slow-consumer policies (defined in connection.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Testing a queue without starting its consumer goroutine
+//   - A shared setup helper marked with t.Helper
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// fillQueue builds a connection for bob with a two-message queue under the
+// given policy and offers it three chat messages. No writer goroutine is
+// started, so nothing ever leaves the queue.
+//
+// LEARNING POINT — A Nil WebSocket:
+// enqueue only touches the channel, so the connection can be tested with a
+// nil *websocket.Conn as long as writeLoop (which would use it) never runs.
+func fillQueue(t *testing.T, policy slowConsumerPolicy) (*Hub, *connection, []bool) {
+	t.Helper()
+	h := NewHub()
+	h.outboundBuffer = 2
+	h.slowConsumer = policy
+	conn := h.newConnection(context.Background(), "bob", nil)
+
+	var accepted []bool
+	for _, text := range []string{"one", "two", "three"} {
+		msg := Message{ID: text, Sender: "alice", Recipient: "bob", Content: text}
+		data, _ := json.Marshal(msg)
+		accepted = append(accepted, conn.enqueue(outbound{data: data, msg: &msg}))
+	}
+	return h, conn, accepted
+}
+
+// queued returns the contents of the queued messages, oldest first.
+func queued(conn *connection) []string {
+	var out []string
+	for len(conn.send) > 0 {
+		out = append(out, (<-conn.send).msg.Content)
+	}
+	return out
+}
+
+// TestSlowConsumerDropOldest verifies that a full queue makes room by
+// discarding its oldest message and keeps the connection open.
+func TestSlowConsumerDropOldest(t *testing.T) {
+	_, conn, accepted := fillQueue(t, policyDropOldest)
+
+	if !accepted[2] {
+		t.Error("expected the newest message to be queued")
+	}
+	if got := queued(conn); len(got) != 2 || got[0] != "two" || got[1] != "three" {
+		t.Errorf("expected [two three] to remain queued, got %v", got)
+	}
+	if conn.ctx.Err() != nil {
+		t.Error("expected the connection to stay open")
+	}
+}
+
+// TestSlowConsumerDisconnect verifies that a full queue stops the connection
+// under the disconnect policy.
+func TestSlowConsumerDisconnect(t *testing.T) {
+	h, conn, accepted := fillQueue(t, policyDisconnect)
+
+	if accepted[2] {
+		t.Error("expected the third message to be refused")
+	}
+	if !errors.Is(context.Cause(conn.ctx), errSlowConsumer) {
+		t.Errorf("expected the connection to be stopped as a slow consumer, got %v", context.Cause(conn.ctx))
+	}
+	if pending := h.offline.drain("bob"); len(pending) != 0 {
+		t.Errorf("expected nothing to be spilled, got %d message(s)", len(pending))
+	}
+}
+
+// TestSlowConsumerSpill verifies that under the spill policy the message that
+// did not fit, and anything sent after the connection was stopped, end up in
+// the offline queue.
+func TestSlowConsumerSpill(t *testing.T) {
+	h, conn, accepted := fillQueue(t, policySpill)
+
+	if accepted[2] {
+		t.Error("expected the third message to be refused")
+	}
+	if !errors.Is(context.Cause(conn.ctx), errSlowConsumer) {
+		t.Errorf("expected the connection to be stopped as a slow consumer, got %v", context.Cause(conn.ctx))
+	}
+
+	msg := Message{ID: "four", Sender: "alice", Recipient: "bob", Content: "four"}
+	conn.enqueue(outbound{data: []byte("{}"), msg: &msg})
+	// Acks and receipts have no msg and are never spilled.
+	conn.enqueue(outbound{data: []byte("{}")})
+
+	pending := h.offline.drain("bob")
+	if len(pending) != 2 || pending[0].msg.Content != "three" || pending[1].msg.Content != "four" {
+		t.Errorf("expected three and four in the offline queue, got %+v", pending)
+	}
+}
+
+// TestParseSlowConsumerPolicy checks the accepted policy names.
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	for _, name := range []string{"drop_oldest", "disconnect", "spill"} {
+		if _, err := parseSlowConsumerPolicy(name); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", name, err)
+		}
+	}
+	if _, err := parseSlowConsumerPolicy("block"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,128 @@
+// This file implements room discovery. Rooms are private by default: only
+// their members know they exist. The owner or an admin can make a room
+// public ("set_visibility"), which lists it for everyone ("list_rooms") and
+// lets anyone join it without an invitation ("join_room").
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Named string types validated with a method
+//   - Sorting with slices.SortFunc and cmp.Compare
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// Visibility says who can find a room.
+type Visibility string
+
+const (
+	VisibilityPrivate Visibility = "private"
+	VisibilityPublic  Visibility = "public"
+)
+
+// valid reports whether v is a known visibility.
+func (v Visibility) valid() bool {
+	return v == VisibilityPrivate || v == VisibilityPublic
+}
+
+// RoomSummary describes one room in a "room_list" reply. Joined and Role are
+// from the point of view of the user who asked.
+type RoomSummary struct {
+	ID         string     `json:"id"`
+	Type       RoomType   `json:"type"`
+	Name       string     `json:"name"`
+	Topic      string     `json:"topic,omitempty"`
+	Visibility Visibility `json:"visibility"`
+	Members    int        `json:"members"`
+	Joined     bool       `json:"joined"`
+	Role       Role       `json:"role,omitempty"`
+}
+
+// listRooms returns the rooms user belongs to followed by the public rooms
+// they don't, each group sorted by ID.
+//
+// LEARNING POINT — slices.SortFunc and cmp.Compare:
+// slices.SortFunc sorts with a comparison function returning a negative
+// number, zero or a positive number, like C's qsort. cmp.Compare produces
+// exactly that for any ordered type, so only the tie-break on Joined needs
+// writing out by hand.
+func (h *Hub) listRooms(user string) []RoomSummary {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var rooms []RoomSummary
+	for id, room := range h.rooms {
+		joined := room.Members[user]
+		if !joined && room.Visibility != VisibilityPublic {
+			continue
+		}
+		rooms = append(rooms, RoomSummary{
+			ID:         id,
+			Type:       room.Type,
+			Name:       room.Name,
+			Topic:      room.Topic,
+			Visibility: room.Visibility,
+			Members:    len(room.Members),
+			Joined:     joined,
+			Role:       room.role(user),
+		})
+	}
+	slices.SortFunc(rooms, func(a, b RoomSummary) int {
+		if a.Joined != b.Joined {
+			if a.Joined {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return rooms
+}
+
+// joinPublicRoom adds user to a public room. It returns who to tell
+// (including the new member; see noticeList), or an error message string on
+// failure. Private rooms are reported as not existing, so that their names
+// don't leak.
+func (h *Hub) joinPublicRoom(roomName, user string) ([]string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists || (room.Visibility != VisibilityPublic && !room.Members[user]) {
+		return nil, fmt.Sprintf("there is no public room %q", roomName)
+	}
+	if room.Members[user] {
+		return nil, fmt.Sprintf("you are already a member of room %q", roomName)
+	}
+	if errMsg := checkCapacity(room); errMsg != "" {
+		return nil, errMsg
+	}
+	room.Members[user] = true
+	// Any invitation they had to this room is moot now.
+	h.invitations.take(user, roomName)
+	slog.Info("member joined", "room", roomName, "user", user)
+	h.events.publish(Event{Type: "member_added", Room: roomName, User: user})
+	return room.noticeList(user), ""
+}
+
+// setVisibility makes a room public or private on behalf of requester, who
+// must be allowed to change its settings. It returns the room's members, so
+// they can be told, or an error message string on failure.
+func (h *Hub) setVisibility(roomName, requester string, v Visibility) ([]string, string) {
+	if !v.valid() {
+		return nil, fmt.Sprintf("visibility must be %q or %q", VisibilityPublic, VisibilityPrivate)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if errMsg := checkPermission(room, requester, permEditSettings); errMsg != "" {
+		return nil, errMsg
+	}
+	room.Visibility = v
+	slog.Info("room visibility changed", "room", roomName, "user", requester, "visibility", v)
+	return memberList(room), ""
+}
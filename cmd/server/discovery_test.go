@@ -0,0 +1,108 @@
+// This file contains tests for room discovery (defined in discovery.go).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TestListRooms verifies that a user sees their own rooms first and then the
+// public rooms they are not in, but never other people's private rooms.
+func TestListRooms(t *testing.T) {
+	h := NewHub()
+	h.createRoom("zeta", "alice")
+	h.createRoom("lobby", "bob")
+	h.createRoom("secret", "bob")
+	h.createRoom("announcements", "bob")
+	h.setVisibility("lobby", "bob", VisibilityPublic)
+	h.setVisibility("announcements", "bob", VisibilityPublic)
+	h.addToRoom("lobby", "bob", "carol")
+
+	var got []string
+	for _, r := range h.listRooms("alice") {
+		got = append(got, r.Name)
+	}
+	if strings.Join(got, ",") != "zeta,announcements,lobby" {
+		t.Errorf("unexpected rooms %v", got)
+	}
+	rooms := h.listRooms("alice")
+	if !rooms[0].Joined || rooms[0].Role != RoleOwner || rooms[2].Joined || rooms[2].Members != 2 {
+		t.Errorf("unexpected summaries %+v", rooms)
+	}
+}
+
+// TestJoinPublicRoom verifies that only public rooms can be joined without
+// an invitation, and that private rooms look like they don't exist.
+func TestJoinPublicRoom(t *testing.T) {
+	h := NewHub()
+	h.createRoom("lobby", "bob")
+	h.createRoom("secret", "bob")
+	h.setVisibility("lobby", "bob", VisibilityPublic)
+
+	if _, errMsg := h.joinPublicRoom("secret", "alice"); errMsg != `there is no public room "secret"` {
+		t.Errorf("unexpected error for a private room: %q", errMsg)
+	}
+	if _, errMsg := h.joinPublicRoom("lobby", "alice"); errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if h.rooms["lobby"].role("alice") != RoleMember {
+		t.Error("expected alice to join as a plain member")
+	}
+	if _, errMsg := h.setVisibility("lobby", "alice", VisibilityPrivate); errMsg == "" {
+		t.Error("expected a plain member not to be able to change visibility")
+	}
+	if errMsg := h.createRoom("inv-lobby", "alice"); errMsg == "" {
+		t.Error("expected a room name that looks like a join code to be refused")
+	}
+}
+
+// TestDiscoveryViaWebSocket creates a public room and has another user find
+// and join it.
+func TestDiscoveryViaWebSocket(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+
+	send(alice, Message{Type: "create_room", Content: "lobby", Visibility: "everyone"})
+	if got := readUntil(t, ctx, alice, "error"); !strings.Contains(got.Content, "visibility") {
+		t.Errorf("expected a bad visibility to be rejected, got %+v", got)
+	}
+	send(alice, Message{Type: "create_room", Content: "lobby", Visibility: VisibilityPublic})
+	readUntil(t, ctx, alice, "room_created")
+
+	send(bob, Message{Type: "list_rooms"})
+	list := readUntil(t, ctx, bob, "room_list")
+	if len(list.Rooms) != 1 || list.Rooms[0].Name != "lobby" || list.Rooms[0].Joined {
+		t.Fatalf("unexpected room list %+v", list.Rooms)
+	}
+
+	send(bob, Message{Type: "join_room", Room: "lobby"})
+	if got := readUntil(t, ctx, alice, "member_joined"); got.Sender != "bob" {
+		t.Errorf("expected alice to hear bob joined, got %+v", got)
+	}
+}
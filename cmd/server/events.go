@@ -0,0 +1,157 @@
+// This file implements the hub's event feed: a stream of notable things that
+// happen (users connecting and disconnecting, rooms being created, members
+// joining and leaving, announcements) that operators can watch live with
+// GET /admin/events or `admin events`.
+//
+// Events are published by the hub as state changes and fanned out to every
+// subscriber. A subscriber that can't keep up loses events rather than
+// slowing the hub down: this is a monitoring aid, not a log.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - A publish/subscribe fan-out with one buffered channel per subscriber
+//   - Server-Sent Events: streaming over a plain HTTP response with
+//     http.Flusher
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// eventBuffer is how many events may wait for a slow subscriber before
+	// further ones are dropped for it.
+	eventBuffer = 64
+
+	// eventKeepAlive is how often an idle event stream sends a comment, so
+	// that proxies don't time it out and a vanished client is noticed.
+	eventKeepAlive = 30 * time.Second
+)
+
+// Event is one entry in the event feed. Which of the optional fields are set
+// depends on Type:
+//
+//	connected, disconnected   User, Conn
+//	room_created              Room, User (the creator), Detail (group or channel)
+//	member_added              Room, User (the new member), By (the inviter)
+//	member_left               Room, User
+//	member_removed            Room, User, By (the owner; empty for an admin)
+//	role_changed              Room, User, By (empty if automatic), Detail (the role)
+//	room_updated              Room, By, Detail (the fields changed, comma-separated)
+//	room_deleted              Room
+//	announcement              Detail (the text)
+//	user_disconnected         User, Detail (the reason)
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	Conn   string    `json:"conn,omitempty"`
+	Room   string    `json:"room,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// eventBus fans events out to subscribers.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan Event]bool
+	closed bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan Event]bool)}
+}
+
+// publish stamps ev with the current time and offers it to every
+// subscriber without blocking.
+func (b *eventBus) publish(ev Event) {
+	ev.Time = time.Now().UTC()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default: // subscriber is behind; it misses this one
+		}
+	}
+}
+
+// subscribe returns a channel that receives events from now on, and a
+// function to stop receiving them. The channel is closed when unsubscribe is
+// called or the bus is closed.
+func (b *eventBus) subscribe() (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan Event, eventBuffer)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = true
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subs[ch] {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every subscription. It is called on shutdown so that open event
+// streams finish and don't hold up the admin listener's Shutdown.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// adminEventsHandler streams the event feed as Server-Sent Events, one
+// JSON-encoded Event per "data:" line, until the client goes away or the
+// server shuts down.
+//
+// LEARNING POINT — Server-Sent Events and http.Flusher:
+// SSE is just a long-lived HTTP response with Content-Type text/event-stream
+// whose body is written a few lines at a time. net/http buffers response
+// bodies, so after each event the handler calls Flush to push it to the
+// client immediately. Not every ResponseWriter can flush, hence the type
+// assertion.
+func (s *Server) adminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := s.hub.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,102 @@
+// This file contains tests for the event feed (defined in events.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Reading a streaming HTTP response line by line with bufio.Scanner
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TestEventBusSlowSubscriber verifies that a subscriber that stops reading
+// loses events instead of blocking the publisher, and that close ends the
+// subscription.
+func TestEventBusSlowSubscriber(t *testing.T) {
+	b := newEventBus()
+	events, _ := b.subscribe()
+	for range eventBuffer + 10 {
+		b.publish(Event{Type: "connected"}) // must not block
+	}
+	b.close()
+
+	n := 0
+	for range events {
+		n++
+	}
+	if n != eventBuffer {
+		t.Errorf("expected the %d buffered events, got %d", eventBuffer, n)
+	}
+	late, _ := b.subscribe()
+	if _, ok := <-late; ok {
+		t.Error("expected subscribing to a closed bus to return a closed channel")
+	}
+}
+
+// TestAdminEventsStream connects a user while an admin is watching
+// /admin/events and checks that the stream reports it.
+func TestAdminEventsStream(t *testing.T) {
+	s, wsURL := newAdminTestServer(t)
+	admin := httptest.NewServer(AdminRouter(s))
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", admin.URL+"/admin/events", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	// The handler has subscribed by the time the headers arrive, so this
+	// connection's events cannot be missed.
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.CloseNow()
+	data, _ := json.Marshal(Message{Type: "create_room", Content: "general"})
+	alice.Write(ctx, websocket.MessageText, data)
+
+	var got []string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(got) < 2 && scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		if ev.Time.IsZero() {
+			t.Errorf("expected event %+v to be timestamped", ev)
+		}
+		got = append(got, ev.Type+":"+ev.User+ev.Room)
+	}
+	if strings.Join(got, " ") != "connected:alice room_created:alicegeneral" {
+		t.Errorf("unexpected events %v", got)
+	}
+
+	// Shutting down ends the stream.
+	go s.Shutdown(ctx)
+	for scanner.Scan() {
+	}
+	if ctx.Err() != nil {
+		t.Error("expected the event stream to end on shutdown")
+	}
+}
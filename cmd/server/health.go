@@ -0,0 +1,117 @@
+// This file implements the endpoints that load balancers, orchestrators and
+// deploy scripts use to decide what to do with a server instance:
+//
+//	GET /healthz  is the process alive? (restart it if not)
+//	GET /readyz   should it receive traffic? (stop routing to it if not)
+//	GET /version  what build is running, for how long, and how busy is it?
+//
+// Liveness and readiness are deliberately different questions. A server that
+// is draining connections during a graceful shutdown is perfectly healthy —
+// restarting it would cut those connections off — but it must not be sent
+// new clients, so /readyz fails while /healthz keeps succeeding.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - runtime/debug.ReadBuildInfo to report the version a binary was built from
+//   - Package-level variables set at link time with -ldflags "-X"
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// version is the release this binary was built as. It is "dev" unless set at
+// build time:
+//
+//	go build -ldflags "-X main.version=v1.4.0" ./cmd/server
+//
+// LEARNING POINT — -ldflags "-X":
+// The linker can overwrite the value of a package-level string variable as
+// it builds the binary. That is how release pipelines stamp a version into a
+// Go program without generating source files.
+var version = "dev"
+
+// startTime is when the process started, for the uptime in /version.
+var startTime = time.Now()
+
+// healthzHandler reports that the process is up and serving HTTP. It checks
+// nothing else on purpose: if it fails, the process gets restarted, and no
+// dependency being briefly unavailable is a reason to do that.
+//
+// LEARNING POINT — http.HandlerFunc Signature:
+// Any function with the signature func(http.ResponseWriter, *http.Request) can
+// be used as an HTTP handler. http.ResponseWriter is an interface that lets you
+// write the HTTP response (status code, headers, body). *http.Request contains
+// all information about the incoming request (method, URL, headers, body).
+//
+// fmt.Fprint writes to any io.Writer. Since http.ResponseWriter implements
+// io.Writer, we can write the response body directly with fmt.Fprint.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
+
+// readyzHandler reports whether the server should be sent new clients: the
+// message store must be usable and the server must not be shutting down.
+// It answers 503 Service Unavailable with the reason otherwise.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.hub.accepting() {
+		http.Error(w, "not ready: server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.store.Ping(); err != nil {
+		http.Error(w, "not ready: message store unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ready")
+}
+
+// versionInfo is the body of a /version response.
+type versionInfo struct {
+	Version     string    `json:"version"`
+	Revision    string    `json:"revision,omitempty"`
+	RevisionAt  string    `json:"revision_time,omitempty"`
+	Modified    bool      `json:"modified,omitempty"`
+	GoVersion   string    `json:"go_version"`
+	StartedAt   time.Time `json:"started_at"`
+	Uptime      string    `json:"uptime"`
+	Users       int       `json:"connected_users"`
+	Connections int       `json:"connections"`
+}
+
+// versionHandler reports build information, uptime and how many users are
+// connected.
+//
+// LEARNING POINT — debug.ReadBuildInfo:
+// The go command embeds the module version and, when building inside a git
+// checkout, the commit hash, commit time and whether the tree had
+// uncommitted changes ("vcs.*" settings). ReadBuildInfo exposes them at run
+// time, so even an unstamped binary can say exactly what it was built from.
+func (s *Server) versionHandler(w http.ResponseWriter, r *http.Request) {
+	info := versionInfo{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		StartedAt: startTime.UTC(),
+		Uptime:    time.Since(startTime).Round(time.Second).String(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.time":
+				info.RevisionAt = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	g := s.hub.gauges()
+	info.Users, info.Connections = g.users, g.connections
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,128 @@
+// This file implements connection heartbeats. Every connection gets a
+// goroutine that pings the client at a fixed interval; a client that does not
+// answer within the timeout is considered gone, and its connection is closed
+// and unregistered.
+//
+// Without heartbeats, a client that vanishes without closing its socket (a
+// laptop lid shut, a phone losing signal) leaves a half-open TCP connection
+// behind. Nothing is ever read from it, so the read loop never fails, and
+// the user appears online — and has messages "delivered" into the void —
+// until the operating system gives up on the connection, which can take
+// hours.
+//
+// Each successful ping also measures the round-trip time to the client, kept
+// per connection for diagnostics (see connectionStats).
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - time.Ticker for periodic work
+//   - WebSocket ping/pong control frames
+//   - sync/atomic types for values written by one goroutine and read by others
+//   - sort.Slice for ordering a snapshot
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultPingInterval is how often the server pings each connection.
+	defaultPingInterval = 30 * time.Second
+
+	// defaultPingTimeout is how long the server waits for a pong before
+	// treating the connection as dead.
+	defaultPingTimeout = 10 * time.Second
+)
+
+// errMissedHeartbeat is the cancellation cause for a connection whose client
+// stopped answering pings.
+var errMissedHeartbeat = errors.New("missed heartbeat")
+
+// heartbeat pings the client every h.pingInterval until the connection stops.
+// A ping that is not answered within h.pingTimeout stops the connection; its
+// writer then closes the socket, which ends the read loop in wsHandler and
+// unregisters the device.
+//
+// LEARNING POINT — Pings Need a Reader:
+// websocket.Conn.Ping sends a ping frame and waits for the matching pong, but
+// pongs are only processed while some goroutine is inside Read. On the server
+// that is wsHandler's read loop, so heartbeat can simply block in Ping.
+//
+// LEARNING POINT — time.Ticker:
+// A Ticker delivers the current time on its channel C at a fixed interval.
+// Always Stop a ticker when you are done with it so the runtime can release
+// it.
+func (c *connection) heartbeat() {
+	ticker := time.NewTicker(c.hub.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(c.ctx, c.hub.pingTimeout)
+			start := time.Now()
+			err := c.ws.Ping(ctx)
+			cancel()
+			if c.ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				c.log.Warn("missed heartbeat", "err", err)
+				c.cancel(errMissedHeartbeat)
+				return
+			}
+			rtt := time.Since(start)
+			c.rtt.Store(int64(rtt))
+			c.lastPong.Store(time.Now().UnixNano())
+			c.log.Debug("pong", "rtt", rtt)
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// connectionInfo describes one live connection for diagnostics.
+//
+// LEARNING POINT — time.Duration in JSON:
+// A time.Duration is an int64 count of nanoseconds, and encoding/json writes
+// it as exactly that number. The field name says so, to spare readers the
+// guesswork.
+type connectionInfo struct {
+	User        string        `json:"user"`
+	ID          string        `json:"id"`
+	ConnectedAt time.Time     `json:"connected_at"`
+	LastPong    time.Time     `json:"last_pong,omitzero"`
+	RTT         time.Duration `json:"rtt_ns"`
+}
+
+// connectionStats returns a snapshot of every live connection, ordered by
+// user and then by connection time. RTT and LastPong are zero until the
+// connection's first heartbeat completes.
+func (h *Hub) connectionStats() []connectionInfo {
+	h.mu.RLock()
+	var stats []connectionInfo
+	for user, devices := range h.clients {
+		for conn := range devices {
+			info := connectionInfo{
+				User:        user,
+				ID:          conn.id,
+				ConnectedAt: conn.connectedAt,
+				RTT:         time.Duration(conn.rtt.Load()),
+			}
+			if ns := conn.lastPong.Load(); ns != 0 {
+				info.LastPong = time.Unix(0, ns).UTC()
+			}
+			stats = append(stats, info)
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].User != stats[j].User {
+			return stats[i].User < stats[j].User
+		}
+		return stats[i].ConnectedAt.Before(stats[j].ConnectedAt)
+	})
+	return stats
+}
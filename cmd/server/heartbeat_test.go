@@ -0,0 +1,95 @@
+// This file contains tests for connection heartbeats (defined in
+// heartbeat.go). They run against a real server with the ping interval
+// shortened to milliseconds.
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Polling for an asynchronous outcome with a deadline
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// eventually polls cond every few milliseconds until it returns true, failing
+// the test if that takes longer than two seconds.
+//
+// LEARNING POINT — Polling Instead of Sleeping:
+// A fixed time.Sleep is either too short (flaky on a slow CI machine) or too
+// long (slow everywhere else). Polling returns as soon as the condition
+// holds and only waits the full deadline when something is actually wrong.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestHeartbeatReapsSilentClient verifies that a client that stops answering
+// pings is unregistered. A nhooyr client only answers pings while something
+// is reading from the connection, so a client that never calls Read behaves
+// like one whose network has silently gone away.
+func TestHeartbeatReapsSilentClient(t *testing.T) {
+	s := newTestServer()
+	s.hub.pingInterval = 20 * time.Millisecond
+	s.hub.pingTimeout = 50 * time.Millisecond
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.CloseNow()
+
+	eventually(t, "alice to register", func() bool { return s.hub.devices("alice") != nil })
+	eventually(t, "alice to be reaped", func() bool { return s.hub.devices("alice") == nil })
+}
+
+// TestHeartbeatMeasuresRTT verifies that a responsive client stays connected
+// and gets a round-trip time recorded.
+func TestHeartbeatMeasuresRTT(t *testing.T) {
+	s := newTestServer()
+	s.hub.pingInterval = 20 * time.Millisecond
+	s.hub.pingTimeout = time.Second
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	go func() {
+		for {
+			if _, _, err := alice.Read(ctx); err != nil {
+				return
+			}
+		}
+	}()
+
+	eventually(t, "a measured RTT", func() bool {
+		stats := s.hub.connectionStats()
+		return len(stats) == 1 && stats[0].RTT > 0 && !stats[0].LastPong.IsZero()
+	})
+	if stats := s.hub.connectionStats(); stats[0].User != "alice" {
+		t.Errorf("expected the connection to belong to alice, got %+v", stats[0])
+	}
+}
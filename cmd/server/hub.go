@@ -15,8 +15,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
+	"time"
 
 	// nhooyr.io/websocket is a popular, minimal WebSocket library for Go.
 	// It's preferred over the older gorilla/websocket for new projects because
@@ -25,16 +30,6 @@ import (
 	"nhooyr.io/websocket"
 )
 
-// connection wraps a WebSocket connection. This thin wrapper struct is a common
-// Go pattern — it lets you attach additional per-connection state later (e.g.,
-// send channels, metadata) without changing the Hub's interface.
-//
-// In Go, lowercase struct names (unexported) are only visible within the same
-// package. This is intentional: connection is an internal implementation detail.
-type connection struct {
-	ws *websocket.Conn
-}
-
 // Message represents a chat message or command sent between clients and the server.
 //
 // LEARNING POINT — Struct Tags:
@@ -48,15 +43,75 @@ type connection struct {
 //
 // The Type field determines how the message is routed:
 //   - "" (empty) or unrecognized: direct message to a single recipient
-//   - "create_room": create a new chat room (Content = room name)
+//   - "create_room": create a new chat room (Content = room name, Visibility
+//     optionally "public", RoomType optionally "channel")
 //   - "invite": invite a user to a room (Recipient = user, Room = room name)
 //   - "room_msg": send a message to all members of a room
+//
+// ID, Timestamp and Seq are assigned by the server when it accepts a direct
+// or room message; anything a client puts in them is overwritten. Seq counts
+// up from 1 within each conversation (see store.go), so clients can sort and
+// spot gaps. The server replies to the sender with an "ack" carrying all three.
+//
+// Receipts (see receipts.go) use two more types. The server sends the sender
+// "delivered" when a recipient's connection has written the message, and a
+// client sends "read" (ID = the message read) once it has displayed a
+// message, which the server relays to the original sender. Both carry a
+// Receipt with per-recipient counts.
+//
+// Rooms are managed with "leave_room", "kick", "delete_room", "promote",
+// "demote" and "transfer_ownership" (Room, and Recipient for the member
+// acted on), and invitations are answered with "accept_invite" and
+// "decline_invite" or withdrawn with "revoke_invite". "create_join_code"
+// mints a shareable code for a room (MaxUses and ExpiresIn, in seconds, are
+// optional), "join_by_code" (Content = the code) joins with one and
+// "revoke_join_code" cancels one (see joincodes.go). "list_rooms" asks for
+// the caller's rooms and the public ones, answered with "room_list" (Rooms);
+// "join_room" joins a public room, and "set_visibility" (Visibility) makes a
+// room public or private (see discovery.go). "room_info" asks for a room's
+// name, topic, description and settings, answered with "room_info"
+// (Details), and "update_room" (Update) changes them (see roominfo.go).
+// "follow" and "unfollow" subscribe to and unsubscribe from a channel (see
+// channels.go). The server tells the members affected with notices such as
+// "member_joined", "member_left", "member_removed", "role_changed",
+// "room_updated" and "room_deleted".
+//
+// Broadcast lists (see broadcastlists.go) are managed with "create_list",
+// "add_to_list", "remove_from_list" and "delete_list" (List, and Recipients
+// for the contacts), which are answered with "list_updated" or
+// "list_deleted", and "get_lists", answered with "lists" (Lists).
+// "broadcast" (List, Content) sends Content to everyone on the list as
+// separate direct messages, and the server confirms with "broadcast_sent".
+//
+// When the server is stopping it sends every client "server_shutdown", with
+// RetryAfter saying how many seconds to wait before reconnecting.
+//
+// LEARNING POINT — omitzero:
+// omitempty has no effect on struct types like time.Time, because a struct is
+// never "empty" to encoding/json. Go 1.24 added omitzero, which omits a field
+// whenever it holds its zero value — exactly what we want for a timestamp
+// that control messages never set.
 type Message struct {
-	Type      string `json:"type"`
-	Sender    string `json:"sender"`
-	Recipient string `json:"recipient"`
-	Content   string `json:"content"`
-	Room      string `json:"room,omitempty"`
+	Type       string          `json:"type"`
+	ID         string          `json:"id,omitempty"`
+	Sender     string          `json:"sender"`
+	Recipient  string          `json:"recipient"`
+	Content    string          `json:"content"`
+	Room       string          `json:"room,omitempty"`
+	Timestamp  time.Time       `json:"timestamp,omitzero"`
+	Seq        uint64          `json:"seq,omitempty"`
+	Receipt    *Receipt        `json:"receipt,omitempty"`
+	RetryAfter int             `json:"retry_after,omitempty"`
+	MaxUses    int             `json:"max_uses,omitempty"`
+	ExpiresIn  int             `json:"expires_in,omitempty"`
+	Visibility Visibility      `json:"visibility,omitempty"`
+	Rooms      []RoomSummary   `json:"rooms,omitempty"`
+	Details    *RoomDetails    `json:"details,omitempty"`
+	RoomType   RoomType        `json:"room_type,omitempty"`
+	Update     *RoomUpdate     `json:"update,omitempty"`
+	List       string          `json:"list,omitempty"`
+	Recipients []string        `json:"recipients,omitempty"`
+	Lists      []BroadcastList `json:"lists,omitempty"`
 }
 
 // Room represents a chat room with a set of members.
@@ -67,9 +122,31 @@ type Message struct {
 // map[string]bool, checking membership is simply: if room.Members["alice"] { ... }
 // A missing key returns the zero value (false), so it naturally reads as
 // "alice is not a member."
+//
+// A room has two names. ID is the name it was created with: it is the key in
+// Hub.rooms and what messages, invitations, join codes and the message store
+// refer to, so it never changes. Name is the display name people see; it
+// starts out equal to ID and can be changed like the topic and description
+// (see roominfo.go).
+//
+// Roles holds the owner and admins of the room; members not in it are plain
+// members (see roles.go). Visibility says whether non-members can find and
+// join it (see discovery.go), and Settings who may post, who may edit its
+// info and how many members it may have. Type says whether it is a group,
+// where everyone talks, or a channel, where only admins post (see
+// channels.go).
 type Room struct {
-	Name    string
-	Members map[string]bool
+	ID          string
+	Type        RoomType
+	Name        string
+	Topic       string
+	Description string
+	CreatedAt   time.Time
+	CreatedBy   string
+	Members     map[string]bool
+	Roles       map[string]Role
+	Visibility  Visibility
+	Settings    RoomSettings
 }
 
 // Hub is the central registry that tracks all connected clients and chat rooms.
@@ -83,10 +160,43 @@ type Room struct {
 // The mutex protects BOTH the clients and rooms maps. In Go, maps are NOT safe
 // for concurrent use. Any concurrent read + write (or write + write) to a map
 // will cause a runtime panic. The mutex prevents this.
+//
+// A user may be connected from several devices at once (a phone and a laptop,
+// or two terminals), so clients maps each user ID to the set of that user's
+// live connections. A user is online while the set is non-empty.
+//
+// Messages for users who are offline are parked in the offline queue,
+// delivery state for recent messages lives in the receipt tracker,
+// invitations nobody has answered yet wait in the invitation store, usable
+// join codes in the join code store, and everyone's broadcast lists in the
+// broadcast list store. Each has its own lock (see offline.go, receipts.go,
+// invitations.go, joincodes.go and broadcastlists.go).
+//
+// outboundBuffer and slowConsumer configure the outbound queue of every new
+// connection (see connection.go), and pingInterval and pingTimeout its
+// heartbeat (see heartbeat.go). closing and drained track a shutdown in
+// progress (see shutdown.go). metrics counts traffic for /metrics (see
+// metrics.go), and events feeds GET /admin/events (see events.go).
 type Hub struct {
-	mu      sync.RWMutex
-	clients map[string]*connection
-	rooms   map[string]*Room
+	mu          sync.RWMutex
+	clients     map[string]map[*connection]bool
+	rooms       map[string]*Room
+	offline     *offlineQueue
+	receipts    *receiptTracker
+	invitations *invitationStore
+	joinCodes   *joinCodeStore
+	broadcasts  *broadcastListStore
+
+	outboundBuffer int
+	slowConsumer   slowConsumerPolicy
+	pingInterval   time.Duration
+	pingTimeout    time.Duration
+
+	closing bool
+	drained chan struct{}
+
+	metrics *metrics
+	events  *eventBus
 }
 
 // NewHub creates and returns a new Hub with initialized maps.
@@ -101,12 +211,29 @@ type Hub struct {
 // mutated by multiple goroutines.
 func NewHub() *Hub {
 	return &Hub{
-		clients: make(map[string]*connection),
-		rooms:   make(map[string]*Room),
+		clients:     make(map[string]map[*connection]bool),
+		rooms:       make(map[string]*Room),
+		offline:     newOfflineQueue(defaultOfflineQueueLimit, defaultRoomBacklogLimit, defaultOfflineQueueTTL),
+		receipts:    newReceiptTracker(defaultReceiptLimit),
+		invitations: newInvitationStore(defaultInviteTTL),
+		joinCodes:   newJoinCodeStore(defaultJoinCodeTTL),
+		broadcasts:  newBroadcastListStore(),
+
+		outboundBuffer: defaultOutboundBuffer,
+		slowConsumer:   policySpill,
+		pingInterval:   defaultPingInterval,
+		pingTimeout:    defaultPingTimeout,
+
+		metrics: newMetrics(),
+		events:  newEventBus(),
 	}
 }
 
-// register adds a client connection to the hub, keyed by their user ID.
+// offlineFlushTimeout bounds how long register spends writing queued messages
+// to a newly connected client.
+const offlineFlushTimeout = 10 * time.Second
+
+// register adds one of a user's device connections to the hub.
 //
 // LEARNING POINT — Method Receivers:
 // The (h *Hub) before the function name is a "pointer receiver". This means
@@ -118,41 +245,180 @@ func NewHub() *Hub {
 // no matter how it returns (normal return, panic, etc.). This pattern of
 // Lock + defer Unlock is the standard way to use mutexes in Go — it guarantees
 // the lock is always released, even if a panic occurs between Lock and Unlock.
-func (h *Hub) register(id string, conn *connection) {
+//
+// Any messages queued while the user was offline are taken from the offline
+// queue under the same lock (so no new message can slip into the queue after
+// it has been drained) and then written to the connection in order once the
+// lock has been released. The queue only fills while the user has no
+// connections at all, so it is always the first device back that receives it.
+//
+// register writes the backlog itself, before the connection's writer
+// goroutine is started, so anything sent to the new connection in the
+// meantime waits in its outbound queue and goes out after the backlog.
+//
+// Once the hub is shutting down, register refuses new connections and
+// returns false.
+func (h *Hub) register(id string, conn *connection) bool {
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.clients[id] = conn
-	fmt.Printf("Registered client: %s (Total: %d)\n", id, len(h.clients))
+	if h.closing {
+		h.mu.Unlock()
+		return false
+	}
+	// LEARNING POINT — The "Comma Ok" Idiom:
+	// The two-value map lookup (devices, ok := h.clients[id]) is one of Go's
+	// most common patterns. 'ok' is true if the key exists, false otherwise,
+	// which tells us whether this is the user's first device.
+	devices, ok := h.clients[id]
+	if !ok {
+		devices = make(map[*connection]bool)
+		h.clients[id] = devices
+	}
+	devices[conn] = true
+	pending := h.offline.drain(id)
+	slog.Info("registered", "user", id, "conn", conn.id, "devices", len(devices), "users", len(h.clients))
+	h.events.publish(Event{Type: "connected", User: id, Conn: conn.id})
+	h.mu.Unlock()
+
+	if len(pending) > 0 {
+		h.flushOffline(id, conn, pending)
+	}
+	return true
 }
 
-// unregister removes a client connection from the hub.
+// flushOffline writes queued messages to a freshly registered connection and
+// sends delivery receipts for them. If a write fails, the undelivered
+// messages go back to the front of the queue.
+func (h *Hub) flushOffline(id string, conn *connection, pending []queuedMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), offlineFlushTimeout)
+	defer cancel()
+
+	conn.log.Info("delivering queued messages", "count", len(pending))
+	for i, qm := range pending {
+		data, err := json.Marshal(qm.msg)
+		if err != nil {
+			conn.log.Error("marshal queued message", "id", qm.msg.ID, "err", err)
+			continue
+		}
+		start := time.Now()
+		err = conn.ws.Write(ctx, websocket.MessageText, data)
+		h.metrics.observeWrite(qm.msg.Type, time.Since(start), err)
+		if err != nil {
+			conn.log.Info("deliver queued message", "id", qm.msg.ID, "err", err)
+			h.offline.pushFront(id, pending[i:])
+			return
+		}
+		h.confirmDelivery(qm.msg, id)
+	}
+}
+
+// unregister removes one of a user's device connections from the hub. The
+// user stays online until their last device disconnects.
 //
 // LEARNING POINT — delete() built-in:
 // delete(map, key) removes a key from a map. It's a no-op if the key doesn't
 // exist (no error, no panic). This is safe to call without checking existence.
-func (h *Hub) unregister(id string) {
+// Removing a specific connection (rather than "whatever is stored for this
+// user") matters: a device that disconnects must never take a newer device's
+// registration down with it.
+func (h *Hub) unregister(id string, conn *connection) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	delete(h.clients, id)
-	fmt.Printf("Unregistered client: %s (Total: %d)\n", id, len(h.clients))
+	devices := h.clients[id]
+	delete(devices, conn)
+	if len(devices) == 0 {
+		delete(h.clients, id)
+	}
+	slog.Info("unregistered", "user", id, "conn", conn.id, "devices", len(devices), "users", len(h.clients))
+	h.events.publish(Event{Type: "disconnected", User: id, Conn: conn.id})
+	if h.closing && len(h.clients) == 0 {
+		h.markDrained()
+	}
 }
 
-// get retrieves a client connection by user ID.
+// devices returns the live connections for a user, or nil if they are
+// offline.
 //
 // LEARNING POINT — RLock for Read-Only Access:
 // We use RLock/RUnlock (read lock) instead of Lock/Unlock because this method
 // only reads from the map. Multiple goroutines can hold a read lock
 // simultaneously, which gives better performance under concurrent load.
 //
-// LEARNING POINT — The "Comma Ok" Idiom:
-// The two-value map lookup (conn, ok := h.clients[id]) is one of Go's most
-// common patterns. 'ok' is true if the key exists, false otherwise. This lets
-// callers distinguish between "key exists with zero value" and "key missing".
-func (h *Hub) get(id string) (*connection, bool) {
+// LEARNING POINT — Copying Out of a Locked Map:
+// The set itself must not escape: once the lock is released, register and
+// unregister may modify it while the caller is still ranging over it. Copying
+// the connections into a fresh slice gives the caller a snapshot it can use
+// without holding the lock during (slow) network writes.
+func (h *Hub) devices(id string) []*connection {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	conn, ok := h.clients[id]
-	return conn, ok
+	return h.snapshot(id)
+}
+
+// snapshot copies a user's connections into a slice. The caller must hold
+// h.mu.
+func (h *Hub) snapshot(id string) []*connection {
+	set := h.clients[id]
+	if len(set) == 0 {
+		return nil
+	}
+	conns := make([]*connection, 0, len(set))
+	for conn := range set {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// devicesOrQueue returns the user's connections if they are online. If they
+// are not, msg is added to their offline queue and nil is returned.
+//
+// Checking presence and queueing under one lock matters: with separate lookup
+// and enqueue calls, the user could connect (and drain an empty queue) in
+// between, leaving the message stranded until their next reconnect.
+func (h *Hub) devicesOrQueue(id string, msg Message) []*connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if conns := h.snapshot(id); conns != nil {
+		return conns
+	}
+	h.offline.push(id, msg)
+	return nil
+}
+
+// sendToUser queues msg for every device the user has connected, except skip
+// (which may be nil). It reports how many devices accepted it.
+//
+// Marshaling happens once, however many devices there are; see deliver.
+func (h *Hub) sendToUser(id string, msg Message, skip *connection) int {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("marshal message", "user", id, "type", msg.Type, "err", err)
+		return 0
+	}
+	return deliver(h.devices(id), outbound{data: data, kind: msg.Type}, skip)
+}
+
+// deliverChat queues a marshaled chat message for each of a recipient's
+// devices. The recipient's delivery receipt fires when the first of them has
+// actually written it; later devices find it already counted.
+func (h *Hub) deliverChat(recipient string, conns []*connection, data []byte, msg Message) int {
+	return deliver(conns, outbound{
+		data:      data,
+		kind:      msg.Type,
+		msg:       &msg,
+		onWritten: func() { h.confirmDelivery(msg, recipient) },
+	}, nil)
+}
+
+// deliver queues out on each connection except skip and reports how many
+// accepted it.
+func deliver(conns []*connection, out outbound, skip *connection) int {
+	queued := 0
+	for _, conn := range conns {
+		if conn != skip && conn.enqueue(out) {
+			queued++
+		}
+	}
+	return queued
 }
 
 // createRoom creates a new chat room and adds the creator as the first member.
@@ -166,21 +432,44 @@ func (h *Hub) get(id string) (*connection, bool) {
 // In production code, you'd more commonly see: func createRoom(...) error
 // and use fmt.Errorf("room %q already exists", name) to create the error.
 func (h *Hub) createRoom(name, creator string) string {
+	return h.newRoom(name, creator, RoomTypeGroup)
+}
+
+// newRoom creates a room of type typ owned by creator; see createRoom.
+func (h *Hub) newRoom(name, creator string, typ RoomType) string {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	// Join codes and room names share the /join command, so no room may
+	// look like a join code.
+	if strings.HasPrefix(name, joinCodePrefix) {
+		return fmt.Sprintf("room names cannot start with %q", joinCodePrefix)
+	}
 	if _, exists := h.rooms[name]; exists {
 		return fmt.Sprintf("room %q already exists", name)
 	}
+	settings := defaultRoomSettings()
+	if typ == RoomTypeChannel {
+		settings.WhoCanPost = AudienceAdmins
+	}
 	h.rooms[name] = &Room{
-		Name:    name,
-		Members: map[string]bool{creator: true},
+		ID:         name,
+		Type:       typ,
+		Name:       name,
+		CreatedAt:  time.Now().UTC(),
+		CreatedBy:  creator,
+		Members:    map[string]bool{creator: true},
+		Roles:      map[string]Role{creator: RoleOwner},
+		Visibility: VisibilityPrivate,
+		Settings:   settings,
 	}
-	fmt.Printf("Room %q created by %s\n", name, creator)
+	slog.Info("room created", "room", name, "user", creator, "type", typ)
+	h.events.publish(Event{Type: "room_created", Room: name, User: creator, Detail: string(typ)})
 	return ""
 }
 
-// addToRoom adds a user to an existing room. Only existing members can invite.
-// Returns an empty string on success, or an error message string on failure.
+// addToRoom adds a user to an existing room. Only members whose role allows
+// inviting (owners and admins) can invite. Returns an empty string on
+// success, or an error message string on failure.
 //
 // LEARNING POINT — Guard Clauses:
 // The early returns for "room doesn't exist" and "not a member" are called
@@ -194,16 +483,205 @@ func (h *Hub) addToRoom(roomName, inviter, invitee string) string {
 	if !exists {
 		return fmt.Sprintf("room %q does not exist", roomName)
 	}
-	if !room.Members[inviter] {
-		return fmt.Sprintf("you are not a member of room %q", roomName)
+	return h.addToRoomLocked(room, inviter, invitee)
+}
+
+// addToRoomLocked is addToRoom for a room the caller has already looked up.
+// The caller must hold h.mu.
+func (h *Hub) addToRoomLocked(room *Room, inviter, invitee string) string {
+	if errMsg := checkPermission(room, inviter, permInvite); errMsg != "" {
+		return errMsg
+	}
+	if errMsg := checkCapacity(room); errMsg != "" {
+		return errMsg
 	}
 	room.Members[invitee] = true
-	fmt.Printf("User %s invited %s to room %q\n", inviter, invitee, roomName)
+	slog.Info("member invited", "room", room.ID, "user", inviter, "invitee", invitee)
+	h.events.publish(Event{Type: "member_added", Room: room.ID, User: invitee, By: inviter})
 	return ""
 }
 
+// isMember reports whether user is a member of the room.
+func (h *Hub) isMember(roomName, user string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room, exists := h.rooms[roomName]
+	return exists && room.Members[user]
+}
+
 // getRoomMembers returns the list of member IDs for a room.
 // Returns nil if the room doesn't exist or the requester is not a member.
+func (h *Hub) getRoomMembers(roomName, requester string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil
+	}
+	if !room.Members[requester] {
+		return nil
+	}
+	return memberList(room)
+}
+
+// leaveRoom takes a member out of a room at their own request. It returns the
+// others to tell (the members who remain, in a group; see noticeList), the
+// new owner if one had to be appointed, or an error message string on
+// failure.
+//
+// A room is never left without an owner: if the owner leaves, a successor is
+// appointed (see appointSuccessorLocked), and when the last member leaves the
+// room is deleted. The owner of a channel with no admins can't leave it
+// while it has followers (see checkHandover).
+func (h *Hub) leaveRoom(roomName, member string) ([]string, *succession, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if !room.Members[member] {
+		return nil, nil, fmt.Sprintf("you are not a member of room %q", roomName)
+	}
+	if errMsg := checkHandover(room, member); errMsg != "" {
+		return nil, nil, errMsg
+	}
+	notify := without(room.noticeList(member), member)
+	succ := h.removeMemberLocked(room, member, member)
+	if len(room.Members) == 0 {
+		h.deleteRoomLocked(room)
+	}
+	return notify, succ, ""
+}
+
+// kickFromRoom removes member from a room on behalf of requester, who must be
+// allowed to remove members and outrank member. It returns the others to
+// tell and any new owner, as leaveRoom does, or an error message string on
+// failure.
+func (h *Hub) kickFromRoom(roomName, requester, member string) ([]string, *succession, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if errMsg := checkPermission(room, requester, permKick); errMsg != "" {
+		return nil, nil, errMsg
+	}
+	if member == requester {
+		return nil, nil, "you cannot remove yourself; leave the room instead"
+	}
+	if !room.Members[member] {
+		return nil, nil, fmt.Sprintf("%s is not a member of room %q", member, roomName)
+	}
+	if errMsg := checkOutranks(room, requester, member); errMsg != "" {
+		return nil, nil, errMsg
+	}
+	notify := without(room.noticeList(member), member)
+	succ := h.removeMemberLocked(room, member, requester)
+	return notify, succ, ""
+}
+
+// deleteOwnedRoom deletes a room on behalf of requester, who must be allowed
+// to delete it (only the owner is). It returns the members the room had, so
+// they can be told, or an error message string on failure.
+func (h *Hub) deleteOwnedRoom(roomName, requester string) ([]string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if errMsg := checkPermission(room, requester, permDelete); errMsg != "" {
+		return nil, errMsg
+	}
+	h.deleteRoomLocked(room)
+	return memberList(room), ""
+}
+
+// deleteRoom removes a room and any messages still queued for its offline
+// members, without checking who is asking (it is used by the admin API). It
+// returns the members the room had, so they can be told, or an error message
+// if the room does not exist.
+func (h *Hub) deleteRoom(roomName string) ([]string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	h.deleteRoomLocked(room)
+	return memberList(room), ""
+}
+
+// removeFromRoom takes a member out of a room and drops any of the room's
+// messages still queued for them, without checking who is asking (it is
+// used by the admin API). It returns the others to tell and any new owner,
+// as leaveRoom does, or an error message string on failure.
+func (h *Hub) removeFromRoom(roomName, member string) ([]string, *succession, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if !room.Members[member] {
+		return nil, nil, fmt.Sprintf("%s is not a member of room %q", member, roomName)
+	}
+	if errMsg := checkHandover(room, member); errMsg != "" {
+		return nil, nil, errMsg
+	}
+	notify := without(room.noticeList(member), member)
+	succ := h.removeMemberLocked(room, member, "")
+	if len(room.Members) == 0 {
+		h.deleteRoomLocked(room)
+	}
+	return notify, succ, ""
+}
+
+// removeMemberLocked takes member out of room, drops the room's messages
+// still queued for them and, if they owned the room, appoints a successor,
+// who is returned (nil if there is none) so the room can be told. by is who
+// removed them: the member themselves if they left, or "" for an
+// administrator. The caller must hold h.mu.
+//
+// LEARNING POINT — The "Locked" Suffix:
+// Go's mutexes are not reentrant: a goroutine that calls Lock twice on the
+// same mutex deadlocks. Helpers that expect the caller to already hold the
+// lock are conventionally named xxxLocked so that nobody calls them without
+// it, and so that the public methods can share them.
+func (h *Hub) removeMemberLocked(room *Room, member, by string) *succession {
+	delete(room.Members, member)
+	delete(room.Roles, member)
+	h.offline.forgetRoom(member, room.ID)
+	if by == member {
+		slog.Info("member left", "room", room.ID, "user", member)
+		h.events.publish(Event{Type: "member_left", Room: room.ID, User: member})
+	} else {
+		slog.Info("member removed", "room", room.ID, "user", member, "by", by)
+		h.events.publish(Event{Type: "member_removed", Room: room.ID, User: member, By: by})
+	}
+	if successor := h.appointSuccessorLocked(room); successor != "" {
+		return &succession{owner: successor, notify: room.roleNoticeList(successor)}
+	}
+	return nil
+}
+
+// deleteRoomLocked removes room from the hub and drops any of its messages
+// still queued for offline members, and any invitations and join codes for
+// it. The caller must hold h.mu.
+func (h *Hub) deleteRoomLocked(room *Room) {
+	delete(h.rooms, room.ID)
+	for m := range room.Members {
+		h.offline.forgetRoom(m, room.ID)
+	}
+	h.invitations.forgetRoom(room.ID)
+	h.joinCodes.forgetRoom(room.ID)
+	slog.Info("room deleted", "room", room.ID, "members", len(room.Members))
+	h.events.publish(Event{Type: "room_deleted", Room: room.ID})
+}
+
+// memberList returns the members of room in no particular order.
 //
 // LEARNING POINT — Slice Pre-allocation with make():
 // make([]string, 0, len(room.Members)) creates a slice with length 0 but
@@ -217,16 +695,7 @@ func (h *Hub) addToRoom(roomName, inviter, invitee string) string {
 // keys (not values), you can omit the second variable. The iteration order is
 // intentionally randomized by Go's runtime to prevent code from depending on
 // a specific order.
-func (h *Hub) getRoomMembers(roomName, requester string) []string {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	room, exists := h.rooms[roomName]
-	if !exists {
-		return nil
-	}
-	if !room.Members[requester] {
-		return nil
-	}
+func memberList(room *Room) []string {
 	members := make([]string, 0, len(room.Members))
 	for m := range room.Members {
 		members = append(members, m)
@@ -56,13 +56,38 @@ func TestHubRegisterUnregister(t *testing.T) {
 		t.Errorf("expected client %s to be registered", clientID)
 	}
 
-	h.unregister(clientID)
+	h.unregister(clientID, conn)
 
 	if _, ok := h.clients[clientID]; ok {
 		t.Errorf("expected client %s to be unregistered", clientID)
 	}
 }
 
+// TestHubMultipleDevices verifies that a user stays online until their last
+// device disconnects, and that a device disconnecting only removes itself.
+func TestHubMultipleDevices(t *testing.T) {
+	h := NewHub()
+	phone := &connection{id: "phone"}
+	laptop := &connection{id: "laptop"}
+
+	h.register("alice", phone)
+	h.register("alice", laptop)
+	if got := len(h.devices("alice")); got != 2 {
+		t.Fatalf("expected 2 devices, got %d", got)
+	}
+
+	h.unregister("alice", phone)
+	devices := h.devices("alice")
+	if len(devices) != 1 || devices[0] != laptop {
+		t.Fatalf("expected only the laptop to remain, got %v", devices)
+	}
+
+	h.unregister("alice", laptop)
+	if devices := h.devices("alice"); devices != nil {
+		t.Errorf("expected alice to be offline, got %v", devices)
+	}
+}
+
 // TestCreateRoom verifies that a room can be created and the creator becomes
 // a member.
 //
@@ -195,3 +220,68 @@ func TestGetRoomMembersNonExistent(t *testing.T) {
 		t.Error("expected nil for nonexistent room")
 	}
 }
+
+// TestLeaveRoom verifies that leaving hands ownership on and that the room
+// goes away with its last member.
+func TestLeaveRoom(t *testing.T) {
+	h := NewHub()
+	h.createRoom("general", "alice")
+	h.addToRoom("general", "alice", "carol")
+	h.addToRoom("general", "alice", "bob")
+
+	if _, _, errMsg := h.leaveRoom("general", "dave"); errMsg == "" {
+		t.Error("expected error when a non-member leaves")
+	}
+	remaining, _, errMsg := h.leaveRoom("general", "alice")
+	if errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if len(remaining) != 2 {
+		t.Errorf("expected 2 remaining members, got %v", remaining)
+	}
+	if owner := h.rooms["general"].owner(); owner != "bob" {
+		t.Errorf("expected bob to take over the room, got %q", owner)
+	}
+
+	h.leaveRoom("general", "bob")
+	h.leaveRoom("general", "carol")
+	if _, exists := h.rooms["general"]; exists {
+		t.Error("expected the room to be deleted when its last member left")
+	}
+}
+
+// TestKickAndDeleteRequireOwner verifies that only a room's owner can remove
+// members or delete it.
+func TestKickAndDeleteRequireOwner(t *testing.T) {
+	h := NewHub()
+	h.createRoom("general", "alice")
+	h.addToRoom("general", "alice", "bob")
+	h.addToRoom("general", "alice", "carol")
+
+	if _, _, errMsg := h.kickFromRoom("general", "bob", "carol"); errMsg == "" {
+		t.Error("expected error when a non-owner kicks")
+	}
+	if _, _, errMsg := h.kickFromRoom("general", "alice", "alice"); errMsg == "" {
+		t.Error("expected error when the owner kicks themselves")
+	}
+	if _, _, errMsg := h.kickFromRoom("general", "alice", "carol"); errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if h.rooms["general"].Members["carol"] {
+		t.Error("expected carol to be removed")
+	}
+
+	if _, errMsg := h.deleteOwnedRoom("general", "bob"); errMsg == "" {
+		t.Error("expected error when a non-owner deletes the room")
+	}
+	members, errMsg := h.deleteOwnedRoom("general", "alice")
+	if errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if len(members) != 2 {
+		t.Errorf("expected the 2 former members, got %v", members)
+	}
+	if _, exists := h.rooms["general"]; exists {
+		t.Error("expected the room to be deleted")
+	}
+}
@@ -0,0 +1,244 @@
+// This file implements room invitations. Inviting someone no longer adds them
+// to the room: it leaves a pending invitation that the invitee accepts
+// (accept_invite) or declines (decline_invite). Until then the inviter can
+// take it back (revoke_invite), and after the invitation TTL it simply
+// expires.
+//
+// Pending invitations are kept per invitee, at most one per room, and are
+// shown again every time the invitee connects, so someone who was offline
+// when they were invited still finds out.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Expiring entries checked lazily on read rather than by a timer
+//   - Returning copies (a []invitation) instead of the store's own maps
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultInviteTTL is how long an invitation can be accepted.
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+// invitation is an offer for Invitee to join Room, made by Inviter.
+type invitation struct {
+	Room    string
+	Inviter string
+	Invitee string
+	Expires time.Time
+}
+
+// invitationStore holds pending invitations.
+//
+// LEARNING POINT — Lazy Expiry:
+// Nothing deletes an invitation the moment it expires. Instead every read
+// (take, pendingFor) ignores and prunes expired entries. That needs no
+// background goroutine and is exact from the user's point of view; the cost
+// is that an expired invitation nobody looks at again keeps its few bytes
+// until its room is deleted.
+type invitationStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	pending map[string]map[string]invitation // invitee -> room -> invitation
+}
+
+// newInvitationStore creates a store whose invitations last ttl.
+func newInvitationStore(ttl time.Duration) *invitationStore {
+	return &invitationStore{
+		ttl:     ttl,
+		now:     time.Now,
+		pending: make(map[string]map[string]invitation),
+	}
+}
+
+// add records an invitation, replacing (and so renewing) any pending one for
+// the same invitee and room.
+func (s *invitationStore) add(room, inviter, invitee string) invitation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv := invitation{Room: room, Inviter: inviter, Invitee: invitee, Expires: s.now().Add(s.ttl)}
+	if s.pending[invitee] == nil {
+		s.pending[invitee] = make(map[string]invitation)
+	}
+	s.pending[invitee][room] = inv
+	return inv
+}
+
+// get returns invitee's invitation to room without removing it. ok is false
+// if there is none or it has expired.
+func (s *invitationStore) get(invitee, room string) (inv invitation, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok = s.pending[invitee][room]
+	if !ok || !s.now().Before(inv.Expires) {
+		return invitation{}, false
+	}
+	return inv, true
+}
+
+// take removes and returns invitee's invitation to room. ok is false if there
+// is none or it has expired.
+func (s *invitationStore) take(invitee, room string) (inv invitation, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok = s.pending[invitee][room]
+	if !ok {
+		return invitation{}, false
+	}
+	s.removeLocked(invitee, room)
+	if !s.now().Before(inv.Expires) {
+		return invitation{}, false
+	}
+	return inv, true
+}
+
+// revoke removes invitee's invitation to room if inviter made it, and reports
+// whether there was one to remove.
+func (s *invitationStore) revoke(room, inviter, invitee string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.pending[invitee][room]
+	if !ok || inv.Inviter != inviter || !s.now().Before(inv.Expires) {
+		return false
+	}
+	s.removeLocked(invitee, room)
+	return true
+}
+
+// pendingFor returns invitee's unexpired invitations, sorted by room.
+func (s *invitationStore) pendingFor(invitee string) []invitation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var invs []invitation
+	for room, inv := range s.pending[invitee] {
+		if !s.now().Before(inv.Expires) {
+			s.removeLocked(invitee, room)
+			continue
+		}
+		invs = append(invs, inv)
+	}
+	sort.Slice(invs, func(i, j int) bool { return invs[i].Room < invs[j].Room })
+	return invs
+}
+
+// forgetRoom drops every invitation to room, e.g. because it was deleted.
+func (s *invitationStore) forgetRoom(room string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for invitee := range s.pending {
+		s.removeLocked(invitee, room)
+	}
+}
+
+// removeLocked deletes one invitation, and the invitee's map with it if that
+// was their last. The caller must hold s.mu.
+func (s *invitationStore) removeLocked(invitee, room string) {
+	delete(s.pending[invitee], room)
+	if len(s.pending[invitee]) == 0 {
+		delete(s.pending, invitee)
+	}
+}
+
+// inviteToRoom invites invitee to a room on behalf of inviter, who must be
+// allowed to invite. Returns the invitation, or an error message string on
+// failure.
+func (h *Hub) inviteToRoom(roomName, inviter, invitee string) (invitation, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return invitation{}, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if errMsg := checkPermission(room, inviter, permInvite); errMsg != "" {
+		return invitation{}, errMsg
+	}
+	if room.Members[invitee] {
+		return invitation{}, fmt.Sprintf("%s is already a member of room %q", invitee, roomName)
+	}
+	inv := h.invitations.add(roomName, inviter, invitee)
+	slog.Info("invitation sent", "room", roomName, "user", inviter, "invitee", invitee)
+	return inv, ""
+}
+
+// acceptInvite adds invitee to a room they have a pending invitation to. It
+// returns the invitation and who to tell (including the invitee), or an
+// error message string on failure. The invitation is used up either way,
+// unless the room is full: then it can be accepted once there is space.
+//
+// The inviter's permission is checked again: an invitation from someone who
+// has since been demoted, or has left, is no longer good.
+//
+// As in joinByCode, everything from the checks to adding the member happens
+// under one hold of h.mu, so the room can't fill up in between and leave
+// the invitation used up with nobody added.
+func (h *Hub) acceptInvite(roomName, invitee string) (invitation, []string, string) {
+	noInvitation := fmt.Sprintf("you have no pending invitation to room %q", roomName)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return invitation{}, nil, noInvitation
+	}
+	inv, ok := h.invitations.get(invitee, roomName)
+	if !ok {
+		return invitation{}, nil, noInvitation
+	}
+	if errMsg := checkCapacity(room); errMsg != "" {
+		return invitation{}, nil, errMsg
+	}
+	h.invitations.take(invitee, roomName)
+	if checkPermission(room, inv.Inviter, permInvite) != "" {
+		return invitation{}, nil, fmt.Sprintf("your invitation to room %q is no longer valid: %s can no longer invite people to it", roomName, inv.Inviter)
+	}
+	if errMsg := h.addToRoomLocked(room, inv.Inviter, invitee); errMsg != "" {
+		return invitation{}, nil, errMsg
+	}
+	return inv, room.noticeList(invitee), ""
+}
+
+// declineInvite discards invitee's invitation to a room. It returns the
+// invitation, so the inviter can be told, or an error message string if there
+// was none.
+func (h *Hub) declineInvite(roomName, invitee string) (invitation, string) {
+	inv, ok := h.invitations.take(invitee, roomName)
+	if !ok {
+		return invitation{}, fmt.Sprintf("you have no pending invitation to room %q", roomName)
+	}
+	slog.Info("invitation declined", "room", roomName, "user", invitee, "inviter", inv.Inviter)
+	return inv, ""
+}
+
+// revokeInvite withdraws an invitation inviter sent to invitee. Returns an
+// empty string on success, or an error message string on failure.
+func (h *Hub) revokeInvite(roomName, inviter, invitee string) string {
+	if !h.invitations.revoke(roomName, inviter, invitee) {
+		return fmt.Sprintf("you have no pending invitation to room %q for %s", roomName, invitee)
+	}
+	slog.Info("invitation revoked", "room", roomName, "user", inviter, "invitee", invitee)
+	return ""
+}
+
+// invitedMessage is the notice an invitee gets for a pending invitation, both
+// when it is sent and each time they connect until they answer it.
+func invitedMessage(inv invitation) Message {
+	return Message{
+		Type:   "invited",
+		Sender: inv.Inviter,
+		Room:   inv.Room,
+		Content: fmt.Sprintf("%s invited you to room %q (expires %s UTC)",
+			inv.Inviter, inv.Room, inv.Expires.UTC().Format(time.DateTime)),
+	}
+}
+
+// sendPendingInvites tells a newly connected device about its user's pending
+// invitations.
+func (s *Server) sendPendingInvites(userID string, conn *connection) {
+	for _, inv := range s.hub.invitations.pendingFor(userID) {
+		sendJSON(conn, invitedMessage(inv))
+	}
+}
@@ -0,0 +1,128 @@
+// This file contains tests for room invitations (defined in invitations.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Replacing a clock function to test expiry without time.Sleep
+//   - Connecting a user only after something was sent to them
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TestInvitationStoreExpiry verifies that an expired invitation can neither
+// be accepted nor listed.
+func TestInvitationStoreExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newInvitationStore(time.Hour)
+	s.now = func() time.Time { return now }
+
+	s.add("general", "alice", "bob")
+	s.add("random", "alice", "bob")
+	if got := s.pendingFor("bob"); len(got) != 2 || got[0].Room != "general" {
+		t.Fatalf("expected 2 invitations sorted by room, got %+v", got)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := s.take("bob", "general"); ok {
+		t.Error("expected an expired invitation not to be accepted")
+	}
+	if got := s.pendingFor("bob"); len(got) != 0 {
+		t.Errorf("expected no pending invitations, got %+v", got)
+	}
+}
+
+// TestInvitationStoreRevoke verifies that only the inviter can revoke.
+func TestInvitationStoreRevoke(t *testing.T) {
+	s := newInvitationStore(time.Hour)
+	s.add("general", "alice", "bob")
+	if s.revoke("general", "carol", "bob") {
+		t.Error("expected carol not to be able to revoke alice's invitation")
+	}
+	if !s.revoke("general", "alice", "bob") {
+		t.Fatal("expected alice to revoke her invitation")
+	}
+	if _, ok := s.take("bob", "general"); ok {
+		t.Error("expected a revoked invitation not to be accepted")
+	}
+}
+
+// TestInviteDoesNotAddMember verifies that an invitee only joins once they
+// accept, and that an invitation can't be used twice.
+func TestInviteDoesNotAddMember(t *testing.T) {
+	h := NewHub()
+	h.createRoom("general", "alice")
+	if _, errMsg := h.inviteToRoom("general", "alice", "bob"); errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if h.rooms["general"].Members["bob"] {
+		t.Fatal("expected bob not to be a member before accepting")
+	}
+	if _, _, errMsg := h.acceptInvite("general", "bob"); errMsg != "" {
+		t.Fatalf("unexpected error accepting: %s", errMsg)
+	}
+	if !h.rooms["general"].Members["bob"] {
+		t.Error("expected bob to be a member after accepting")
+	}
+	if _, _, errMsg := h.acceptInvite("general", "bob"); errMsg == "" {
+		t.Error("expected the invitation to be used up")
+	}
+	if _, errMsg := h.inviteToRoom("general", "alice", "bob"); errMsg == "" {
+		t.Error("expected an error inviting an existing member")
+	}
+}
+
+// TestInvitationDeliveredOnConnect invites bob while he is offline, then
+// checks that he hears about it when he connects and that declining tells
+// alice.
+func TestInvitationDeliveredOnConnect(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+	send(alice, Message{Type: "create_room", Content: "general"})
+	send(alice, Message{Type: "invite", Room: "general", Recipient: "nobody"})
+	if got := readUntil(t, ctx, alice, "error"); !strings.Contains(got.Content, "no user") {
+		t.Errorf("expected inviting an unknown user to fail, got %+v", got)
+	}
+	addAccount(t, s, "bob")
+	send(alice, Message{Type: "invite", Room: "general", Recipient: "bob"})
+	readUntil(t, ctx, alice, "invite_sent")
+
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+	if got := readUntil(t, ctx, bob, "invited"); got.Room != "general" || got.Sender != "alice" {
+		t.Errorf("unexpected invitation %+v", got)
+	}
+
+	send(bob, Message{Type: "decline_invite", Room: "general"})
+	if got := readUntil(t, ctx, alice, "invite_declined"); got.Sender != "bob" {
+		t.Errorf("unexpected notice %+v", got)
+	}
+	send(bob, Message{Type: "accept_invite", Room: "general"})
+	if got := readUntil(t, ctx, bob, "error"); !strings.Contains(got.Content, "no pending invitation") {
+		t.Errorf("unexpected error %+v", got)
+	}
+}
@@ -0,0 +1,248 @@
+// This file implements join codes: shareable invite links for a room. A
+// member who may invite mints a code ("create_join_code"), passes it around
+// however they like, and anyone holding it can join with "join_by_code"
+// without the inviter knowing their user ID.
+//
+// A code can be single-use or allow a fixed or unlimited number of joins,
+// always expires, and can be revoked early ("revoke_join_code"). Codes all
+// start with "inv-", so they can never be mistaken for a room name.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - crypto/rand.Text for unguessable identifiers
+//   - Validating input at the edge before calling into shared state
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// joinCodePrefix starts every join code.
+	joinCodePrefix = "inv-"
+
+	// joinCodeLength is the number of random characters after the prefix.
+	// Base32 carries 5 bits per character, so 12 characters are 60 bits:
+	// far too many to guess, few enough to read out over the phone.
+	joinCodeLength = 12
+
+	// defaultJoinCodeTTL is how long a code lasts if its creator doesn't say.
+	defaultJoinCodeTTL = 24 * time.Hour
+
+	// maxJoinCodeTTL is the longest a code may be asked to last.
+	maxJoinCodeTTL = 30 * 24 * time.Hour
+)
+
+// joinCode lets whoever holds Code join Room. MaxUses of 0 means unlimited.
+type joinCode struct {
+	Code      string
+	Room      string
+	CreatedBy string
+	Expires   time.Time
+	MaxUses   int
+	Uses      int
+}
+
+// joinCodeStore holds the codes that can still be used.
+type joinCodeStore struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	now   func() time.Time
+	codes map[string]*joinCode
+}
+
+// newJoinCodeStore creates a store whose codes last ttl unless their creator
+// asks otherwise.
+func newJoinCodeStore(ttl time.Duration) *joinCodeStore {
+	return &joinCodeStore{
+		ttl:   ttl,
+		now:   time.Now,
+		codes: make(map[string]*joinCode),
+	}
+}
+
+// create mints a code for room. ttl of 0 means the store's default.
+//
+// LEARNING POINT — crypto/rand.Text:
+// rand.Text (Go 1.24) returns a random string in the base32 alphabet, drawn
+// from the operating system's secure random source. Codes are bearer
+// credentials, so math/rand, which is predictable, would not do.
+func (s *joinCodeStore) create(room, by string, maxUses int, ttl time.Duration) joinCode {
+	if ttl == 0 {
+		ttl = s.ttl
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jc := &joinCode{
+		Code:      joinCodePrefix + strings.ToLower(rand.Text()[:joinCodeLength]),
+		Room:      room,
+		CreatedBy: by,
+		Expires:   s.now().Add(ttl),
+		MaxUses:   maxUses,
+	}
+	s.codes[jc.Code] = jc
+	return *jc
+}
+
+// lookup returns the code if it can still be used, without using it.
+func (s *joinCodeStore) lookup(code string) (joinCode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jc, ok := s.validLocked(code)
+	if !ok {
+		return joinCode{}, false
+	}
+	return *jc, true
+}
+
+// use counts one join against the code and reports whether it was still
+// usable. A code that has reached its last use is removed.
+func (s *joinCodeStore) use(code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jc, ok := s.validLocked(code)
+	if !ok {
+		return false
+	}
+	jc.Uses++
+	if jc.MaxUses > 0 && jc.Uses >= jc.MaxUses {
+		delete(s.codes, code)
+	}
+	return true
+}
+
+// remove deletes a code and returns it, or reports false if there was no
+// usable code to remove.
+func (s *joinCodeStore) remove(code string) (joinCode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jc, ok := s.validLocked(code)
+	if !ok {
+		return joinCode{}, false
+	}
+	delete(s.codes, code)
+	return *jc, true
+}
+
+// forgetRoom drops every code for room, e.g. because it was deleted.
+func (s *joinCodeStore) forgetRoom(room string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for code, jc := range s.codes {
+		if jc.Room == room {
+			delete(s.codes, code)
+		}
+	}
+}
+
+// validLocked returns the code if it exists and has not expired, removing it
+// if it has. The caller must hold s.mu.
+func (s *joinCodeStore) validLocked(code string) (*joinCode, bool) {
+	jc, ok := s.codes[code]
+	if !ok {
+		return nil, false
+	}
+	if !s.now().Before(jc.Expires) {
+		delete(s.codes, code)
+		return nil, false
+	}
+	return jc, true
+}
+
+// createJoinCode mints a join code for a room on behalf of requester, who
+// must be allowed to invite. Returns the code, or an error message string on
+// failure.
+func (h *Hub) createJoinCode(roomName, requester string, maxUses int, ttl time.Duration) (joinCode, string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return joinCode{}, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if errMsg := checkPermission(room, requester, permInvite); errMsg != "" {
+		return joinCode{}, errMsg
+	}
+	jc := h.joinCodes.create(roomName, requester, maxUses, ttl)
+	slog.Info("join code created", "room", roomName, "user", requester, "max_uses", maxUses, "expires", jc.Expires)
+	return jc, ""
+}
+
+// joinByCode adds user to the room code is for. It returns the room and who
+// to tell (including the new member), or an error message string on failure.
+//
+// As with invitations, the code's creator must still be allowed to invite: a
+// code stops working when its creator is demoted or leaves.
+//
+// LEARNING POINT — Check and Act Under One Lock:
+// Checking that there is space, then using the code, then adding the member
+// as three separately locked steps would let two people joining at once
+// both pass the check and overfill the room, or use the code up and then
+// fail to join. Holding h.mu from the first check to the last write makes
+// the whole join one step as far as anyone else can see. The code store's
+// own lock is taken inside it, in the usual order (h.mu first).
+func (h *Hub) joinByCode(code, user string) (string, []string, string) {
+	const invalid = "that join code is invalid or has expired"
+	jc, ok := h.joinCodes.lookup(code)
+	if !ok {
+		return "", nil, invalid
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[jc.Room]
+	if !exists {
+		return "", nil, invalid
+	}
+	// Everything that could stop the join is checked before the code is
+	// used, so that none of them wastes one of its uses.
+	if room.Members[user] {
+		return "", nil, fmt.Sprintf("you are already a member of room %q", room.ID)
+	}
+	if checkPermission(room, jc.CreatedBy, permInvite) != "" {
+		return "", nil, fmt.Sprintf("that join code no longer works: %s can no longer invite people to room %q", jc.CreatedBy, room.ID)
+	}
+	if errMsg := checkCapacity(room); errMsg != "" {
+		return "", nil, errMsg
+	}
+	if !h.joinCodes.use(code) {
+		return "", nil, invalid
+	}
+	if errMsg := h.addToRoomLocked(room, jc.CreatedBy, user); errMsg != "" {
+		return "", nil, errMsg
+	}
+	// As with joinPublicRoom, any invitation they had to this room is moot
+	// now, and would otherwise be sent to them again on every connect.
+	h.invitations.take(user, room.ID)
+	return room.ID, room.noticeList(user), ""
+}
+
+// revokeJoinCode deletes a code on behalf of requester, who must have
+// created it or be allowed to invite to its room. Returns the room the code
+// was for, or an error message string on failure.
+func (h *Hub) revokeJoinCode(code, requester string) (string, string) {
+	const unknown = "no such join code"
+	jc, ok := h.joinCodes.lookup(code)
+	if !ok {
+		return "", unknown
+	}
+	if jc.CreatedBy != requester {
+		h.mu.RLock()
+		room, exists := h.rooms[jc.Room]
+		errMsg := ""
+		if exists {
+			errMsg = checkPermission(room, requester, permInvite)
+		}
+		h.mu.RUnlock()
+		if !exists || errMsg != "" {
+			return "", unknown
+		}
+	}
+	if _, ok := h.joinCodes.remove(code); !ok {
+		return "", unknown
+	}
+	slog.Info("join code revoked", "room", jc.Room, "user", requester)
+	return jc.Room, ""
+}
@@ -0,0 +1,135 @@
+// This file contains tests for join codes (defined in joincodes.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Replacing a clock function to test expiry without time.Sleep
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TestJoinCodeStoreUses verifies that a code stops working after its last
+// use, or when it expires.
+func TestJoinCodeStoreUses(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newJoinCodeStore(time.Hour)
+	s.now = func() time.Time { return now }
+
+	single := s.create("general", "alice", 1, 0)
+	if !strings.HasPrefix(single.Code, joinCodePrefix) || len(single.Code) != len(joinCodePrefix)+joinCodeLength {
+		t.Fatalf("unexpected code %q", single.Code)
+	}
+	if !s.use(single.Code) {
+		t.Fatal("expected the first use to succeed")
+	}
+	if s.use(single.Code) {
+		t.Error("expected a single-use code to work only once")
+	}
+
+	unlimited := s.create("general", "alice", 0, 2*time.Hour)
+	for range 5 {
+		if !s.use(unlimited.Code) {
+			t.Fatal("expected an unlimited code to keep working")
+		}
+	}
+	now = now.Add(2 * time.Hour)
+	if s.use(unlimited.Code) {
+		t.Error("expected an expired code to stop working")
+	}
+}
+
+// TestJoinCodePermissions verifies that only members who may invite can mint
+// or revoke codes, and that deleting the room kills its codes.
+func TestJoinCodePermissions(t *testing.T) {
+	h := newRoleTestHub(t) // alice owns general, bob is an admin, carol a member
+	if _, errMsg := h.createJoinCode("general", "carol", 0, 0); errMsg == "" {
+		t.Error("expected a plain member not to be able to create a code")
+	}
+	jc, errMsg := h.createJoinCode("general", "bob", 0, 0)
+	if errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if _, errMsg := h.revokeJoinCode(jc.Code, "carol"); errMsg == "" {
+		t.Error("expected a plain member not to be able to revoke a code")
+	}
+	if _, _, errMsg := h.joinByCode(jc.Code, "carol"); errMsg == "" {
+		t.Error("expected an error joining a room you are already in")
+	}
+
+	// Once bob can't invite, his code stops working without being used up.
+	single, _ := h.createJoinCode("general", "bob", 1, 0)
+	h.setRole("general", "alice", "bob", RoleMember)
+	if _, _, errMsg := h.joinByCode(single.Code, "erin"); !strings.Contains(errMsg, "no longer works") {
+		t.Errorf("expected the code to stop working, got %q", errMsg)
+	}
+	if got, ok := h.joinCodes.lookup(single.Code); !ok || got.Uses != 0 {
+		t.Errorf("expected the failed join not to use the code, got %+v", got)
+	}
+	h.setRole("general", "alice", "bob", RoleAdmin)
+	h.inviteToRoom("general", "alice", "erin")
+	if _, _, errMsg := h.joinByCode(single.Code, "erin"); errMsg != "" {
+		t.Errorf("expected the code to work again: %s", errMsg)
+	}
+	if pending := h.invitations.pendingFor("erin"); len(pending) != 0 {
+		t.Errorf("expected joining with the code to clear erin's invitation, got %+v", pending)
+	}
+
+	h.deleteRoom("general")
+	if _, ok := h.joinCodes.lookup(jc.Code); ok {
+		t.Error("expected the code to be gone with its room")
+	}
+}
+
+// TestJoinByCodeViaWebSocket walks through minting a single-use code and
+// joining with it.
+func TestJoinByCodeViaWebSocket(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conns := map[string]*websocket.Conn{}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, user), nil)
+		if err != nil {
+			t.Fatalf("%s failed to dial: %v", user, err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+		conns[user] = c
+	}
+	alice, bob, carol := conns["alice"], conns["bob"], conns["carol"]
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+
+	send(alice, Message{Type: "create_room", Content: "general"})
+	send(alice, Message{Type: "create_join_code", Room: "general", MaxUses: 1, ExpiresIn: 600})
+	code := readUntil(t, ctx, alice, "join_code")
+	if code.MaxUses != 1 || code.ExpiresIn < 590 || code.ExpiresIn > 600 {
+		t.Errorf("unexpected join code %+v", code)
+	}
+
+	send(bob, Message{Type: "join_by_code", Content: "general"})
+	if got := readUntil(t, ctx, bob, "error"); !strings.Contains(got.Content, "not a join code") {
+		t.Errorf("unexpected error %+v", got)
+	}
+	send(bob, Message{Type: "join_by_code", Content: strings.ToUpper(code.Content)})
+	if got := readUntil(t, ctx, alice, "member_joined"); got.Sender != "bob" {
+		t.Errorf("expected alice to hear bob joined, got %+v", got)
+	}
+	send(carol, Message{Type: "join_by_code", Content: code.Content})
+	if got := readUntil(t, ctx, carol, "error"); !strings.Contains(got.Content, "invalid or has expired") {
+		t.Errorf("expected the used-up code to be refused, got %+v", got)
+	}
+}
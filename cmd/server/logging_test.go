@@ -0,0 +1,119 @@
+// This file contains tests for structured logging (defined in logging.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Swapping the default slog logger for one that writes to a buffer, and
+//     restoring it with t.Cleanup
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use: the server logs from
+// its handler goroutines while the test reads what was logged.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs makes the default logger write JSON at level to a buffer for
+// the rest of the test.
+func captureLogs(t *testing.T, level string) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	log, err := newLogger(buf, logFormatJSON, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(log)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return buf
+}
+
+// TestNewLogger verifies format selection and level filtering.
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := newLogger(&buf, logFormatText, "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Info("hidden")
+	log.Warn("shown", "user", "alice")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "msg=shown user=alice") {
+		t.Errorf("expected only the warning, as text, got %q", out)
+	}
+
+	for _, tt := range []struct{ format, level string }{{"xml", "info"}, {"json", "loud"}} {
+		if _, err := newLogger(&buf, tt.format, tt.level); err == nil {
+			t.Errorf("expected format %q level %q to be rejected", tt.format, tt.level)
+		}
+	}
+}
+
+// TestMessageContentOnlyLoggedAtDebug sends a direct message and checks
+// that the info-level record carries the user, connection and type fields
+// but not the content, which only appears at debug level.
+func TestMessageContentOnlyLoggedAtDebug(t *testing.T) {
+	for _, level := range []string{"info", "debug"} {
+		t.Run(level, func(t *testing.T) {
+			logs := captureLogs(t, level)
+			s := newTestServer()
+			server := httptest.NewServer(SetupRouter(s))
+			defer server.Close()
+
+			wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+			if err != nil {
+				t.Fatalf("alice failed to dial: %v", err)
+			}
+			defer alice.CloseNow()
+			addAccount(t, s, "bob")
+
+			data, _ := json.Marshal(Message{Recipient: "bob", Content: "top secret"})
+			alice.Write(ctx, websocket.MessageText, data)
+			readUntil(t, ctx, alice, "ack")
+
+			var record map[string]any
+			for _, line := range strings.Split(logs.String(), "\n") {
+				if strings.Contains(line, `"msg":"direct message"`) {
+					json.Unmarshal([]byte(line), &record)
+				}
+			}
+			if record == nil {
+				t.Fatalf("expected a direct message record, got:\n%s", logs)
+			}
+			if record["user"] != "alice" || record["conn"] == nil || record["type"] != "dm" || record["content"] != nil {
+				t.Errorf("unexpected fields in %v", record)
+			}
+			if logged := strings.Contains(logs.String(), "top secret"); logged != (level == "debug") {
+				t.Errorf("at level %s, content logged = %v", level, logged)
+			}
+		})
+	}
+}
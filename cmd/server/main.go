@@ -18,22 +18,48 @@ import (
 	// context as its first parameter.
 	"context"
 
+	// crypto/rand and encoding/hex are used to generate unguessable
+	// message and connection IDs (see newID).
+	"crypto/rand"
+	"encoding/hex"
+
 	// encoding/json provides JSON encoding and decoding. It uses reflection
 	// to map between Go structs and JSON, guided by struct tags (see hub.go).
 	// Key functions: json.Marshal (Go -> JSON bytes), json.Unmarshal (JSON bytes -> Go).
 	"encoding/json"
 
+	// flag.ErrHelp tells main that -h was given (see config.go).
+	"flag"
+
+	// errors.Is lets main tell a normal shutdown apart from a failure.
+	"errors"
+
 	// fmt implements formatted I/O. fmt.Fprint writes to an io.Writer (like
-	// http.ResponseWriter), fmt.Printf prints to stdout. It's Go's equivalent
-	// of printf/sprintf from C.
+	// http.ResponseWriter), fmt.Sprintf formats into a string. It's Go's
+	// equivalent of printf/sprintf from C.
 	"fmt"
 
+	// log/slog is the structured logger used for all diagnostics (see
+	// logging.go).
+	"log/slog"
+
 	// net/http provides HTTP client and server implementations. Go's HTTP
 	// server is production-grade out of the box — many companies use it
 	// directly in production without a framework (unlike Node.js/Express or
 	// Python/Flask). The standard library is one of Go's biggest strengths.
 	"net/http"
 
+	// os gives access to process-level facilities such as os.Exit.
+	"os"
+
+	// os/signal and syscall let the server notice SIGINT and SIGTERM and
+	// shut down gracefully (see shutdown.go).
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
 	"nhooyr.io/websocket"
 )
 
@@ -47,21 +73,11 @@ import (
 // Instead, you pass dependencies explicitly through struct fields or function
 // parameters. This is intentional: Go values explicitness over magic.
 type Server struct {
-	hub *Hub
-}
-
-// helloHandler is a simple HTTP handler that responds with "Hello, World!".
-//
-// LEARNING POINT — http.HandlerFunc Signature:
-// Any function with the signature func(http.ResponseWriter, *http.Request) can
-// be used as an HTTP handler. http.ResponseWriter is an interface that lets you
-// write the HTTP response (status code, headers, body). *http.Request contains
-// all information about the incoming request (method, URL, headers, body).
-//
-// fmt.Fprint writes to any io.Writer. Since http.ResponseWriter implements
-// io.Writer, we can write the response body directly with fmt.Fprint.
-func helloHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "Hello, World!")
+	hub    *Hub
+	store  MessageStore
+	tokens *TokenIssuer
+	users  *UserRegistry
+	config Config
 }
 
 // wsHandler upgrades an HTTP connection to a WebSocket connection and enters
@@ -73,51 +89,89 @@ func helloHandler(w http.ResponseWriter, r *http.Request) {
 // protocols. The websocket.Accept() call handles this entire handshake.
 //
 // LEARNING POINT — defer for Cleanup:
-// Notice the two defers: one to unregister the user from the hub, another to
-// close the WebSocket. defers execute in LIFO (last-in, first-out) order when
-// the function returns, so the WebSocket closes first, then the user is
-// unregistered. This guarantees cleanup even if the loop exits due to an error.
+// Notice the three defers: one to stop the connection's writer goroutine, one
+// to unregister the device from the hub, another to close the WebSocket.
+// defers execute in LIFO (last-in, first-out) order when the function
+// returns, so the WebSocket closes first, then the device is unregistered,
+// then the writer stops. This guarantees cleanup even if the loop exits due
+// to an error.
 //
 // LEARNING POINT — Infinite Loop Pattern:
 // The for { ... } loop is Go's "while true" — it reads messages until the
 // client disconnects (which causes c.Read to return an error, breaking the
 // loop). This is the standard pattern for long-lived connections in Go.
 func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("Received connection request on /ws from %s\n", r.RemoteAddr)
-
-	// Extract the "user" query parameter from the URL (e.g., /ws?user=alice).
-	// r.URL.Query() parses the query string into a map of key -> []string,
-	// and .Get() returns the first value for the key (or "" if missing).
-	userID := r.URL.Query().Get("user")
-	if userID == "" {
-		fmt.Println("Error: user query parameter is missing")
+	// The user's identity comes from the signed token issued by /login, never
+	// from anything the client asserts directly (see auth.go).
+	userID, err := s.tokens.Verify(bearerToken(r))
+	if err != nil {
+		slog.Info("rejected connection", "remote", r.RemoteAddr, "err", err)
 		// http.Error is a convenience function that writes an error message
 		// and sets the appropriate HTTP status code in one call.
-		http.Error(w, "user query parameter is required", http.StatusBadRequest)
+		http.Error(w, "a valid token is required", http.StatusUnauthorized)
+		return
+	}
+
+	// A token outlives the checks made when it was issued, so confirm the
+	// account still exists and hasn't been disabled since.
+	if !s.users.Active(userID) {
+		slog.Info("rejected connection for inactive account", "remote", r.RemoteAddr, "user", userID)
+		http.Error(w, "account is unknown or disabled", http.StatusForbidden)
+		return
+	}
+
+	// A server that is shutting down takes no new connections. Retry-After
+	// tells well-behaved clients when to try again.
+	if !s.hub.accepting() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(shutdownRetryAfter.Seconds())))
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
 
 	// Accept upgrades the HTTP connection to a WebSocket connection.
-	// InsecureSkipVerify: true disables origin checking — fine for development,
-	// but in production you should validate the Origin header to prevent
-	// cross-site WebSocket hijacking (CSWSH).
+	//
+	// LEARNING POINT — Origin Checks:
+	// Browsers attach the page's Origin to WebSocket requests, and Accept
+	// rejects any whose host doesn't match the server's own or one of the
+	// configured OriginPatterns. Without that check, any web page a user
+	// visited could open a chat connection in their name (cross-site
+	// WebSocket hijacking). Non-browser clients such as the CLI send no
+	// Origin and are unaffected.
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		InsecureSkipVerify: true,
+		OriginPatterns: s.config.AllowedOrigins,
 	})
 	if err != nil {
-		fmt.Printf("Error accepting websocket for user %s: %v\n", userID, err)
+		slog.Info("websocket upgrade failed", "remote", r.RemoteAddr, "user", userID, "err", err)
 		return
 	}
+	// Messages larger than this make the next Read fail and close the
+	// connection with StatusMessageTooBig.
+	c.SetReadLimit(int64(s.config.MaxMessageBytes))
 
-	// Wrap the raw WebSocket in our connection struct and register with the hub.
-	conn := &connection{ws: c}
-	s.hub.register(userID, conn)
+	// Wrap the raw WebSocket in our connection struct and register with the
+	// hub. Each connection gets its own ID because the same user may be
+	// connected from several devices at once. Only once any offline backlog
+	// has been written does the connection's writer goroutine take over.
+	conn := s.hub.newConnection(r.Context(), userID, c)
+	if !s.hub.register(userID, conn) {
+		// Shutdown began between the check above and now.
+		c.Close(websocket.StatusGoingAway, "server shutting down")
+		return
+	}
+	go conn.writeLoop()
+	if s.hub.pingInterval > 0 {
+		go conn.heartbeat()
+	}
+	s.sendPendingInvites(userID, conn)
 
-	// defer runs these cleanup functions when wsHandler returns (in reverse order).
-	defer s.hub.unregister(userID)
-	defer c.Close(websocket.StatusInternalError, "the sky is falling")
+	// defer runs these cleanup functions when wsHandler returns (in reverse
+	// order): close the socket, take the device out of the hub so nothing
+	// new is queued for it, then stop its writer.
+	defer conn.stop()
+	defer s.hub.unregister(userID, conn)
+	defer c.Close(websocket.StatusNormalClosure, "")
 
-	fmt.Printf("User %s connected successfully\n", userID)
+	conn.log.Info("connected", "remote", r.RemoteAddr)
 
 	// r.Context() returns the request's context, which is automatically
 	// cancelled when the client disconnects. Passing it to c.Read() means
@@ -131,7 +185,7 @@ func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
 		// we only expect text messages.
 		_, p, err := c.Read(ctx)
 		if err != nil {
-			fmt.Printf("User %s disconnected: %v\n", userID, err)
+			conn.log.Info("disconnected", "err", err)
 			break
 		}
 
@@ -140,29 +194,84 @@ func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
 		// next message (don't disconnect the client for a bad message).
 		var msg Message
 		if err := json.Unmarshal(p, &msg); err != nil {
-			fmt.Printf("Error unmarshaling message from %s: %v\n", userID, err)
+			conn.log.Warn("malformed message", "err", err)
 			continue
 		}
 
+		// Whatever the client put in Sender, the message comes from the
+		// authenticated user on this connection.
+		msg.Sender = userID
+		conn.log.Debug("message received", "type", msg.Type)
+		s.hub.metrics.messagesIn.inc(typeLabel(msg.Type))
+
 		// LEARNING POINT — Type-based Dispatch with switch:
 		// Go's switch statement doesn't need "break" — each case automatically
 		// breaks unless you use "fallthrough". The default case handles any
 		// unrecognized message type, providing backwards compatibility.
 		switch msg.Type {
 		case "create_room":
-			s.handleCreateRoom(ctx, userID, msg, c)
+			s.handleCreateRoom(ctx, userID, msg, conn)
 		case "invite":
-			s.handleInvite(ctx, userID, msg, c)
+			s.handleInvite(ctx, userID, msg, conn)
+		case "accept_invite":
+			s.handleAcceptInvite(ctx, userID, msg, conn)
+		case "decline_invite":
+			s.handleDeclineInvite(ctx, userID, msg, conn)
+		case "revoke_invite":
+			s.handleRevokeInvite(ctx, userID, msg, conn)
+		case "list_rooms":
+			s.handleListRooms(ctx, userID, msg, conn)
+		case "join_room":
+			s.handleJoinRoom(ctx, userID, msg, conn)
+		case "follow":
+			s.handleFollow(ctx, userID, msg, conn)
+		case "unfollow":
+			s.handleUnfollow(ctx, userID, msg, conn)
+		case "set_visibility":
+			s.handleSetVisibility(ctx, userID, msg, conn)
+		case "room_info":
+			s.handleRoomInfo(ctx, userID, msg, conn)
+		case "update_room":
+			s.handleUpdateRoom(ctx, userID, msg, conn)
+		case "create_join_code":
+			s.handleCreateJoinCode(ctx, userID, msg, conn)
+		case "join_by_code":
+			s.handleJoinByCode(ctx, userID, msg, conn)
+		case "revoke_join_code":
+			s.handleRevokeJoinCode(ctx, userID, msg, conn)
 		case "room_msg":
-			s.handleRoomMessage(ctx, userID, msg)
+			s.handleRoomMessage(ctx, userID, msg, conn)
+		case "leave_room":
+			s.handleLeaveRoom(ctx, userID, msg, conn)
+		case "kick":
+			s.handleKick(ctx, userID, msg, conn)
+		case "delete_room":
+			s.handleDeleteRoom(ctx, userID, msg, conn)
+		case "promote", "demote", "transfer_ownership":
+			s.handleSetRole(ctx, userID, msg, conn)
+		case "create_list":
+			s.handleCreateList(ctx, userID, msg, conn)
+		case "add_to_list", "remove_from_list":
+			s.handleEditList(ctx, userID, msg, conn)
+		case "delete_list":
+			s.handleDeleteList(ctx, userID, msg, conn)
+		case "get_lists":
+			s.handleGetLists(ctx, userID, msg, conn)
+		case "broadcast":
+			s.handleBroadcast(ctx, userID, msg, conn)
+		case "read":
+			s.handleRead(ctx, userID, msg)
 		default:
 			// Direct message (original behavior, backwards compatible)
-			s.handleDirectMessage(ctx, userID, msg, p)
+			s.handleDirectMessage(ctx, userID, msg, conn)
 		}
 	}
 }
 
-// handleDirectMessage routes a message to a single recipient (original behavior).
+// handleDirectMessage routes a message to a single recipient (original
+// behavior). Every one of the recipient's devices gets it, and so do the
+// sender's other devices, so a conversation looks the same wherever the
+// sender picks it up.
 //
 // LEARNING POINT — Blank Identifier:
 // The second parameter is "_ string" (the userID). The underscore (_) tells Go
@@ -170,40 +279,108 @@ func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
 // doesn't allow unused variables — it's a compile error. The blank identifier
 // is a signal to readers that the parameter exists for interface conformity but
 // isn't needed in this particular implementation.
-func (s *Server) handleDirectMessage(ctx context.Context, _ string, msg Message, rawPayload []byte) {
-	fmt.Printf("Message from %s to %s: %s\n", msg.Sender, msg.Recipient, msg.Content)
+func (s *Server) handleDirectMessage(ctx context.Context, _ string, msg Message, conn *connection) {
+	if msg.Recipient == "" {
+		s.hub.metrics.dropped.inc(dropUnknownRecipient)
+		sendError(conn, "recipient is required")
+		return
+	}
+	// Without this check a message to a made-up name would be stored and
+	// queued for a user who can never connect to collect it, and a client
+	// could grow the offline queue without limit by inventing names.
+	if !s.users.Active(msg.Recipient) {
+		s.hub.metrics.dropped.inc(dropUnknownRecipient)
+		sendError(conn, fmt.Sprintf("there is no user %q", msg.Recipient))
+		return
+	}
 
-	// Look up the recipient's connection in the hub using the comma-ok idiom.
-	recipientConn, ok := s.hub.get(msg.Recipient)
-	if !ok {
-		fmt.Printf("Recipient %s not found for message from %s\n", msg.Recipient, msg.Sender)
+	// Stamp and persist before delivering so that a message the recipient
+	// has seen is always one we can show again later.
+	msg, err := s.accept(directConversation(msg.Sender, msg.Recipient), msg)
+	if err != nil {
+		conn.log.Error("store message", "type", "dm", "recipient", msg.Recipient, "err", err)
+		sendError(conn, "message could not be stored")
 		return
 	}
+	conn.log.Info("direct message", "type", "dm", "id", msg.ID, "recipient", msg.Recipient)
+	logContent(conn.log, msg)
+	sendAck(conn, msg)
+
+	// If the recipient is offline the hub queues the message for their next
+	// connect, and we tell the sender so they aren't left wondering.
+	if !s.deliverDirect(msg, conn) {
+		conn.log.Info("recipient offline; message queued", "type", "dm", "id", msg.ID, "recipient", msg.Recipient)
+		sendJSON(conn, Message{
+			Type:      "queued",
+			Sender:    "server",
+			Recipient: msg.Recipient,
+			ID:        msg.ID,
+			Content:   fmt.Sprintf("%s is offline; message queued for delivery", msg.Recipient),
+		})
+	}
+}
+
+// deliverDirect hands a stored, stamped direct message to every device of
+// its recipient, or to their offline queue, and to the sender's devices
+// other than conn. It reports whether the recipient was online.
+func (s *Server) deliverDirect(msg Message, conn *connection) bool {
+	s.hub.receipts.track(msg.ID, msg.Sender, "", []string{msg.Recipient})
+
+	// The copy for the sender's other devices is the stamped message itself;
+	// their clients recognize it as their own because Sender is them.
+	s.hub.sendToUser(msg.Sender, msg, conn)
+
+	recipientConns := s.hub.devicesOrQueue(msg.Recipient, msg)
+	if recipientConns == nil {
+		return false
+	}
 
-	// Write the raw JSON payload directly to the recipient's WebSocket.
-	// We reuse rawPayload (the original bytes) instead of re-marshaling,
-	// which avoids unnecessary serialization work.
-	if err := recipientConn.ws.Write(ctx, websocket.MessageText, rawPayload); err != nil {
-		fmt.Printf("Error sending message to %s: %v\n", msg.Recipient, err)
+	// Forward the stamped message (not the client's raw bytes) so the
+	// recipient sees the same ID, timestamp and sequence number the sender
+	// was acknowledged with.
+	data, err := json.Marshal(msg)
+	if err != nil {
+		conn.log.Error("marshal message", "type", "dm", "id", msg.ID, "err", err)
+		return true
 	}
+	s.hub.deliverChat(msg.Recipient, recipientConns, data, msg)
+	return true
 }
 
 // handleCreateRoom creates a new chat room with the sender as the first member.
 // Sends an acknowledgment or error back to the creator.
-func (s *Server) handleCreateRoom(ctx context.Context, userID string, msg Message, c *websocket.Conn) {
+func (s *Server) handleCreateRoom(ctx context.Context, userID string, msg Message, conn *connection) {
 	roomName := msg.Content
 	if roomName == "" {
 		roomName = msg.Room
 	}
 	if roomName == "" {
-		sendError(ctx, c, "room name is required")
+		sendError(conn, "room name is required")
+		return
+	}
+	if msg.Visibility != "" && !msg.Visibility.valid() {
+		sendError(conn, fmt.Sprintf("visibility must be %q or %q", VisibilityPublic, VisibilityPrivate))
+		return
+	}
+	if msg.RoomType != "" && !msg.RoomType.valid() {
+		sendError(conn, fmt.Sprintf("room_type must be %q or %q", RoomTypeGroup, RoomTypeChannel))
 		return
 	}
 
-	if errMsg := s.hub.createRoom(roomName, userID); errMsg != "" {
-		sendError(ctx, c, errMsg)
+	create := s.hub.createRoom
+	if msg.RoomType == RoomTypeChannel {
+		create = s.hub.createChannel
+	}
+	if errMsg := create(roomName, userID); errMsg != "" {
+		sendError(conn, errMsg)
 		return
 	}
+	if msg.Visibility == VisibilityPublic {
+		if _, errMsg := s.hub.setVisibility(roomName, userID, msg.Visibility); errMsg != "" {
+			sendError(conn, fmt.Sprintf("room %q was created but could not be made public: %s", roomName, errMsg))
+			return
+		}
+	}
 
 	// LEARNING POINT — Struct Literals:
 	// Go allows you to create struct values inline with named fields.
@@ -216,20 +393,26 @@ func (s *Server) handleCreateRoom(ctx context.Context, userID string, msg Messag
 		Room:    roomName,
 		Content: fmt.Sprintf("room %q created successfully", roomName),
 	}
-	sendJSON(ctx, c, ack)
+	sendJSON(conn, ack)
 }
 
-// handleInvite adds a user to a chat room and notifies both the inviter and invitee.
-func (s *Server) handleInvite(ctx context.Context, userID string, msg Message, c *websocket.Conn) {
+// handleInvite invites a user to a chat room and notifies both the inviter
+// and invitee. The invitee only joins once they accept (see invitations.go).
+func (s *Server) handleInvite(ctx context.Context, userID string, msg Message, conn *connection) {
 	roomName := msg.Room
 	invitee := msg.Recipient
 	if roomName == "" || invitee == "" {
-		sendError(ctx, c, "room and recipient are required for invite")
+		sendError(conn, "room and recipient are required for invite")
+		return
+	}
+	if !s.users.Active(invitee) {
+		sendError(conn, fmt.Sprintf("there is no user %q", invitee))
 		return
 	}
 
-	if errMsg := s.hub.addToRoom(roomName, userID, invitee); errMsg != "" {
-		sendError(ctx, c, errMsg)
+	inv, errMsg := s.hub.inviteToRoom(roomName, userID, invitee)
+	if errMsg != "" {
+		sendError(conn, errMsg)
 		return
 	}
 
@@ -240,45 +423,105 @@ func (s *Server) handleInvite(ctx context.Context, userID string, msg Message, c
 		Room:    roomName,
 		Content: fmt.Sprintf("user %q invited to room %q", invitee, roomName),
 	}
-	sendJSON(ctx, c, ack)
-
-	// Notify invitee if they are online.
-	// This is a "best effort" notification — if the invitee is offline,
-	// they simply won't receive the notification. A production system
-	// might store pending notifications for delivery when the user reconnects.
-	if inviteeConn, ok := s.hub.get(invitee); ok {
-		notify := Message{
-			Type:    "invited",
-			Sender:  userID,
-			Room:    roomName,
-			Content: fmt.Sprintf("you have been invited to room %q by %s", roomName, userID),
-		}
-		sendJSON(ctx, inviteeConn.ws, notify)
+	sendJSON(conn, ack)
+
+	// Notify invitee on each device they are connected from. If the invitee
+	// is offline they are told when they next connect, since the invitation
+	// itself waits in the hub until it is answered or expires.
+	s.hub.sendToUser(invitee, invitedMessage(inv), nil)
+}
+
+// handleAcceptInvite adds the sender to a room they were invited to. Every
+// member, the new one included, is told with a member_joined notice.
+func (s *Server) handleAcceptInvite(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName := msg.Room
+	if roomName == "" {
+		sendError(conn, "room is required for accept_invite")
+		return
 	}
+	_, members, errMsg := s.hub.acceptInvite(roomName, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(members, Message{
+		Type:    "member_joined",
+		Sender:  userID,
+		Room:    roomName,
+		Content: fmt.Sprintf("%s joined room %q", userID, roomName),
+	})
 }
 
-// handleRoomMessage broadcasts a message to all online members of a room
-// except the sender.
+// handleDeclineInvite turns down the sender's invitation to a room. The
+// inviter and the sender's devices are told with an invite_declined notice.
+func (s *Server) handleDeclineInvite(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName := msg.Room
+	if roomName == "" {
+		sendError(conn, "room is required for decline_invite")
+		return
+	}
+	inv, errMsg := s.hub.declineInvite(roomName, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers([]string{inv.Inviter, userID}, Message{
+		Type:    "invite_declined",
+		Sender:  userID,
+		Room:    roomName,
+		Content: fmt.Sprintf("%s declined the invitation to room %q", userID, roomName),
+	})
+}
+
+// handleRevokeInvite withdraws an invitation the sender made to
+// msg.Recipient. Both of them are told with an invite_revoked notice.
+func (s *Server) handleRevokeInvite(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName, invitee := msg.Room, msg.Recipient
+	if roomName == "" || invitee == "" {
+		sendError(conn, "room and recipient are required for revoke_invite")
+		return
+	}
+	if errMsg := s.hub.revokeInvite(roomName, userID, invitee); errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers([]string{invitee, userID}, Message{
+		Type:      "invite_revoked",
+		Sender:    userID,
+		Recipient: invitee,
+		Room:      roomName,
+		Content:   fmt.Sprintf("%s withdrew the invitation to room %q for %s", userID, roomName, invitee),
+	})
+}
+
+// handleRoomMessage broadcasts a message to all members of a room except the
+// sender. Members who are offline have it queued for their next connect, and
+// the sender's own other devices get a copy.
 //
 // LEARNING POINT — Fan-out Pattern:
 // This function demonstrates a simple fan-out: one incoming message is sent to
 // multiple recipients. The for loop iterates over all room members and writes
 // to each one individually. In a high-throughput system, you might use
 // goroutines for parallel writes, but for simplicity this does them sequentially.
-func (s *Server) handleRoomMessage(ctx context.Context, userID string, msg Message) {
+func (s *Server) handleRoomMessage(ctx context.Context, userID string, msg Message, conn *connection) {
 	roomName := msg.Room
 	if roomName == "" {
-		fmt.Printf("Room message from %s missing room name\n", userID)
+		conn.log.Info("room message without a room", "type", "room_msg")
+		s.hub.metrics.dropped.inc(dropNotMember)
 		return
 	}
 
-	members := s.hub.getRoomMembers(roomName, userID)
-	if members == nil {
-		fmt.Printf("User %s cannot send to room %q (not a member or room doesn't exist)\n", userID, roomName)
+	if !s.hub.isMember(roomName, userID) {
+		conn.log.Info("room message rejected: not a member or no such room", "type", "room_msg", "room", roomName)
+		s.hub.metrics.dropped.inc(dropNotMember)
+		return
+	}
+	if errMsg := s.hub.checkRoomPermission(roomName, userID, permPost); errMsg != "" {
+		conn.log.Info("room message rejected: not allowed to post", "type", "room_msg", "room", roomName)
+		s.hub.metrics.dropped.inc(dropNotAllowed)
+		sendError(conn, errMsg)
 		return
 	}
-
-	fmt.Printf("Room message from %s in %q: %s\n", userID, roomName, msg.Content)
 
 	// Build the outgoing message once and marshal it once, then send the
 	// same bytes to every recipient. This is more efficient than marshaling
@@ -289,52 +532,649 @@ func (s *Server) handleRoomMessage(ctx context.Context, userID string, msg Messa
 		Room:    roomName,
 		Content: msg.Content,
 	}
-	data, err := json.Marshal(outMsg)
+	outMsg, err := s.accept(roomConversation(roomName), outMsg)
 	if err != nil {
-		fmt.Printf("Error marshaling room message: %v\n", err)
+		conn.log.Error("store message", "type", "room_msg", "room", roomName, "err", err)
+		sendError(conn, "message could not be stored")
+		return
+	}
+	conn.log.Info("room message", "type", "room_msg", "room", roomName, "id", outMsg.ID)
+	logContent(conn.log, outMsg)
+	sendAck(conn, outMsg)
+
+	// Channels can have thousands of subscribers, so their posts skip
+	// receipts and the member-at-a-time loop below (see channels.go).
+	if s.hub.isChannel(roomName) {
+		data, err := json.Marshal(outMsg)
+		if err != nil {
+			conn.log.Error("marshal message", "type", "room_msg", "room", roomName, "id", outMsg.ID, "err", err)
+			return
+		}
+		s.hub.fanOutChannel(roomName, userID, outMsg, data, conn)
 		return
 	}
 
+	members := s.hub.getRoomMembers(roomName, userID)
+	recipients := make([]string, 0, len(members))
 	for _, memberID := range members {
-		// Skip the sender — they already know what they sent.
-		if memberID == userID {
-			continue
+		if memberID != userID {
+			recipients = append(recipients, memberID)
 		}
-		if memberConn, ok := s.hub.get(memberID); ok {
-			if err := memberConn.ws.Write(ctx, websocket.MessageText, data); err != nil {
-				fmt.Printf("Error sending room message to %s: %v\n", memberID, err)
-			}
+	}
+	s.hub.receipts.track(outMsg.ID, userID, roomName, recipients)
+
+	data, err := json.Marshal(outMsg)
+	if err != nil {
+		conn.log.Error("marshal message", "type", "room_msg", "room", roomName, "id", outMsg.ID, "err", err)
+		return
+	}
+
+	// The sender is not in recipients — the device they sent from already
+	// knows what it sent, and their other devices are handled below.
+	for _, memberID := range recipients {
+		// Offline members get the message parked in their per-room backlog
+		// and receive it (or a "you missed N messages" summary) on reconnect.
+		if memberConns := s.hub.devicesOrQueue(memberID, outMsg); memberConns != nil {
+			s.hub.deliverChat(memberID, memberConns, data, outMsg)
+		}
+	}
+	deliver(s.hub.devices(userID), outbound{data: data, kind: outMsg.Type}, conn)
+}
+
+// handleLeaveRoom takes the sender out of a room. The remaining members and
+// all of the sender's devices are told with a member_left notice, which also
+// serves as the sender's acknowledgment. If the sender owned the room, the
+// members are then told who took over with a role_changed notice.
+func (s *Server) handleLeaveRoom(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName := msg.Room
+	if roomName == "" {
+		sendError(conn, "room is required for leave_room")
+		return
+	}
+	remaining, succ, errMsg := s.hub.leaveRoom(roomName, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(append(remaining, userID), Message{
+		Type:    "member_left",
+		Sender:  userID,
+		Room:    roomName,
+		Content: fmt.Sprintf("%s left room %q", userID, roomName),
+	})
+	s.announceSuccession(roomName, succ)
+}
+
+// handleListRooms replies with a "room_list" of the sender's rooms followed
+// by the public rooms they are not in.
+func (s *Server) handleListRooms(ctx context.Context, userID string, msg Message, conn *connection) {
+	sendJSON(conn, Message{
+		Type:   "room_list",
+		Sender: "server",
+		Rooms:  s.hub.listRooms(userID),
+	})
+}
+
+// handleJoinRoom adds the sender to a public room. Every member, the new one
+// included, is told with a member_joined notice.
+func (s *Server) handleJoinRoom(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName := msg.Room
+	if roomName == "" {
+		roomName = msg.Content
+	}
+	if roomName == "" {
+		sendError(conn, "room is required for join_room")
+		return
+	}
+	members, errMsg := s.hub.joinPublicRoom(roomName, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(members, Message{
+		Type:    "member_joined",
+		Sender:  userID,
+		Room:    roomName,
+		Content: fmt.Sprintf("%s joined room %q", userID, roomName),
+	})
+}
+
+// handleFollow subscribes the sender to the public channel msg.Room. Only the
+// new subscriber is told; the channel's admins and other subscribers are
+// not.
+func (s *Server) handleFollow(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.Room == "" {
+		sendError(conn, "room is required for follow")
+		return
+	}
+	notify, errMsg := s.hub.followChannel(msg.Room, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(notify, Message{
+		Type:    "member_joined",
+		Sender:  userID,
+		Room:    msg.Room,
+		Content: fmt.Sprintf("you are now following channel %q", msg.Room),
+	})
+}
+
+// handleUnfollow unsubscribes the sender from the channel msg.Room.
+func (s *Server) handleUnfollow(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.Room == "" {
+		sendError(conn, "room is required for unfollow")
+		return
+	}
+	notify, succ, errMsg := s.hub.unfollowChannel(msg.Room, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(append(notify, userID), Message{
+		Type:    "member_left",
+		Sender:  userID,
+		Room:    msg.Room,
+		Content: fmt.Sprintf("%s stopped following channel %q", userID, msg.Room),
+	})
+	s.announceSuccession(msg.Room, succ)
+}
+
+// handleSetVisibility makes a room public or private. Members are told with
+// a "room_updated" notice.
+func (s *Server) handleSetVisibility(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.Room == "" {
+		sendError(conn, "room is required for set_visibility")
+		return
+	}
+	members, errMsg := s.hub.setVisibility(msg.Room, userID, msg.Visibility)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	// The room may have been deleted since setVisibility let go of the lock.
+	details, errMsg := s.hub.roomDetails(msg.Room, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(members, Message{
+		Type:       "room_updated",
+		Sender:     userID,
+		Room:       msg.Room,
+		Visibility: msg.Visibility,
+		Details:    &details,
+		Content:    fmt.Sprintf("%s made room %q %s", userID, msg.Room, msg.Visibility),
+	})
+}
+
+// handleRoomInfo sends the sender a "room_info" reply describing msg.Room:
+// its name, topic, description, creator, settings and, for members, who is
+// in it with what role.
+func (s *Server) handleRoomInfo(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.Room == "" {
+		sendError(conn, "room is required for room_info")
+		return
+	}
+	details, errMsg := s.hub.roomDetails(msg.Room, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	sendJSON(conn, Message{Type: "room_info", Sender: "server", Room: msg.Room, Details: &details})
+}
+
+// handleUpdateRoom applies msg.Update to msg.Room and sends every member a
+// "room_updated" notice with the room as it now is and, in Content, what
+// changed.
+func (s *Server) handleUpdateRoom(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.Room == "" || msg.Update == nil {
+		sendError(conn, "room and update are required for update_room")
+		return
+	}
+	details, members, errMsg := s.hub.updateRoom(msg.Room, userID, *msg.Update)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(members, Message{
+		Type:    "room_updated",
+		Sender:  userID,
+		Room:    msg.Room,
+		Details: &details,
+		Content: fmt.Sprintf("%s %s", userID, msg.Update.describe()),
+	})
+}
+
+// handleCreateJoinCode mints a join code for msg.Room and sends it back to
+// the sender as a "join_code" message (Content = the code). msg.MaxUses
+// limits how many people can use it (0 for no limit) and msg.ExpiresIn, in
+// seconds, how long it lasts (0 for the server's default).
+func (s *Server) handleCreateJoinCode(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.Room == "" {
+		sendError(conn, "room is required for create_join_code")
+		return
+	}
+	ttl := time.Duration(msg.ExpiresIn) * time.Second
+	if msg.MaxUses < 0 || ttl < 0 || ttl > maxJoinCodeTTL {
+		sendError(conn, fmt.Sprintf("max_uses must not be negative and expires_in must be at most %d seconds", int(maxJoinCodeTTL.Seconds())))
+		return
+	}
+	jc, errMsg := s.hub.createJoinCode(msg.Room, userID, msg.MaxUses, ttl)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	sendJSON(conn, Message{
+		Type:      "join_code",
+		Sender:    "server",
+		Room:      jc.Room,
+		Content:   jc.Code,
+		MaxUses:   jc.MaxUses,
+		ExpiresIn: int(time.Until(jc.Expires).Round(time.Second).Seconds()),
+	})
+}
+
+// handleJoinByCode adds the sender to the room a join code (msg.Content) is
+// for. Every member, the new one included, is told with a member_joined
+// notice.
+func (s *Server) handleJoinByCode(ctx context.Context, userID string, msg Message, conn *connection) {
+	// Check the shape of the code here, so that obvious junk (a room name
+	// typed by mistake, say) gets a helpful answer and never reaches the hub.
+	code := strings.ToLower(strings.TrimSpace(msg.Content))
+	if !strings.HasPrefix(code, joinCodePrefix) || len(code) != len(joinCodePrefix)+joinCodeLength {
+		sendError(conn, fmt.Sprintf("%q is not a join code; join codes start with %q", msg.Content, joinCodePrefix))
+		return
+	}
+	roomName, members, errMsg := s.hub.joinByCode(code, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	conn.log.Info("joined by code", "room", roomName)
+	s.notifyMembers(members, Message{
+		Type:    "member_joined",
+		Sender:  userID,
+		Room:    roomName,
+		Content: fmt.Sprintf("%s joined room %q with an invite link", userID, roomName),
+	})
+}
+
+// handleRevokeJoinCode cancels a join code (msg.Content) so nobody else can
+// use it. The sender gets a "join_code_revoked" acknowledgment.
+func (s *Server) handleRevokeJoinCode(ctx context.Context, userID string, msg Message, conn *connection) {
+	code := strings.ToLower(strings.TrimSpace(msg.Content))
+	roomName, errMsg := s.hub.revokeJoinCode(code, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	sendJSON(conn, Message{
+		Type:    "join_code_revoked",
+		Sender:  "server",
+		Room:    roomName,
+		Content: fmt.Sprintf("join code %s for room %q no longer works", code, roomName),
+	})
+}
+
+// handleKick removes msg.Recipient from a room. Only owners and admins may do
+// this, and only to members below them. The removed user and everyone still
+// in the room are told with a member_removed notice.
+func (s *Server) handleKick(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName, member := msg.Room, msg.Recipient
+	if roomName == "" || member == "" {
+		sendError(conn, "room and recipient are required for kick")
+		return
+	}
+	remaining, succ, errMsg := s.hub.kickFromRoom(roomName, userID, member)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(append(remaining, member), Message{
+		Type:      "member_removed",
+		Sender:    userID,
+		Recipient: member,
+		Room:      roomName,
+		Content:   fmt.Sprintf("%s was removed from room %q by %s", member, roomName, userID),
+	})
+	s.announceSuccession(roomName, succ)
+}
+
+// handleDeleteRoom deletes a room. Only the room's owner may do this. Every
+// former member is told with a room_deleted notice.
+func (s *Server) handleDeleteRoom(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName := msg.Room
+	if roomName == "" {
+		sendError(conn, "room is required for delete_room")
+		return
+	}
+	members, errMsg := s.hub.deleteOwnedRoom(roomName, userID)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(members, Message{
+		Type:    "room_deleted",
+		Sender:  userID,
+		Room:    roomName,
+		Content: fmt.Sprintf("room %q was deleted by %s", roomName, userID),
+	})
+}
+
+// handleSetRole changes msg.Recipient's role in a room: "promote" makes them
+// an admin, "demote" a plain member, and "transfer_ownership" the owner (the
+// old owner becomes an admin). Every member is told with a role_changed
+// notice.
+func (s *Server) handleSetRole(ctx context.Context, userID string, msg Message, conn *connection) {
+	roomName, member := msg.Room, msg.Recipient
+	if roomName == "" || member == "" {
+		sendError(conn, fmt.Sprintf("room and recipient are required for %s", msg.Type))
+		return
+	}
+	var (
+		members []string
+		errMsg  string
+		role    Role
+	)
+	switch msg.Type {
+	case "promote":
+		role = RoleAdmin
+		members, errMsg = s.hub.setRole(roomName, userID, member, role)
+	case "demote":
+		role = RoleMember
+		members, errMsg = s.hub.setRole(roomName, userID, member, role)
+	default:
+		role = RoleOwner
+		members, errMsg = s.hub.transferOwnership(roomName, userID, member)
+	}
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	s.notifyMembers(members, Message{
+		Type:      "role_changed",
+		Sender:    userID,
+		Recipient: member,
+		Room:      roomName,
+		Content:   fmt.Sprintf("%s is now %s of room %q (set by %s)", member, role.withArticle(), roomName, userID),
+	})
+}
+
+// notifyMembers sends msg to every device of each of the given users. These
+// notices are best effort: users who are offline don't get them later.
+func (s *Server) notifyMembers(members []string, msg Message) {
+	for _, m := range members {
+		s.hub.sendToUser(m, msg, nil)
+	}
+}
+
+// announceSuccession sends a role_changed notice about a room's new owner,
+// appointed because the old one left or was removed, to those succ lists.
+// It does nothing if succ is nil.
+func (s *Server) announceSuccession(roomName string, succ *succession) {
+	if succ == nil {
+		return
+	}
+	s.notifyMembers(succ.notify, Message{
+		Type:      "role_changed",
+		Sender:    "server",
+		Recipient: succ.owner,
+		Room:      roomName,
+		Content:   fmt.Sprintf("%s is now the owner of room %q", succ.owner, roomName),
+	})
+}
+
+// handleCreateList creates the broadcast list msg.List for the sender, with
+// msg.Recipients (which may be empty) on it, and replies "list_updated".
+func (s *Server) handleCreateList(ctx context.Context, userID string, msg Message, conn *connection) {
+	if errMsg := s.checkAccounts(msg.Recipients); errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	list, errMsg := s.hub.broadcasts.create(userID, msg.List, msg.Recipients)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	sendJSON(conn, listUpdatedMessage(list, fmt.Sprintf("created list %q", list.Name)))
+}
+
+// handleEditList adds msg.Recipients to, or removes them from, the sender's
+// broadcast list msg.List, and replies "list_updated".
+func (s *Server) handleEditList(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.List == "" || len(msg.Recipients) == 0 {
+		sendError(conn, fmt.Sprintf("list and recipients are required for %s", msg.Type))
+		return
+	}
+	edit := s.hub.broadcasts.add
+	if msg.Type == "remove_from_list" {
+		edit = s.hub.broadcasts.remove
+	} else if errMsg := s.checkAccounts(msg.Recipients); errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	list, errMsg := edit(userID, msg.List, msg.Recipients)
+	if errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	sendJSON(conn, listUpdatedMessage(list, fmt.Sprintf("list %q now has %d contacts", list.Name, len(list.Recipients))))
+}
+
+// handleDeleteList deletes the sender's broadcast list msg.List and replies
+// "list_deleted". Nobody on it is told; they never knew it existed.
+func (s *Server) handleDeleteList(ctx context.Context, userID string, msg Message, conn *connection) {
+	if errMsg := s.hub.broadcasts.deleteList(userID, msg.List); errMsg != "" {
+		sendError(conn, errMsg)
+		return
+	}
+	sendJSON(conn, Message{
+		Type:    "list_deleted",
+		Sender:  "server",
+		List:    msg.List,
+		Content: fmt.Sprintf("deleted list %q", msg.List),
+	})
+}
+
+// handleGetLists sends the sender their broadcast lists in a "lists" reply.
+func (s *Server) handleGetLists(ctx context.Context, userID string, msg Message, conn *connection) {
+	sendJSON(conn, Message{Type: "lists", Sender: "server", Lists: s.hub.broadcasts.all(userID)})
+}
+
+// handleBroadcast sends msg.Content to everyone on the sender's broadcast
+// list msg.List, then replies "broadcast_sent".
+//
+// Each recipient gets an ordinary direct message, stored in their one-to-one
+// conversation with the sender, with its own ID, receipts and offline
+// queueing. The messages are stored together, in one write to the message
+// log, and the sender gets the one "broadcast_sent" reply instead of an ack
+// per recipient: a list can hold up to 256 contacts.
+func (s *Server) handleBroadcast(ctx context.Context, userID string, msg Message, conn *connection) {
+	if msg.List == "" || msg.Content == "" {
+		sendError(conn, "list and content are required for broadcast")
+		return
+	}
+	list, ok := s.hub.broadcasts.get(userID, msg.List)
+	if !ok {
+		sendError(conn, fmt.Sprintf("you have no list called %q", msg.List))
+		return
+	}
+	if len(list.Recipients) == 0 {
+		sendError(conn, fmt.Sprintf("list %q is empty", list.Name))
+		return
+	}
+	// Someone on the list may have lost their account since they were added.
+	// As with a direct message to them, nothing is sent: the sender is told
+	// who to take off the list instead.
+	if errMsg := s.checkAccounts(list.Recipients); errMsg != "" {
+		s.hub.metrics.dropped.inc(dropUnknownRecipient)
+		sendError(conn, errMsg)
+		return
+	}
+
+	now := time.Now().UTC()
+	entries := make([]StoredMessage, len(list.Recipients))
+	for i, recipient := range list.Recipients {
+		entries[i] = StoredMessage{
+			Conversation: directConversation(userID, recipient),
+			Message: Message{
+				ID:        newID(),
+				Sender:    userID,
+				Recipient: recipient,
+				Content:   msg.Content,
+				Timestamp: now,
+			},
+		}
+	}
+	recs, err := s.store.AppendAll(entries)
+	if err != nil {
+		conn.log.Error("store message", "type", "broadcast", "list", list.Name, "err", err)
+		sendError(conn, "broadcast could not be stored")
+		return
+	}
+	conn.log.Info("broadcast", "list", list.Name, "recipients", len(recs))
+	logContent(conn.log, msg)
+
+	queued := 0
+	for _, rec := range recs {
+		if !s.deliverDirect(rec.Message, conn) {
+			queued++
 		}
 	}
+	content := fmt.Sprintf("sent to %d contacts on list %q", len(recs), list.Name)
+	if queued > 0 {
+		content += fmt.Sprintf("; %d of them offline, queued for delivery", queued)
+	}
+	sendJSON(conn, Message{
+		Type:       "broadcast_sent",
+		Sender:     "server",
+		List:       list.Name,
+		Recipients: list.Recipients,
+		Content:    content,
+	})
+}
+
+// checkAccounts returns an error message naming whichever of users have no
+// active account, or an empty string if they all do.
+func (s *Server) checkAccounts(users []string) string {
+	var unknown []string
+	for _, user := range users {
+		if !s.users.Active(user) {
+			unknown = append(unknown, strconv.Quote(user))
+		}
+	}
+	if len(unknown) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("there is no user %s", strings.Join(unknown, ", "))
+}
+
+// listUpdatedMessage is the reply to a change to a broadcast list.
+func listUpdatedMessage(list BroadcastList, content string) Message {
+	return Message{
+		Type:    "list_updated",
+		Sender:  "server",
+		List:    list.Name,
+		Lists:   []BroadcastList{list},
+		Content: content,
+	}
+}
+
+// handleRead relays a read receipt to the original sender of a message. The
+// client sends {"type": "read", "id": "<message id>"} once it has displayed
+// the message. Reports for unknown messages, or from users who were not
+// recipients, are ignored.
+func (s *Server) handleRead(ctx context.Context, userID string, msg Message) {
+	sender, room, counts, ok := s.hub.receipts.markRead(msg.ID, userID)
+	if !ok {
+		return
+	}
+	// Like delivery receipts, read receipts go to all of the sender's
+	// devices and are dropped if the sender is offline (see confirmDelivery).
+	s.hub.sendToUser(sender, Message{
+		Type:    "read",
+		Sender:  userID,
+		Room:    room,
+		ID:      msg.ID,
+		Receipt: &counts,
+	}, nil)
+}
+
+// accept stamps an inbound chat message with a unique ID and the server's
+// receive time, then appends it to the conversation in the message store. The
+// returned copy also carries the per-conversation sequence number the store
+// assigned.
+//
+// LEARNING POINT — Server-Assigned Identity:
+// Clients' clocks drift and clients can lie, so anything used for ordering or
+// deduplication is assigned by the server at the moment it accepts the
+// message. The timestamp is taken in UTC so it serializes the same way no
+// matter which timezone the server runs in.
+func (s *Server) accept(conversation string, msg Message) (Message, error) {
+	msg.ID = newID()
+	msg.Timestamp = time.Now().UTC()
+	rec, err := s.store.Append(conversation, msg)
+	if err != nil {
+		return msg, err
+	}
+	return rec.Message, nil
+}
+
+// newID returns a random 128-bit identifier as 32 hex characters. It is used
+// for message IDs and connection IDs.
+//
+// LEARNING POINT — crypto/rand vs math/rand:
+// math/rand is fast but predictable; crypto/rand reads from the operating
+// system's secure random source. IDs that other users can see should not be
+// guessable, so crypto/rand is the right default. rand.Read never returns an
+// error on supported platforms.
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// sendAck tells the sender that the server accepted their message, echoing
+// back the ID, timestamp and sequence number it was assigned.
+func sendAck(conn *connection, msg Message) {
+	sendJSON(conn, Message{
+		Type:      "ack",
+		Sender:    "server",
+		Recipient: msg.Recipient,
+		Room:      msg.Room,
+		ID:        msg.ID,
+		Timestamp: msg.Timestamp,
+		Seq:       msg.Seq,
+	})
 }
 
-// sendJSON marshals a message and writes it to the WebSocket connection.
+// sendJSON marshals a message and queues it on the connection. The
+// connection's writer goroutine does the actual write (see connection.go).
 //
 // LEARNING POINT — Helper Functions:
 // Small utility functions like sendJSON reduce repetition and centralize error
 // handling. In Go, it's idiomatic to keep helpers in the same file where they're
 // used, rather than creating a separate "utils" package. Go favors flat package
 // structures over deep hierarchies.
-func sendJSON(ctx context.Context, c *websocket.Conn, msg Message) {
+func sendJSON(conn *connection, msg Message) {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		fmt.Printf("Error marshaling message: %v\n", err)
+		conn.log.Error("marshal message", "type", msg.Type, "err", err)
 		return
 	}
-	if err := c.Write(ctx, websocket.MessageText, data); err != nil {
-		fmt.Printf("Error writing message: %v\n", err)
-	}
+	conn.enqueue(outbound{data: data, kind: msg.Type})
 }
 
 // sendError sends a server error message back to a client. This is a thin
 // wrapper around sendJSON that constructs an error-typed Message.
-func sendError(ctx context.Context, c *websocket.Conn, errMsg string) {
+func sendError(conn *connection, errMsg string) {
 	msg := Message{
 		Type:    "error",
 		Sender:  "server",
 		Content: errMsg,
 	}
-	sendJSON(ctx, c, msg)
+	sendJSON(conn, msg)
 }
 
 // SetupRouter creates and configures the HTTP request multiplexer (router).
@@ -353,30 +1193,164 @@ func sendError(ctx context.Context, c *websocket.Conn, errMsg string) {
 // httptest.NewServer.
 func SetupRouter(s *Server) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", helloHandler)
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+	mux.HandleFunc("GET /version", s.versionHandler)
+	mux.HandleFunc("POST /signup", s.signupHandler)
+	mux.HandleFunc("POST /login", s.loginHandler)
+	mux.HandleFunc("POST /password", s.changePasswordHandler)
+	mux.HandleFunc("POST /account/disable", s.disableHandler)
 	mux.HandleFunc("/ws", s.wsHandler)
+	mux.HandleFunc("GET /metrics", s.metricsHandler)
 	return mux
 }
 
 // main is the entry point of the program. It creates the server, sets up
 // routes, and starts listening for HTTP connections.
 //
-// LEARNING POINT — http.ListenAndServe:
-// This single function call starts a production-capable HTTP server. It binds
-// to the given address (":8080" means all interfaces, port 8080) and serves
-// requests using the provided handler (our mux). It blocks forever, only
-// returning if the server encounters a fatal error (e.g., port already in use).
+// LEARNING POINT — http.Server and ListenAndServe:
+// ListenAndServe starts a production-capable HTTP server. It binds to the
+// given address (":8080" means all interfaces, port 8080) and serves requests
+// using the provided handler (our mux). It blocks until the server fails
+// (e.g., port already in use) or is shut down. Using an explicit http.Server
+// value rather than the http.ListenAndServe shortcut is what gives us a
+// Shutdown method to call later.
+//
+// LEARNING POINT — signal.NotifyContext:
+// signal.NotifyContext returns a context that is cancelled when the process
+// receives one of the listed signals. main simply waits on ctx.Done() and
+// then runs the graceful shutdown (see shutdown.go). Calling stop() restores
+// the default signal handling, so pressing Ctrl-C a second time kills the
+// process immediately instead of waiting for the shutdown to finish.
 //
 // In Go, the main function takes no arguments and returns no value. The program
 // exits when main returns. Command-line arguments are accessed via os.Args,
 // and exit codes are set with os.Exit().
 func main() {
+	cfg, err := LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	// Configuration errors are printed plainly: the logger's own settings
+	// come from the configuration, so it does not exist yet.
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %s\n", err)
+		os.Exit(2)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+	logger, err := newLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	slog.Info("configuration loaded", "config", cfg)
+
+	store, err := OpenFileStore(cfg.MessageLogPath)
+	if err != nil {
+		slog.Error("open message store", "path", cfg.MessageLogPath, "err", err)
+		os.Exit(1)
+	}
+
+	// A fixed secret lets tokens survive restarts (and be verified by other
+	// instances); without one, every restart logs everybody out.
+	secret := []byte(cfg.TokenSecret)
+	if len(secret) == 0 {
+		slog.Warn("no token secret configured (CHAT_TOKEN_SECRET); using a random one, so restarts log everybody out")
+		secret = randomSecret()
+	}
+
+	users, err := OpenUserRegistry(cfg.AccountsPath)
+	if err != nil {
+		slog.Error("open user registry", "path", cfg.AccountsPath, "err", err)
+		os.Exit(1)
+	}
+
+	lists, err := openBroadcastListStore(cfg.BroadcastListsPath)
+	if err != nil {
+		slog.Error("open broadcast lists", "path", cfg.BroadcastListsPath, "err", err)
+		os.Exit(1)
+	}
+
+	hub := cfg.newHub()
+	hub.broadcasts = lists
 	server := &Server{
-		hub: NewHub(),
+		hub:    hub,
+		store:  store,
+		tokens: NewTokenIssuer(secret, time.Duration(cfg.TokenTTL)),
+		users:  users,
+		config: cfg,
+	}
+	httpServer := &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: SetupRouter(server),
+		// net/http reports its own problems (TLS handshake failures,
+		// panics in handlers) through a *log.Logger; route them into slog.
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// http.ErrServerClosed is what ListenAndServe returns after a Shutdown,
+	// so it is the one error that isn't a failure.
+	serveErr := make(chan error, 2)
+	go func() {
+		var err error
+		if cfg.TLSCertFile != "" {
+			slog.Info("server starting", "addr", cfg.ListenAddr, "tls", true)
+			err = httpServer.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			slog.Info("server starting", "addr", cfg.ListenAddr, "tls", false)
+			err = httpServer.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	// The admin API gets its own listener so it can be bound to a private
+	// address that the public never reaches (see admin.go).
+	var adminServer *http.Server
+	if cfg.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr:     cfg.AdminAddr,
+			Handler:  AdminRouter(server),
+			ErrorLog: httpServer.ErrorLog,
+		}
+		go func() {
+			slog.Info("admin API starting", "addr", cfg.AdminAddr)
+			if err := adminServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		slog.Error("server failed", "err", err)
+		store.Close()
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutdown signal received; draining connections")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("graceful shutdown", "err", err)
 	}
-	mux := SetupRouter(server)
-	fmt.Println("Server starting on :8080")
-	if err := http.ListenAndServe(":8080", mux); err != nil {
-		fmt.Printf("Error starting server: %s\n", err)
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("stop HTTP server", "err", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("stop admin API", "err", err)
+		}
 	}
+	slog.Info("server stopped")
 }
@@ -15,6 +15,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 
 	// net/http/httptest provides utilities for HTTP testing:
@@ -23,11 +25,13 @@ import (
 	//   - httptest.NewServer(): creates a real HTTP server on a random port
 	//     (used in websocket_test.go for integration tests)
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
-// TestHelloHandler tests the "/" endpoint by sending a GET request and
-// verifying the response status code and body.
+// TestHealthzHandler tests the "/healthz" endpoint by sending a GET request
+// and verifying the response status code and body.
 //
 // LEARNING POINT — The httptest.NewRecorder Pattern:
 // This is the most common pattern for testing HTTP handlers in Go:
@@ -41,17 +45,18 @@ import (
 // This approach tests the handler through the real router, which also verifies
 // that routes are registered correctly. It's an in-process test — no real HTTP
 // server is started, no ports are used, and it runs in microseconds.
-func TestHelloHandler(t *testing.T) {
-	// Create a Server with a real Hub. For handler tests that don't use the
-	// hub, you could also pass a nil hub — but using NewHub() is safer and
-	// ensures the server is in a valid state.
-	server := &Server{hub: NewHub()}
+func TestHealthzHandler(t *testing.T) {
+	// Create a Server with real in-memory dependencies (see newTestServer in
+	// websocket_test.go). For handler tests that don't use the hub, you could
+	// also leave it nil — but a fully wired server is safer and ensures the
+	// server is in a valid state.
+	server := newTestServer()
 	mux := SetupRouter(server)
 
 	// http.NewRequest creates an *http.Request for testing. It doesn't make
 	// a real HTTP call — it just constructs the request object. The third
 	// argument (nil) is the request body (not needed for GET requests).
-	req, err := http.NewRequest("GET", "/", nil)
+	req, err := http.NewRequest("GET", "/healthz", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,9 +79,135 @@ func TestHelloHandler(t *testing.T) {
 
 	// Check the response body is what we expect.
 	// rr.Body.String() returns the full response body as a string.
-	expected := "Hello, World!"
+	expected := "ok"
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
 }
+
+// TestReadyzHandler verifies that readiness fails once a shutdown starts,
+// and when the message store can no longer be used.
+func TestReadyzHandler(t *testing.T) {
+	ready := func(s *Server) int {
+		rr := httptest.NewRecorder()
+		SetupRouter(s).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+		return rr.Code
+	}
+
+	s := newTestServer()
+	if code := ready(s); code != http.StatusOK {
+		t.Fatalf("expected a fresh server to be ready, got %d", code)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code := ready(s); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 during shutdown, got %d", code)
+	}
+
+	s = newTestServer()
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "messages.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.store = store
+	if code := ready(s); code != http.StatusOK {
+		t.Fatalf("expected a server with an open store to be ready, got %d", code)
+	}
+	store.Close()
+	if code := ready(s); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with a closed store, got %d", code)
+	}
+}
+
+// TestVersionHandler checks that /version reports the build and the number
+// of connected users.
+func TestVersionHandler(t *testing.T) {
+	s := newTestServer()
+	s.hub.register("alice", &connection{})
+
+	rr := httptest.NewRecorder()
+	SetupRouter(s).ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	var info versionInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	if info.Version != version || info.GoVersion == "" || info.Users != 1 || info.StartedAt.IsZero() {
+		t.Errorf("unexpected version info %+v", info)
+	}
+}
+
+// postJSON sends a JSON body to path through the router and returns the
+// recorded response.
+func postJSON(t *testing.T, mux *http.ServeMux, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestSignupAndLogin verifies that POST /signup creates an account and that
+// POST /login then returns a token that verifies to that user.
+func TestSignupAndLogin(t *testing.T) {
+	server := newTestServer()
+	mux := SetupRouter(server)
+
+	rr := postJSON(t, mux, "/signup", `{"user": "alice", "password": "hunter2hunter2"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 from signup, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = postJSON(t, mux, "/login", `{"user": "alice", "password": "hunter2hunter2"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 from login, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp loginResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if user, err := server.tokens.Verify(resp.Token); err != nil || user != "alice" {
+		t.Errorf("expected token for alice, got user %q err %v", user, err)
+	}
+}
+
+// TestAccountEndpointErrors verifies the status codes returned for the
+// common failure cases of the account endpoints.
+//
+// LEARNING POINT — Table-Driven Tests Over Sequential Steps:
+// The rows share one server, so they run in order and later rows can depend
+// on earlier ones (the duplicate signup relies on the first row). That's a
+// deliberate trade-off: it keeps each row to one line at the cost of the rows
+// not being independent.
+func TestAccountEndpointErrors(t *testing.T) {
+	mux := SetupRouter(newTestServer())
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"signup", "/signup", `{"user": "bob", "password": "longenough"}`, http.StatusCreated},
+		{"duplicate signup", "/signup", `{"user": "bob", "password": "longenough"}`, http.StatusConflict},
+		{"short password", "/signup", `{"user": "carol", "password": "short"}`, http.StatusBadRequest},
+		{"bad username", "/signup", `{"user": "no spaces", "password": "longenough"}`, http.StatusBadRequest},
+		{"missing user", "/login", `{}`, http.StatusBadRequest},
+		{"wrong password", "/login", `{"user": "bob", "password": "wrongwrong"}`, http.StatusUnauthorized},
+		{"unknown user", "/login", `{"user": "nobody", "password": "longenough"}`, http.StatusUnauthorized},
+		{"change password", "/password", `{"user": "bob", "password": "longenough", "new_password": "evenlonger"}`, http.StatusNoContent},
+		{"old password gone", "/login", `{"user": "bob", "password": "longenough"}`, http.StatusUnauthorized},
+		{"disable", "/account/disable", `{"user": "bob", "password": "evenlonger"}`, http.StatusNoContent},
+		{"login disabled", "/login", `{"user": "bob", "password": "evenlonger"}`, http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		rr := postJSON(t, mux, tc.path, tc.body)
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+}
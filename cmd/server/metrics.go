@@ -0,0 +1,248 @@
+// This file implements the /metrics endpoint, which reports what the server
+// is doing in the Prometheus text exposition format:
+//
+//	# HELP chat_connected_users Users with at least one live connection.
+//	# TYPE chat_connected_users gauge
+//	chat_connected_users 2
+//	# TYPE chat_messages_received_total counter
+//	chat_messages_received_total{type="dm"} 17
+//
+// Prometheus (or anything that speaks the format, including curl and a
+// human) scrapes the endpoint periodically; the server itself only keeps a
+// handful of counters in memory and needs no client library or external
+// service.
+//
+// There are three kinds of metric here:
+//
+//	counter    a total that only goes up (messages received, write errors)
+//	gauge      a value sampled at scrape time (connected users, queue depth)
+//	histogram  counts of observations in cumulative buckets (write latency)
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - sync/atomic for lock-free counters on hot paths
+//   - Sorting map keys for deterministic output
+//   - strconv.FormatFloat for compact number formatting
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxLabelValues bounds how many distinct label values a counterVec tracks.
+// Message types come from clients, and every distinct value becomes its own
+// time series in Prometheus, so a client sending random types must not be
+// able to grow the metrics without limit. Values past the cap are counted
+// under "other".
+const maxLabelValues = 64
+
+// writeLatencyBuckets are the upper bounds, in seconds, of the write latency
+// histogram. Writes to a healthy client take well under a millisecond; the
+// top buckets approach writeTimeout.
+var writeLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Reasons a message is dropped rather than delivered, used as the "reason"
+// label of chat_messages_dropped_total.
+const (
+	dropUnknownRecipient = "unknown_recipient" // direct message with no recipient, or to no active account
+	dropNotMember        = "not_member"        // room message to a room the sender isn't in
+	dropNotAllowed       = "not_allowed"       // room message from a member the room doesn't let post
+	dropQueueFull        = "queue_full"        // discarded by the drop_oldest slow-consumer policy
+)
+
+// metrics holds the server's counters and histograms. Gauges are not stored;
+// they are read from the hub when /metrics is scraped.
+type metrics struct {
+	messagesIn   counterVec
+	messagesOut  counterVec
+	dropped      counterVec
+	writeErrors  atomic.Uint64
+	writeLatency histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{writeLatency: histogram{buckets: writeLatencyBuckets, counts: make([]uint64, len(writeLatencyBuckets))}}
+}
+
+// typeLabel returns the metrics label for a message type. Direct messages
+// have no type on the wire.
+func typeLabel(msgType string) string {
+	if msgType == "" {
+		return "dm"
+	}
+	return msgType
+}
+
+// observeWrite records the outcome of one socket write of a kind-typed
+// message that took d.
+func (m *metrics) observeWrite(kind string, d time.Duration, err error) {
+	m.writeLatency.observe(d.Seconds())
+	if err != nil {
+		m.writeErrors.Add(1)
+		return
+	}
+	m.messagesOut.inc(typeLabel(kind))
+}
+
+// counterVec is a family of counters distinguished by one label value.
+//
+// LEARNING POINT — Zero Values That Work:
+// A counterVec needs no constructor: inc creates the map on first use, so
+// the zero value embedded in metrics is ready to go.
+type counterVec struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (v *counterVec) inc(label string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]uint64)
+	}
+	if _, ok := v.counts[label]; !ok && len(v.counts) >= maxLabelValues {
+		label = "other"
+	}
+	v.counts[label]++
+}
+
+// snapshot returns a copy of the counts.
+func (v *counterVec) snapshot() map[string]uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts := make(map[string]uint64, len(v.counts))
+	for k, n := range v.counts {
+		counts[k] = n
+	}
+	return counts
+}
+
+// histogram counts observations into buckets. counts[i] is the number of
+// observations no larger than buckets[i] but larger than buckets[i-1]; they
+// are accumulated when written out, as the format requires.
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	total   uint64 // includes observations above the last bucket
+	sum     float64
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.total++
+	h.sum += v
+}
+
+// hubGauges is a point-in-time view of the hub for /metrics.
+type hubGauges struct {
+	users, connections, rooms int
+	queueDepth, maxQueueDepth int
+}
+
+// gauges samples the hub. Queue lengths are read without each connection's
+// lock: len on a channel is safe to call concurrently, and a value that is
+// a moment stale is fine for monitoring.
+func (h *Hub) gauges() hubGauges {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	g := hubGauges{users: len(h.clients), rooms: len(h.rooms)}
+	for _, devices := range h.clients {
+		for conn := range devices {
+			g.connections++
+			depth := len(conn.send)
+			g.queueDepth += depth
+			g.maxQueueDepth = max(g.maxQueueDepth, depth)
+		}
+	}
+	return g
+}
+
+// metricsHandler serves the metrics in the Prometheus text format.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	s.hub.writeMetrics(bw)
+	bw.Flush()
+}
+
+// writeMetrics writes every metric in the text exposition format.
+func (h *Hub) writeMetrics(w io.Writer) {
+	g := h.gauges()
+	writeGauge(w, "chat_connected_users", "Users with at least one live connection.", g.users)
+	writeGauge(w, "chat_connections", "Live WebSocket connections (devices).", g.connections)
+	writeGauge(w, "chat_rooms", "Rooms that exist.", g.rooms)
+	writeGauge(w, "chat_outbound_queue_depth", "Messages waiting in all outbound queues.", g.queueDepth)
+	writeGauge(w, "chat_outbound_queue_max_depth", "Messages waiting in the fullest outbound queue.", g.maxQueueDepth)
+
+	m := h.metrics
+	writeCounterVec(w, "chat_messages_received_total", "Messages received from clients, by type.", "type", m.messagesIn.snapshot())
+	writeCounterVec(w, "chat_messages_sent_total", "Messages written to clients, by type.", "type", m.messagesOut.snapshot())
+	writeCounterVec(w, "chat_messages_dropped_total", "Messages dropped instead of delivered, by reason.", "reason", m.dropped.snapshot())
+
+	fmt.Fprintf(w, "# HELP chat_write_errors_total Failed writes to client connections.\n")
+	fmt.Fprintf(w, "# TYPE chat_write_errors_total counter\n")
+	fmt.Fprintf(w, "chat_write_errors_total %d\n", m.writeErrors.Load())
+
+	m.writeLatency.write(w, "chat_write_duration_seconds", "Time taken to write one message to a client.")
+}
+
+func writeGauge(w io.Writer, name, help string, v int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, v)
+}
+
+// writeCounterVec writes one line per label value, sorted so that the output
+// is stable from scrape to scrape.
+func writeCounterVec(w io.Writer, name, help, label string, counts map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, counts[k])
+	}
+}
+
+// write emits the histogram's cumulative buckets, then its sum and count.
+//
+// LEARNING POINT — Cumulative Buckets:
+// In the Prometheus format, the bucket le="0.01" counts every observation
+// up to 0.01, including those already counted in le="0.005". The final
+// le="+Inf" bucket therefore always equals the total count.
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.total)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.total)
+}
+
+// formatFloat formats v in the shortest form that reads back exactly, as
+// Prometheus expects ("0.005", not "5.000000e-03").
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
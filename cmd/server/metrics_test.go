@@ -0,0 +1,124 @@
+// This file contains tests for the /metrics endpoint (defined in metrics.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Checking text output line by line with strings.Contains
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TestHistogramBuckets verifies that bucket counts are written cumulatively
+// and that observations above the last bucket only show up in +Inf.
+func TestHistogramBuckets(t *testing.T) {
+	h := histogram{buckets: []float64{0.1, 1}, counts: make([]uint64, 2)}
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.observe(v)
+	}
+	var out strings.Builder
+	h.write(&out, "x", "help")
+	for _, want := range []string{
+		`x_bucket{le="0.1"} 2`,
+		`x_bucket{le="1"} 3`,
+		`x_bucket{le="+Inf"} 4`,
+		`x_sum 3.65`,
+		`x_count 4`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestCounterVecCapsLabels verifies that a client cannot create unbounded
+// label values by sending made-up message types.
+func TestCounterVecCapsLabels(t *testing.T) {
+	var v counterVec
+	for i := range maxLabelValues + 10 {
+		v.inc("type" + strconv.Itoa(i))
+	}
+	counts := v.snapshot()
+	if len(counts) != maxLabelValues+1 || counts["other"] != 10 {
+		t.Errorf("expected %d labels plus 10 under other, got %d labels, other=%d", maxLabelValues, len(counts), counts["other"])
+	}
+}
+
+// TestMetricsEndpoint exchanges a few messages and checks that /metrics
+// reports them.
+func TestMetricsEndpoint(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.CloseNow()
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.CloseNow()
+
+	for _, msg := range []Message{
+		{Type: "create_room", Content: "general"},
+		{Recipient: "bob", Content: "hi"},
+		{Content: "to nobody"},
+	} {
+		data, _ := json.Marshal(msg)
+		alice.Write(ctx, websocket.MessageText, data)
+	}
+	readUntil(t, ctx, alice, "error")
+	readUntil(t, ctx, bob, "")
+	data, _ := json.Marshal(Message{Type: "room_msg", Room: "general", Content: "let me in"})
+	bob.Write(ctx, websocket.MessageText, data)
+
+	var body string
+	eventually(t, "bob's rejected room message to be counted", func() bool {
+		resp, err := http.Get(server.URL + "/metrics")
+		if err != nil {
+			t.Fatalf("scrape: %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		body = string(b)
+		return strings.Contains(body, `chat_messages_dropped_total{reason="not_member"} 1`)
+	})
+
+	for _, want := range []string{
+		"chat_connected_users 2",
+		"chat_connections 2",
+		"chat_rooms 1",
+		`chat_messages_received_total{type="create_room"} 1`,
+		`chat_messages_received_total{type="dm"} 2`,
+		`chat_messages_received_total{type="room_msg"} 1`,
+		`chat_messages_sent_total{type="dm"} 1`,
+		`chat_messages_sent_total{type="ack"} 1`,
+		`chat_messages_dropped_total{reason="unknown_recipient"} 1`,
+		"chat_write_errors_total 0",
+		"# TYPE chat_write_duration_seconds histogram",
+		"chat_outbound_queue_depth ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// This file implements the offline delivery queue: direct and room messages
+// sent to a user who is not connected are held here and flushed, in order,
+// the next time that user registers with the Hub.
+//
+// Direct messages and room messages are queued separately. Each room a user
+// belongs to gets its own bounded backlog, so one busy room cannot push a
+// user's direct messages (or quieter rooms) out of the queue. When a room
+// backlog overflows, the user gets a "missed_messages" summary in place of
+// the messages that were dropped.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Slices used as FIFO queues
+//   - Nested maps (map[string]map[string]*T) and lazy initialization
+//   - sort.SliceStable for merging queues by time
+//   - Injecting a clock (func() time.Time) to make time-based code testable
+//   - Separate mutexes for separate pieces of state
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultOfflineQueueLimit is the most messages held for one offline
+	// user. When the queue is full the oldest message is dropped.
+	defaultOfflineQueueLimit = 100
+
+	// defaultRoomBacklogLimit is the most messages held per room for one
+	// offline member.
+	defaultRoomBacklogLimit = 50
+
+	// defaultOfflineQueueTTL is how long a queued message stays deliverable.
+	defaultOfflineQueueTTL = 7 * 24 * time.Hour
+)
+
+// queuedMessage is a message waiting for its recipient to come online.
+type queuedMessage struct {
+	msg      Message
+	queuedAt time.Time
+}
+
+// roomBacklog is one offline member's pending traffic for one room.
+// missed counts messages that were dropped (overflow or expiry) since the
+// member last connected.
+type roomBacklog struct {
+	entries []queuedMessage
+	missed  int
+}
+
+// offlineQueue holds pending messages for users who are not connected.
+//
+// LEARNING POINT — Injectable Clocks:
+// The now field defaults to time.Now, but tests can replace it with a
+// function returning a fixed time. This is the simplest way to test expiry
+// logic without sleeping for real: code that calls time.Now() directly is
+// hard to test, code that calls q.now() is easy.
+type offlineQueue struct {
+	mu        sync.Mutex
+	limit     int
+	roomLimit int
+	ttl       time.Duration
+	now       func() time.Time
+	queues    map[string][]queuedMessage
+	rooms     map[string]map[string]*roomBacklog // user -> room -> backlog
+}
+
+// newOfflineQueue creates an offline queue with the given per-user limit for
+// direct messages, per-room limit for room messages, and message
+// time-to-live.
+func newOfflineQueue(limit, roomLimit int, ttl time.Duration) *offlineQueue {
+	return &offlineQueue{
+		limit:     limit,
+		roomLimit: roomLimit,
+		ttl:       ttl,
+		now:       time.Now,
+		queues:    make(map[string][]queuedMessage),
+		rooms:     make(map[string]map[string]*roomBacklog),
+	}
+}
+
+// push queues a message for an offline user. Room messages go to the backlog
+// for their room; everything else is treated as a direct message. Either
+// way, the oldest queued message is dropped if the queue is already at its
+// limit.
+//
+// LEARNING POINT — Slices as Queues:
+// append adds to the back and q[1:] removes from the front. Re-slicing does
+// not copy; it just moves the start of the view forward. The dropped element
+// stays in the underlying array until the next reallocation, which is fine
+// for a small bounded queue like this one.
+func (q *offlineQueue) push(user string, msg Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if msg.Type == "room_msg" {
+		q.pushRoom(user, msg)
+		return
+	}
+	pending := q.unexpired(q.queues[user])
+	if len(pending) >= q.limit {
+		pending = pending[len(pending)-q.limit+1:]
+	}
+	q.queues[user] = append(pending, queuedMessage{msg: msg, queuedAt: q.now()})
+}
+
+// pushRoom appends a message to a user's backlog for msg.Room, counting any
+// messages that expire or overflow as missed. The caller must hold q.mu.
+//
+// LEARNING POINT — Lazily Initialized Nested Maps:
+// The inner map for a user is only created the first time that user has room
+// traffic queued. Reading a missing key from the outer map yields a nil inner
+// map, and reading from a nil map is fine — but writing to one panics, which
+// is why the inner map must be made before the first write.
+func (q *offlineQueue) pushRoom(user string, msg Message) {
+	backlog := q.backlog(user, msg.Room)
+	live := q.unexpired(backlog.entries)
+	backlog.missed += len(backlog.entries) - len(live)
+	if len(live) >= q.roomLimit {
+		drop := len(live) - q.roomLimit + 1
+		backlog.missed += drop
+		live = live[drop:]
+	}
+	backlog.entries = append(live, queuedMessage{msg: msg, queuedAt: q.now()})
+}
+
+// backlog returns user's backlog for room, creating it if need be. The
+// caller must hold q.mu.
+func (q *offlineQueue) backlog(user, room string) *roomBacklog {
+	byRoom, ok := q.rooms[user]
+	if !ok {
+		byRoom = make(map[string]*roomBacklog)
+		q.rooms[user] = byRoom
+	}
+	backlog, ok := byRoom[room]
+	if !ok {
+		backlog = &roomBacklog{}
+		byRoom[room] = backlog
+	}
+	return backlog
+}
+
+// pushRoomAll appends a message to the backlog for msg.Room of each of
+// users, taking the lock once for the whole batch (see fanOutChannel).
+func (q *offlineQueue) pushRoomAll(users []string, msg Message) {
+	if len(users) == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, user := range users {
+		q.pushRoom(user, msg)
+	}
+}
+
+// forgetRoom discards a user's backlog for a room they are no longer in.
+func (q *offlineQueue) forgetRoom(user, room string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.rooms[user], room)
+}
+
+// pushFront puts messages back at the head of a user's queues. It is used
+// when a flush fails partway through, so the undelivered tail is retried in
+// its original order on the next connect. Anything for a room (its messages
+// and its missed_messages summary) goes back to that room's backlog, so that
+// forgetRoom still drops it if the user leaves the room in the meantime;
+// drain merges the queues back into order by queuedAt, which each message
+// keeps.
+func (q *offlineQueue) pushFront(user string, msgs []queuedMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var direct []queuedMessage
+	byRoom := make(map[string][]queuedMessage)
+	for _, m := range msgs {
+		if m.msg.Room == "" {
+			direct = append(direct, m)
+		} else {
+			byRoom[m.msg.Room] = append(byRoom[m.msg.Room], m)
+		}
+	}
+
+	pending := append(direct, q.queues[user]...)
+	if len(pending) > q.limit {
+		pending = pending[len(pending)-q.limit:]
+	}
+	if len(pending) > 0 {
+		q.queues[user] = pending
+	}
+	for room, entries := range byRoom {
+		backlog := q.backlog(user, room)
+		live := append(entries, backlog.entries...)
+		if len(live) > q.roomLimit {
+			drop := len(live) - q.roomLimit
+			backlog.missed += drop
+			live = live[drop:]
+		}
+		backlog.entries = live
+	}
+}
+
+// drain removes and returns every unexpired message queued for a user,
+// oldest first. Each room that dropped messages contributes a
+// "missed_messages" summary just ahead of its oldest retained message.
+//
+// LEARNING POINT — sort.SliceStable:
+// Direct and room queues are each in time order already; merging them is a
+// sort by queuedAt. The "stable" variant keeps elements with equal keys in
+// their original relative order, which is what keeps each room's summary
+// ahead of that room's first message (they share a timestamp).
+func (q *offlineQueue) drain(user string) []queuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.unexpired(q.queues[user])
+	delete(q.queues, user)
+
+	for room, backlog := range q.rooms[user] {
+		live := q.unexpired(backlog.entries)
+		missed := backlog.missed + len(backlog.entries) - len(live)
+		if missed > 0 {
+			at := q.now()
+			if len(live) > 0 {
+				at = live[0].queuedAt
+			}
+			pending = append(pending, queuedMessage{msg: missedSummary(room, missed), queuedAt: at})
+		}
+		pending = append(pending, live...)
+	}
+	delete(q.rooms, user)
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].queuedAt.Before(pending[j].queuedAt)
+	})
+	return pending
+}
+
+// missedSummary builds the notice sent in place of dropped room messages.
+func missedSummary(room string, missed int) Message {
+	return Message{
+		Type:    "missed_messages",
+		Sender:  "server",
+		Room:    room,
+		Content: fmt.Sprintf("you missed %d message(s) in room %q while offline", missed, room),
+	}
+}
+
+// unexpired filters out messages older than the queue's TTL. Messages are
+// queued in time order, so everything before the first live message has
+// expired. The caller must hold q.mu.
+func (q *offlineQueue) unexpired(pending []queuedMessage) []queuedMessage {
+	cutoff := q.now().Add(-q.ttl)
+	for i, m := range pending {
+		if m.queuedAt.After(cutoff) {
+			return pending[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// This file contains unit tests for the offline delivery queue (defined in
+// offline.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Replacing a clock function to test expiry without time.Sleep
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestOfflineQueueOrder verifies that queued messages drain oldest first and
+// that draining empties the queue.
+func TestOfflineQueueOrder(t *testing.T) {
+	q := newOfflineQueue(10, 10, time.Hour)
+	q.push("bob", Message{Content: "one"})
+	q.push("bob", Message{Content: "two"})
+
+	pending := q.drain("bob")
+	if len(pending) != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", len(pending))
+	}
+	if pending[0].msg.Content != "one" || pending[1].msg.Content != "two" {
+		t.Errorf("expected messages in send order, got %q then %q",
+			pending[0].msg.Content, pending[1].msg.Content)
+	}
+	if again := q.drain("bob"); len(again) != 0 {
+		t.Errorf("expected queue to be empty after drain, got %d", len(again))
+	}
+}
+
+// TestOfflineQueueLimit verifies that a full queue drops its oldest message.
+func TestOfflineQueueLimit(t *testing.T) {
+	q := newOfflineQueue(2, 2, time.Hour)
+	q.push("bob", Message{Content: "one"})
+	q.push("bob", Message{Content: "two"})
+	q.push("bob", Message{Content: "three"})
+
+	pending := q.drain("bob")
+	if len(pending) != 2 {
+		t.Fatalf("expected queue capped at 2, got %d", len(pending))
+	}
+	if pending[0].msg.Content != "two" {
+		t.Errorf("expected oldest message to be dropped, first is %q", pending[0].msg.Content)
+	}
+}
+
+// TestOfflineQueueExpiry verifies that messages older than the TTL are not
+// delivered.
+//
+// LEARNING POINT — Controlling Time in Tests:
+// Instead of sleeping for an hour, the test swaps q.now for a closure over a
+// local variable and moves "now" forward by assigning to that variable.
+func TestOfflineQueueExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := newOfflineQueue(10, 10, time.Hour)
+	q.now = func() time.Time { return now }
+
+	q.push("bob", Message{Content: "stale"})
+	now = now.Add(90 * time.Minute)
+	q.push("bob", Message{Content: "fresh"})
+
+	pending := q.drain("bob")
+	if len(pending) != 1 || pending[0].msg.Content != "fresh" {
+		t.Errorf("expected only the fresh message, got %+v", pending)
+	}
+}
+
+// TestOfflineQueueRoomBacklog verifies that room traffic is capped per room
+// and that overflow is reported as a missed_messages summary ahead of the
+// retained messages.
+func TestOfflineQueueRoomBacklog(t *testing.T) {
+	q := newOfflineQueue(10, 2, time.Hour)
+	for _, text := range []string{"one", "two", "three", "four"} {
+		q.push("bob", Message{Type: "room_msg", Room: "general", Content: text})
+	}
+	q.push("bob", Message{Sender: "alice", Content: "direct"})
+
+	pending := q.drain("bob")
+	var got []string
+	for _, p := range pending {
+		got = append(got, p.msg.Type+":"+p.msg.Content)
+	}
+	if len(pending) != 4 {
+		t.Fatalf("expected summary + 2 room messages + 1 direct, got %v", got)
+	}
+	if pending[0].msg.Type != "missed_messages" || pending[0].msg.Room != "general" {
+		t.Fatalf("expected missed_messages summary first, got %v", got)
+	}
+	if pending[1].msg.Content != "three" || pending[2].msg.Content != "four" {
+		t.Errorf("expected the newest room messages to be kept, got %v", got)
+	}
+	if pending[3].msg.Content != "direct" {
+		t.Errorf("expected direct message to be delivered last, got %v", got)
+	}
+}
+
+// TestOfflineQueueRoomBacklogSeparate verifies that a busy room does not
+// evict a quieter room's backlog.
+func TestOfflineQueueRoomBacklogSeparate(t *testing.T) {
+	q := newOfflineQueue(10, 1, time.Hour)
+	q.push("bob", Message{Type: "room_msg", Room: "quiet", Content: "hello"})
+	q.push("bob", Message{Type: "room_msg", Room: "busy", Content: "a"})
+	q.push("bob", Message{Type: "room_msg", Room: "busy", Content: "b"})
+
+	kept := map[string]bool{}
+	for _, p := range q.drain("bob") {
+		kept[p.msg.Room+":"+p.msg.Content] = true
+	}
+	if !kept["quiet:hello"] {
+		t.Error("expected quiet room's message to survive busy room overflow")
+	}
+	if kept["busy:a"] || !kept["busy:b"] {
+		t.Errorf("expected busy room to keep only its newest message, got %v", kept)
+	}
+}
+
+// TestOfflineQueuePushFront verifies that requeued room traffic goes back to
+// its room's backlog, where leaving the room still discards it.
+func TestOfflineQueuePushFront(t *testing.T) {
+	q := newOfflineQueue(10, 10, time.Hour)
+	q.push("bob", Message{Content: "direct"})
+	q.push("bob", Message{Type: "room_msg", Room: "general", Content: "room"})
+
+	pending := q.drain("bob")
+	q.pushFront("bob", pending)
+	if again := q.drain("bob"); len(again) != 2 || again[0].msg.Content != "direct" || again[1].msg.Content != "room" {
+		t.Fatalf("expected both messages back in order, got %+v", again)
+	}
+
+	q.pushFront("bob", pending)
+	q.forgetRoom("bob", "general")
+	if left := q.drain("bob"); len(left) != 1 || left[0].msg.Content != "direct" {
+		t.Errorf("expected only the direct message after leaving the room, got %+v", left)
+	}
+}
@@ -0,0 +1,171 @@
+// This file implements delivery and read receipts — WhatsApp's "ticks".
+//
+// Every direct and room message moves through three states from its sender's
+// point of view:
+//
+//	✓   sent       the server accepted and stored it (the "ack" message)
+//	✓✓  delivered  a recipient's connection accepted the write
+//	✓✓  read       a recipient's client reported it was displayed
+//
+// The receipt tracker remembers, per message ID, who the recipients are and
+// which of them have had the message delivered or have read it. Room messages
+// have several recipients, so receipts carry counts ("delivered to 3/5").
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Returning a value copy of internal state instead of a pointer into it
+//   - Bounding memory with a FIFO of keys
+package main
+
+import "sync"
+
+// defaultReceiptLimit caps how many messages the receipt tracker remembers.
+// Once full, the oldest messages stop producing receipts.
+const defaultReceiptLimit = 10000
+
+// Receipt summarizes the delivery state of one message across its recipients.
+// It travels on "delivered" and "read" notifications to the sender.
+type Receipt struct {
+	Delivered int `json:"delivered"`
+	Read      int `json:"read"`
+	Total     int `json:"total"`
+}
+
+// receiptState is the tracker's record for one message.
+type receiptState struct {
+	sender     string
+	room       string
+	recipients map[string]bool
+	delivered  map[string]bool
+	read       map[string]bool
+}
+
+// counts builds a Receipt from the current state.
+func (st *receiptState) counts() Receipt {
+	return Receipt{
+		Delivered: len(st.delivered),
+		Read:      len(st.read),
+		Total:     len(st.recipients),
+	}
+}
+
+// receiptTracker records per-recipient delivery state for recent messages.
+//
+// LEARNING POINT — Bounding Memory:
+// A map keyed by message ID would otherwise grow forever, because some
+// recipients never read some messages. The order slice remembers insertion
+// order so the oldest entries can be evicted once the tracker is full.
+// Entries that are fully read are deleted early, leaving stale IDs in order;
+// those are skipped during eviction and compacted away when order grows too
+// large.
+type receiptTracker struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[string]*receiptState
+	order   []string
+}
+
+// newReceiptTracker creates a tracker that remembers at most limit messages.
+func newReceiptTracker(limit int) *receiptTracker {
+	return &receiptTracker{
+		limit:   limit,
+		entries: make(map[string]*receiptState),
+	}
+}
+
+// track starts tracking receipts for a message. room is empty for direct
+// messages.
+func (rt *receiptTracker) track(id, sender, room string, recipients []string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	st := &receiptState{
+		sender:     sender,
+		room:       room,
+		recipients: make(map[string]bool, len(recipients)),
+		delivered:  make(map[string]bool),
+		read:       make(map[string]bool),
+	}
+	for _, r := range recipients {
+		st.recipients[r] = true
+	}
+	rt.entries[id] = st
+	rt.order = append(rt.order, id)
+
+	for len(rt.entries) > rt.limit {
+		delete(rt.entries, rt.order[0])
+		rt.order = rt.order[1:]
+	}
+	if len(rt.order) > 2*rt.limit {
+		live := make([]string, 0, len(rt.entries))
+		for _, id := range rt.order {
+			if _, ok := rt.entries[id]; ok {
+				live = append(live, id)
+			}
+		}
+		rt.order = live
+	}
+}
+
+// markDelivered records that user's connection accepted message id. It
+// returns the original sender, the room (if any) and the updated counts; ok is
+// false if the message is unknown, user is not one of its recipients, or the
+// delivery was already recorded.
+//
+// LEARNING POINT — Returning Copies, Not Pointers:
+// The caller gets a Receipt value, not the *receiptState. Once the mutex is
+// released another goroutine may change the state, so handing out a pointer
+// would invite data races. Copying a few ints is cheap and safe.
+func (rt *receiptTracker) markDelivered(id, user string) (sender, room string, r Receipt, ok bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	st, exists := rt.entries[id]
+	if !exists || !st.recipients[user] || st.delivered[user] {
+		return "", "", Receipt{}, false
+	}
+	st.delivered[user] = true
+	return st.sender, st.room, st.counts(), true
+}
+
+// markRead records that user has read message id. Reading implies delivery.
+// The return values are as for markDelivered. Once every recipient has read
+// the message, it is no longer tracked.
+func (rt *receiptTracker) markRead(id, user string) (sender, room string, r Receipt, ok bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	st, exists := rt.entries[id]
+	if !exists || !st.recipients[user] || st.read[user] {
+		return "", "", Receipt{}, false
+	}
+	st.delivered[user] = true
+	st.read[user] = true
+	counts := st.counts()
+	if counts.Read == counts.Total {
+		delete(rt.entries, id)
+	}
+	return st.sender, st.room, counts, true
+}
+
+// confirmDelivery records that msg reached recipient and, if that changed the
+// message's receipt, tells every device of the original sender. A recipient
+// with several devices counts once, on whichever device is reached first.
+// Messages without an ID (such as server notices) are ignored.
+//
+// Receipts are best effort: if the sender is offline the notification is
+// dropped rather than queued, so a busy room cannot flood the sender's
+// offline queue with ticks and push out real messages.
+func (h *Hub) confirmDelivery(msg Message, recipient string) {
+	if msg.ID == "" {
+		return
+	}
+	sender, room, counts, ok := h.receipts.markDelivered(msg.ID, recipient)
+	if !ok {
+		return
+	}
+	h.sendToUser(sender, Message{
+		Type:      "delivered",
+		Sender:    "server",
+		Recipient: recipient,
+		Room:      room,
+		ID:        msg.ID,
+		Receipt:   &counts,
+	}, nil)
+}
@@ -0,0 +1,69 @@
+// This file contains unit tests for the receipt tracker (defined in
+// receipts.go).
+package main
+
+import "testing"
+
+// TestReceiptTrackerRoomCounts verifies that delivered and read counts
+// accumulate per recipient for a room message.
+func TestReceiptTrackerRoomCounts(t *testing.T) {
+	rt := newReceiptTracker(10)
+	rt.track("m1", "alice", "general", []string{"bob", "carol", "dave"})
+
+	rt.markDelivered("m1", "bob")
+	sender, room, r, ok := rt.markDelivered("m1", "carol")
+	if !ok {
+		t.Fatal("expected delivery to carol to be recorded")
+	}
+	if sender != "alice" || room != "general" {
+		t.Errorf("expected alice in general, got %s in %s", sender, room)
+	}
+	if r != (Receipt{Delivered: 2, Read: 0, Total: 3}) {
+		t.Errorf("expected delivered 2/3, got %+v", r)
+	}
+
+	// Reading implies delivery, so dave's read bumps both counts.
+	_, _, r, _ = rt.markRead("m1", "dave")
+	if r != (Receipt{Delivered: 3, Read: 1, Total: 3}) {
+		t.Errorf("expected delivered 3/3 read 1/3, got %+v", r)
+	}
+}
+
+// TestReceiptTrackerRejects verifies that duplicate reports, reports from
+// non-recipients and reports for unknown messages are ignored.
+func TestReceiptTrackerRejects(t *testing.T) {
+	rt := newReceiptTracker(10)
+	rt.track("m1", "alice", "", []string{"bob"})
+
+	if _, _, _, ok := rt.markDelivered("m1", "mallory"); ok {
+		t.Error("expected non-recipient delivery to be ignored")
+	}
+	if _, _, _, ok := rt.markDelivered("nope", "bob"); ok {
+		t.Error("expected unknown message to be ignored")
+	}
+	rt.markDelivered("m1", "bob")
+	if _, _, _, ok := rt.markDelivered("m1", "bob"); ok {
+		t.Error("expected duplicate delivery to be ignored")
+	}
+}
+
+// TestReceiptTrackerForgets verifies that fully read messages are dropped and
+// that the tracker never holds more than its limit.
+func TestReceiptTrackerForgets(t *testing.T) {
+	rt := newReceiptTracker(2)
+	rt.track("m1", "alice", "", []string{"bob"})
+	rt.markRead("m1", "bob")
+	if _, ok := rt.entries["m1"]; ok {
+		t.Error("expected fully read message to be forgotten")
+	}
+
+	rt.track("m2", "alice", "", []string{"bob"})
+	rt.track("m3", "alice", "", []string{"bob"})
+	rt.track("m4", "alice", "", []string{"bob"})
+	if len(rt.entries) != 2 {
+		t.Fatalf("expected tracker capped at 2 entries, got %d", len(rt.entries))
+	}
+	if _, ok := rt.entries["m2"]; ok {
+		t.Error("expected oldest entry to be evicted")
+	}
+}
@@ -0,0 +1,270 @@
+// This file implements room roles and the permission model that decides what
+// each member of a room may do.
+//
+// Every member of a room has one of three roles:
+//
+//	owner   exactly one per room: its creator, until ownership is
+//	        transferred or the owner leaves
+//	admin   appointed by the owner; helps run the room
+//	member  everyone else
+//
+// What a role allows is a fixed table (rolePermissions), which two of the
+// room's settings adjust: posting can be limited to admins, and editing the
+// room's info can be opened up to every member (see roominfo.go). On top of
+// that, nobody may act on a member whose role is as high as or higher than
+// their own, so an admin can remove members but not other admins or the
+// owner.
+//
+// A room always has an owner while it has members: if the owner leaves or is
+// removed, the admin who sorts first takes over, or the member who sorts first
+// if there are no admins.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Named string types for closed sets of values (Role, permission)
+//   - A lookup table (map of slices) instead of nested if/else rules
+//   - slices.Contains and slices.Min from the standard library
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// Role is a member's standing in a room.
+//
+// LEARNING POINT — Named String Types:
+// "type Role string" creates a distinct type whose underlying type is string.
+// Role values still marshal to JSON and print as plain strings, but the
+// compiler won't let an arbitrary string be passed where a Role is expected
+// without an explicit conversion, and Role can have methods of its own.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// rank orders roles from most to least powerful. Non-members rank 0.
+func (r Role) rank() int {
+	switch r {
+	case RoleOwner:
+		return 3
+	case RoleAdmin:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// withArticle returns the role as it reads in a sentence, e.g. "an admin".
+func (r Role) withArticle() string {
+	switch r {
+	case RoleOwner:
+		return "the owner"
+	case RoleAdmin:
+		return "an admin"
+	}
+	return "a " + string(r)
+}
+
+// permission is something a member may be allowed to do in a room. Its value
+// completes the sentence "you are not allowed to ...".
+type permission string
+
+const (
+	permPost         permission = "post in the room"
+	permInvite       permission = "invite members"
+	permKick         permission = "remove members"
+	permEditInfo     permission = "change the room's name, topic or description"
+	permEditSettings permission = "change the room's settings"
+	permSetRoles     permission = "promote or demote members"
+	permDelete       permission = "delete the room"
+)
+
+// rolePermissions lists what each role may do. Plain members may only talk
+// and leave.
+var rolePermissions = map[Role][]permission{
+	RoleOwner:  {permPost, permInvite, permKick, permEditInfo, permEditSettings, permSetRoles, permDelete},
+	RoleAdmin:  {permPost, permInvite, permKick, permEditInfo, permEditSettings},
+	RoleMember: {permPost},
+}
+
+// can reports whether the role allows p.
+func (r Role) can(p permission) bool {
+	return slices.Contains(rolePermissions[r], p)
+}
+
+// role returns user's role in the room, or "" if they are not a member. Only
+// owners and admins are stored in Roles; any other member is a RoleMember.
+func (r *Room) role(user string) Role {
+	if !r.Members[user] {
+		return ""
+	}
+	if role, ok := r.Roles[user]; ok {
+		return role
+	}
+	return RoleMember
+}
+
+// owner returns the room's owner.
+func (r *Room) owner() string {
+	for m, role := range r.Roles {
+		if role == RoleOwner {
+			return m
+		}
+	}
+	return ""
+}
+
+// admins returns the room's admins, not including the owner, in no
+// particular order.
+func (r *Room) admins() []string {
+	var admins []string
+	for m, role := range r.Roles {
+		if role == RoleAdmin {
+			admins = append(admins, m)
+		}
+	}
+	return admins
+}
+
+// allows reports whether role may do p in this room: what rolePermissions
+// says, narrowed or widened by the room's settings.
+func (r *Room) allows(role Role, p permission) bool {
+	switch {
+	case p == permPost && (r.Type == RoomTypeChannel || r.Settings.WhoCanPost == AudienceAdmins):
+		return role.rank() >= RoleAdmin.rank()
+	case p == permEditInfo && r.Settings.WhoCanEditInfo == AudienceMembers:
+		return true
+	}
+	return role.can(p)
+}
+
+// checkPermission returns an error message string if user may not do p in
+// room, or an empty string if they may.
+func checkPermission(room *Room, user string, p permission) string {
+	role := room.role(user)
+	if role == "" {
+		return fmt.Sprintf("you are not a member of room %q", room.ID)
+	}
+	if !room.allows(role, p) {
+		return fmt.Sprintf("room %q: you are not allowed to %s", room.ID, p)
+	}
+	return ""
+}
+
+// checkRoomPermission is checkPermission for a room by ID.
+func (h *Hub) checkRoomPermission(roomID, user string, p permission) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room, exists := h.rooms[roomID]
+	if !exists {
+		return fmt.Sprintf("room %q does not exist", roomID)
+	}
+	return checkPermission(room, user, p)
+}
+
+// checkOutranks returns an error message string unless user's role in room
+// is higher than target's.
+func checkOutranks(room *Room, user, target string) string {
+	if room.role(user).rank() <= room.role(target).rank() {
+		return fmt.Sprintf("room %q: %s is %s; you can only act on members below you", room.ID, target, room.role(target).withArticle())
+	}
+	return ""
+}
+
+// succession records that a room's owner left and someone was appointed in
+// their place, and who should be told (see roleNoticeList).
+type succession struct {
+	owner  string
+	notify []string
+}
+
+// appointSuccessorLocked gives the room a new owner if it has members but no
+// owner: the first admin by name, or failing that (in a group only) the
+// first member. A channel's followers are never promoted, since that would
+// let one of them post to all the rest; checkHandover keeps a channel's
+// owner from leaving with no admin to take over. It returns the new owner,
+// or "" if none was appointed. The caller must hold h.mu.
+func (h *Hub) appointSuccessorLocked(room *Room) string {
+	if len(room.Members) == 0 || room.owner() != "" {
+		return ""
+	}
+	candidates := room.admins()
+	if len(candidates) == 0 && room.Type != RoomTypeChannel {
+		candidates = memberList(room)
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	successor := slices.Min(candidates)
+	room.Roles[successor] = RoleOwner
+	slog.Info("room owner changed", "room", room.ID, "user", successor)
+	h.events.publish(Event{Type: "role_changed", Room: room.ID, User: successor, Detail: string(RoleOwner)})
+	return successor
+}
+
+// setRole makes member an admin or a plain member of a room on behalf of
+// requester, who must be allowed to change roles and outrank member. It
+// returns who to tell (see roleNoticeList), or an error message string on
+// failure. Ownership is handed over with transferOwnership.
+func (h *Hub) setRole(roomName, requester, member string, role Role) ([]string, string) {
+	if role != RoleAdmin && role != RoleMember {
+		return nil, fmt.Sprintf("cannot make anyone %q", role)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if errMsg := checkPermission(room, requester, permSetRoles); errMsg != "" {
+		return nil, errMsg
+	}
+	if !room.Members[member] {
+		return nil, fmt.Sprintf("%s is not a member of room %q", member, roomName)
+	}
+	if errMsg := checkOutranks(room, requester, member); errMsg != "" {
+		return nil, errMsg
+	}
+	if room.role(member) == role {
+		return nil, fmt.Sprintf("%s is already %s of room %q", member, role.withArticle(), roomName)
+	}
+	if role == RoleMember {
+		delete(room.Roles, member)
+	} else {
+		room.Roles[member] = role
+	}
+	slog.Info("role changed", "room", roomName, "user", member, "role", role, "by", requester)
+	h.events.publish(Event{Type: "role_changed", Room: roomName, User: member, By: requester, Detail: string(role)})
+	return room.roleNoticeList(member), ""
+}
+
+// transferOwnership makes member the owner of a room on behalf of requester,
+// who must be its owner and becomes an admin. It returns who to tell, or an
+// error message string on failure.
+func (h *Hub) transferOwnership(roomName, requester, member string) ([]string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomName]
+	if !exists {
+		return nil, fmt.Sprintf("room %q does not exist", roomName)
+	}
+	if room.role(requester) != RoleOwner {
+		return nil, fmt.Sprintf("only the owner of room %q can transfer it", roomName)
+	}
+	if !room.Members[member] {
+		return nil, fmt.Sprintf("%s is not a member of room %q", member, roomName)
+	}
+	if member == requester {
+		return nil, fmt.Sprintf("you already own room %q", roomName)
+	}
+	room.Roles[requester] = RoleAdmin
+	room.Roles[member] = RoleOwner
+	slog.Info("room owner changed", "room", roomName, "user", member, "by", requester)
+	h.events.publish(Event{Type: "role_changed", Room: roomName, User: member, By: requester, Detail: string(RoleOwner)})
+	return room.roleNoticeList(member), ""
+}
@@ -0,0 +1,179 @@
+// This file contains tests for room roles and permissions (defined in
+// roles.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Table-driven tests over a permission matrix
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// newRoleTestHub returns a hub with a room "general" owned by alice, with bob
+// as an admin and carol and dave as plain members.
+func newRoleTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := NewHub()
+	h.createRoom("general", "alice")
+	for _, m := range []string{"bob", "carol", "dave"} {
+		if errMsg := h.addToRoom("general", "alice", m); errMsg != "" {
+			t.Fatalf("add %s: %s", m, errMsg)
+		}
+	}
+	if _, errMsg := h.setRole("general", "alice", "bob", RoleAdmin); errMsg != "" {
+		t.Fatalf("promote bob: %s", errMsg)
+	}
+	return h
+}
+
+// TestRolePermissions checks who may do what to whom.
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		name string
+		do   func(h *Hub) string
+		ok   bool
+	}{
+		{"admin invites", func(h *Hub) string { return h.addToRoom("general", "bob", "erin") }, true},
+		{"member invites", func(h *Hub) string { return h.addToRoom("general", "carol", "erin") }, false},
+		{"admin kicks member", func(h *Hub) string { _, _, e := h.kickFromRoom("general", "bob", "carol"); return e }, true},
+		{"admin kicks owner", func(h *Hub) string { _, _, e := h.kickFromRoom("general", "bob", "alice"); return e }, false},
+		{"member kicks member", func(h *Hub) string { _, _, e := h.kickFromRoom("general", "carol", "dave"); return e }, false},
+		{"owner kicks admin", func(h *Hub) string { _, _, e := h.kickFromRoom("general", "alice", "bob"); return e }, true},
+		{"admin promotes", func(h *Hub) string { _, e := h.setRole("general", "bob", "carol", RoleAdmin); return e }, false},
+		{"owner demotes admin", func(h *Hub) string { _, e := h.setRole("general", "alice", "bob", RoleMember); return e }, true},
+		{"owner promotes to owner", func(h *Hub) string { _, e := h.setRole("general", "alice", "carol", RoleOwner); return e }, false},
+		{"admin deletes", func(h *Hub) string { _, e := h.deleteOwnedRoom("general", "bob"); return e }, false},
+		{"admin transfers", func(h *Hub) string { _, e := h.transferOwnership("general", "bob", "carol"); return e }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errMsg := tt.do(newRoleTestHub(t))
+			if tt.ok && errMsg != "" {
+				t.Errorf("expected success, got %q", errMsg)
+			}
+			if !tt.ok && errMsg == "" {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestTransferOwnership verifies that the old owner becomes an admin.
+func TestTransferOwnership(t *testing.T) {
+	h := newRoleTestHub(t)
+	if _, errMsg := h.transferOwnership("general", "alice", "carol"); errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	room := h.rooms["general"]
+	if room.owner() != "carol" || room.role("alice") != RoleAdmin {
+		t.Errorf("expected carol to own the room and alice to be an admin, got roles %v", room.Roles)
+	}
+}
+
+// TestOwnerSuccession verifies that an admin takes over from a departing
+// owner, and a plain member when there are no admins left.
+func TestOwnerSuccession(t *testing.T) {
+	h := newRoleTestHub(t)
+	_, succ, _ := h.leaveRoom("general", "alice")
+	if owner := h.rooms["general"].owner(); owner != "bob" {
+		t.Fatalf("expected admin bob to take over, got %q", owner)
+	}
+	if succ == nil || succ.owner != "bob" || len(succ.notify) != 3 {
+		t.Errorf("expected bob's succession to be announced to the 3 members left, got %+v", succ)
+	}
+	h.leaveRoom("general", "bob")
+	if owner := h.rooms["general"].owner(); owner != "carol" {
+		t.Errorf("expected carol to take over, got %q", owner)
+	}
+	if _, succ, _ := h.leaveRoom("general", "dave"); succ != nil {
+		t.Errorf("expected no succession when a plain member leaves, got %+v", succ)
+	}
+}
+
+// TestSuccessionViaWebSocket verifies that when the owner leaves, the members
+// who remain are told who the new owner is.
+func TestSuccessionViaWebSocket(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	s.hub.createRoom("general", "alice")
+	for _, m := range []string{"bob", "carol"} {
+		if errMsg := s.hub.addToRoom("general", "alice", m); errMsg != "" {
+			t.Fatalf("add %s: %s", m, errMsg)
+		}
+	}
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conns := map[string]*websocket.Conn{}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, user), nil)
+		if err != nil {
+			t.Fatalf("%s failed to dial: %v", user, err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+		conns[user] = c
+	}
+
+	data, _ := json.Marshal(Message{Type: "leave_room", Room: "general"})
+	conns["alice"].Write(ctx, websocket.MessageText, data)
+	for _, name := range []string{"bob", "carol"} {
+		got := readUntil(t, ctx, conns[name], "role_changed")
+		if got.Recipient != "bob" || !strings.Contains(got.Content, "the owner") {
+			t.Errorf("unexpected notice for %s: %+v", name, got)
+		}
+	}
+}
+
+// TestPromoteViaWebSocket verifies that every member is told about a role
+// change.
+func TestPromoteViaWebSocket(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+	send(alice, Message{Type: "create_room", Content: "general"})
+	send(alice, Message{Type: "invite", Room: "general", Recipient: "bob"})
+	readUntil(t, ctx, bob, "invited")
+	send(bob, Message{Type: "accept_invite", Room: "general"})
+	readUntil(t, ctx, alice, "member_joined")
+	send(alice, Message{Type: "promote", Room: "general", Recipient: "bob"})
+	for name, c := range map[string]*websocket.Conn{"alice": alice, "bob": bob} {
+		got := readUntil(t, ctx, c, "role_changed")
+		if got.Recipient != "bob" || !strings.Contains(got.Content, "an admin") {
+			t.Errorf("unexpected notice for %s: %+v", name, got)
+		}
+	}
+	if role := s.hub.rooms["general"].role("bob"); role != RoleAdmin {
+		t.Errorf("expected bob to be an admin, got %q", role)
+	}
+}
@@ -0,0 +1,300 @@
+// This file implements room metadata: the display name, topic and
+// description people see, who created the room and when, and the settings
+// that decide who may post, who may edit that info and how many members the
+// room may have.
+//
+// Anyone who can see a room asks for all of it with "room_info". Members
+// allowed to change it send "update_room" with just the fields they want to
+// change, and every member is sent a "room_updated" notice with the result.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Pointer fields to tell "not sent" apart from "sent as the zero value"
+//   - unicode/utf8 for counting characters rather than bytes
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxRoomNameLength, maxTopicLength and maxDescriptionLength bound the
+	// room's info, in characters.
+	maxRoomNameLength    = 64
+	maxTopicLength       = 256
+	maxDescriptionLength = 2048
+)
+
+// Audience says which members a room setting lets do something.
+type Audience string
+
+const (
+	AudienceMembers Audience = "members"
+	AudienceAdmins  Audience = "admins"
+)
+
+// valid reports whether a is a known audience.
+func (a Audience) valid() bool {
+	return a == AudienceMembers || a == AudienceAdmins
+}
+
+// RoomSettings are the rules a room's owner and admins can change. "admins"
+// includes the owner. MaxMembers of 0 means no limit.
+type RoomSettings struct {
+	WhoCanPost     Audience `json:"who_can_post"`
+	WhoCanEditInfo Audience `json:"who_can_edit_info"`
+	MaxMembers     int      `json:"max_members"`
+}
+
+// defaultRoomSettings are the settings a new room starts with: everyone may
+// post, only admins may edit the room's info, and there is no size limit.
+func defaultRoomSettings() RoomSettings {
+	return RoomSettings{WhoCanPost: AudienceMembers, WhoCanEditInfo: AudienceAdmins}
+}
+
+// RoomMember is one entry in RoomDetails.Members.
+type RoomMember struct {
+	User string `json:"user"`
+	Role Role   `json:"role"`
+}
+
+// RoomDetails describes a room in "room_info" replies and "room_updated"
+// notices. Members is only filled in for members of the room; anyone else
+// looking at a public room sees just how many there are. In a channel it
+// lists only the owner and admins: subscribers are counted, not named.
+type RoomDetails struct {
+	ID          string       `json:"id"`
+	Type        RoomType     `json:"type"`
+	Name        string       `json:"name"`
+	Topic       string       `json:"topic,omitempty"`
+	Description string       `json:"description,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CreatedBy   string       `json:"created_by"`
+	Visibility  Visibility   `json:"visibility"`
+	Settings    RoomSettings `json:"settings"`
+	MemberCount int          `json:"member_count"`
+	Members     []RoomMember `json:"members,omitempty"`
+}
+
+// RoomUpdate is the change an "update_room" message asks for. Fields left
+// out (nil) are not changed.
+//
+// LEARNING POINT — Pointer Fields for Partial Updates:
+// With a plain string field, a client that leaves "topic" out and one that
+// sends "topic": "" to clear it decode to the same thing. A *string is nil
+// when the key is absent and points at "" when it was sent empty, so the
+// server can tell "leave it alone" from "clear it".
+type RoomUpdate struct {
+	Name           *string   `json:"name,omitempty"`
+	Topic          *string   `json:"topic,omitempty"`
+	Description    *string   `json:"description,omitempty"`
+	WhoCanPost     *Audience `json:"who_can_post,omitempty"`
+	WhoCanEditInfo *Audience `json:"who_can_edit_info,omitempty"`
+	MaxMembers     *int      `json:"max_members,omitempty"`
+}
+
+// changesInfo reports whether u touches the name, topic or description.
+func (u RoomUpdate) changesInfo() bool {
+	return u.Name != nil || u.Topic != nil || u.Description != nil
+}
+
+// changesSettings reports whether u touches any of the settings.
+func (u RoomUpdate) changesSettings() bool {
+	return u.WhoCanPost != nil || u.WhoCanEditInfo != nil || u.MaxMembers != nil
+}
+
+// validate returns an error message string if u can't be applied to room.
+//
+// LEARNING POINT — Characters vs Bytes:
+// len(s) counts bytes, so a topic in Greek or made of emoji would hit the
+// limit at a half or a quarter of the characters an English one gets.
+// utf8.RuneCountInString counts characters (runes) instead.
+func (u RoomUpdate) validate(room *Room) string {
+	tooLong := func(field string, s *string, limit int) string {
+		if s != nil && utf8.RuneCountInString(*s) > limit {
+			return fmt.Sprintf("the %s can be at most %d characters", field, limit)
+		}
+		return ""
+	}
+	switch {
+	case !u.changesInfo() && !u.changesSettings():
+		return "update_room needs at least one field to change"
+	case u.Name != nil && strings.TrimSpace(*u.Name) == "":
+		return "the room's name cannot be empty"
+	case u.WhoCanPost != nil && room.Type == RoomTypeChannel:
+		return fmt.Sprintf("only admins can post in channel %q", room.ID)
+	case u.WhoCanEditInfo != nil && *u.WhoCanEditInfo == AudienceMembers && room.Type == RoomTypeChannel:
+		return fmt.Sprintf("only admins can edit the info of channel %q", room.ID)
+	case u.WhoCanPost != nil && !u.WhoCanPost.valid(),
+		u.WhoCanEditInfo != nil && !u.WhoCanEditInfo.valid():
+		return fmt.Sprintf("who_can_post and who_can_edit_info must be %q or %q", AudienceMembers, AudienceAdmins)
+	case u.MaxMembers != nil && *u.MaxMembers < 0:
+		return "max_members must not be negative"
+	case u.MaxMembers != nil && *u.MaxMembers > 0 && *u.MaxMembers < len(room.Members):
+		return fmt.Sprintf("room %q already has %d members", room.ID, len(room.Members))
+	}
+	return cmp.Or(
+		tooLong("name", u.Name, maxRoomNameLength),
+		tooLong("topic", u.Topic, maxTopicLength),
+		tooLong("description", u.Description, maxDescriptionLength),
+	)
+}
+
+// describe says what u changes, to complete the sentence "alice ...".
+func (u RoomUpdate) describe() string {
+	var changes []string
+	if u.Name != nil {
+		changes = append(changes, fmt.Sprintf("renamed the room to %q", strings.TrimSpace(*u.Name)))
+	}
+	if u.Topic != nil {
+		if *u.Topic == "" {
+			changes = append(changes, "cleared the topic")
+		} else {
+			changes = append(changes, fmt.Sprintf("set the topic to %q", *u.Topic))
+		}
+	}
+	if u.Description != nil {
+		changes = append(changes, "changed the description")
+	}
+	if u.WhoCanPost != nil {
+		changes = append(changes, fmt.Sprintf("let %s post", *u.WhoCanPost))
+	}
+	if u.WhoCanEditInfo != nil {
+		changes = append(changes, fmt.Sprintf("let %s edit the room's info", *u.WhoCanEditInfo))
+	}
+	if u.MaxMembers != nil {
+		if *u.MaxMembers == 0 {
+			changes = append(changes, "removed the member limit")
+		} else {
+			changes = append(changes, fmt.Sprintf("limited the room to %d members", *u.MaxMembers))
+		}
+	}
+	return strings.Join(changes, ", ")
+}
+
+// fields names the fields u changes, for the event feed.
+func (u RoomUpdate) fields() string {
+	var fields []string
+	for name, set := range map[string]bool{
+		"name":              u.Name != nil,
+		"topic":             u.Topic != nil,
+		"description":       u.Description != nil,
+		"who_can_post":      u.WhoCanPost != nil,
+		"who_can_edit_info": u.WhoCanEditInfo != nil,
+		"max_members":       u.MaxMembers != nil,
+	} {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return strings.Join(fields, ",")
+}
+
+// checkCapacity returns an error message string if room has no space for
+// another member.
+func checkCapacity(room *Room) string {
+	if limit := room.Settings.MaxMembers; limit > 0 && len(room.Members) >= limit {
+		return fmt.Sprintf("room %q is full (%d members)", room.ID, limit)
+	}
+	return ""
+}
+
+// details describes the room for someone who may see it. withMembers adds
+// the member list (for a channel, its owner and admins), sorted by role and
+// then by name.
+func (r *Room) details(withMembers bool) RoomDetails {
+	d := RoomDetails{
+		ID:          r.ID,
+		Type:        r.Type,
+		Name:        r.Name,
+		Topic:       r.Topic,
+		Description: r.Description,
+		CreatedAt:   r.CreatedAt,
+		CreatedBy:   r.CreatedBy,
+		Visibility:  r.Visibility,
+		Settings:    r.Settings,
+		MemberCount: len(r.Members),
+	}
+	if !withMembers {
+		return d
+	}
+	visible := memberList(r)
+	if r.Type == RoomTypeChannel {
+		visible = r.staff()
+	}
+	for _, m := range visible {
+		d.Members = append(d.Members, RoomMember{User: m, Role: r.role(m)})
+	}
+	slices.SortFunc(d.Members, func(a, b RoomMember) int {
+		return cmp.Or(cmp.Compare(b.Role.rank(), a.Role.rank()), cmp.Compare(a.User, b.User))
+	})
+	return d
+}
+
+// roomDetails describes a room for requester, who must be a member or the
+// room must be public. Returns an error message string otherwise; as with
+// joinPublicRoom, private rooms look the same as rooms that don't exist.
+func (h *Hub) roomDetails(roomID, requester string) (RoomDetails, string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room, exists := h.rooms[roomID]
+	if !exists || (room.Visibility != VisibilityPublic && !room.Members[requester]) {
+		return RoomDetails{}, fmt.Sprintf("room %q does not exist", roomID)
+	}
+	return room.details(room.Members[requester]), ""
+}
+
+// updateRoom applies upd to a room on behalf of requester, who needs
+// permEditInfo to change the name, topic or description and permEditSettings
+// to change the settings. It returns the room as it now is and its members,
+// so they can be told, or an error message string on failure. Nothing is
+// changed unless the whole update is allowed and valid.
+func (h *Hub) updateRoom(roomID, requester string, upd RoomUpdate) (RoomDetails, []string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, exists := h.rooms[roomID]
+	if !exists {
+		return RoomDetails{}, nil, fmt.Sprintf("room %q does not exist", roomID)
+	}
+	if upd.changesInfo() {
+		if errMsg := checkPermission(room, requester, permEditInfo); errMsg != "" {
+			return RoomDetails{}, nil, errMsg
+		}
+	}
+	if upd.changesSettings() {
+		if errMsg := checkPermission(room, requester, permEditSettings); errMsg != "" {
+			return RoomDetails{}, nil, errMsg
+		}
+	}
+	if errMsg := upd.validate(room); errMsg != "" {
+		return RoomDetails{}, nil, errMsg
+	}
+
+	if upd.Name != nil {
+		room.Name = strings.TrimSpace(*upd.Name)
+	}
+	if upd.Topic != nil {
+		room.Topic = *upd.Topic
+	}
+	if upd.Description != nil {
+		room.Description = *upd.Description
+	}
+	if upd.WhoCanPost != nil {
+		room.Settings.WhoCanPost = *upd.WhoCanPost
+	}
+	if upd.WhoCanEditInfo != nil {
+		room.Settings.WhoCanEditInfo = *upd.WhoCanEditInfo
+	}
+	if upd.MaxMembers != nil {
+		room.Settings.MaxMembers = *upd.MaxMembers
+	}
+	slog.Info("room updated", "room", roomID, "user", requester, "fields", upd.fields())
+	h.events.publish(Event{Type: "room_updated", Room: roomID, By: requester, Detail: upd.fields()})
+	return room.details(true), memberList(room), ""
+}
@@ -0,0 +1,187 @@
+// This file contains tests for room metadata and settings (defined in
+// roominfo.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - A small generic helper (ptr) for building structs of pointer fields
+//   - Checking that a rejected update changes nothing at all
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// ptr returns a pointer to v, for filling in RoomUpdate.
+//
+// LEARNING POINT — Generic Helpers:
+// Go can't take the address of a literal (&"hello" doesn't compile), so
+// setting a *string field needs a variable. A one-line generic function
+// does that for any type.
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// TestUpdateRoomPermissions checks who may change a room's info and
+// settings, and that the room's ID never changes.
+func TestUpdateRoomPermissions(t *testing.T) {
+	h := newRoleTestHub(t)
+
+	if _, _, errMsg := h.updateRoom("general", "carol", RoomUpdate{Topic: ptr("hi")}); errMsg == "" {
+		t.Error("expected a member not to be able to set the topic")
+	}
+	details, members, errMsg := h.updateRoom("general", "bob", RoomUpdate{Name: ptr("  General Chat "), Topic: ptr("hi")})
+	if errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if details.ID != "general" || details.Name != "General Chat" || details.Topic != "hi" || len(members) != 4 {
+		t.Errorf("unexpected result %+v, members %v", details, members)
+	}
+	if _, exists := h.rooms["general"]; !exists {
+		t.Error("expected the room to keep its ID after being renamed")
+	}
+
+	// Opening up info editing lets carol set the topic, but she still may
+	// not touch the settings.
+	if _, _, errMsg := h.updateRoom("general", "bob", RoomUpdate{WhoCanEditInfo: ptr(AudienceMembers)}); errMsg != "" {
+		t.Fatalf("unexpected error: %s", errMsg)
+	}
+	if _, _, errMsg := h.updateRoom("general", "carol", RoomUpdate{Topic: ptr("")}); errMsg != "" {
+		t.Errorf("expected carol to be able to clear the topic: %s", errMsg)
+	}
+	// A channel's followers never get to edit its info.
+	ch := newChannelTestHub(t)
+	if _, _, errMsg := ch.updateRoom("news", "alice", RoomUpdate{WhoCanEditInfo: ptr(AudienceMembers)}); errMsg == "" {
+		t.Error("expected a channel not to allow opening up info editing")
+	}
+	if _, _, errMsg := h.updateRoom("general", "carol", RoomUpdate{Topic: ptr("x"), MaxMembers: ptr(10)}); errMsg == "" {
+		t.Error("expected a member not to be able to change settings")
+	}
+	if room := h.rooms["general"]; room.Topic != "" || room.Settings.MaxMembers != 0 {
+		t.Errorf("expected a rejected update to change nothing, got %+v", room)
+	}
+}
+
+// TestUpdateRoomValidation checks the update_room input rules.
+func TestUpdateRoomValidation(t *testing.T) {
+	h := newRoleTestHub(t)
+	tests := []struct {
+		name string
+		upd  RoomUpdate
+	}{
+		{"empty update", RoomUpdate{}},
+		{"blank name", RoomUpdate{Name: ptr("   ")}},
+		{"long topic", RoomUpdate{Topic: ptr(strings.Repeat("é", maxTopicLength+1))}},
+		{"unknown audience", RoomUpdate{WhoCanPost: ptr(Audience("everyone"))}},
+		{"negative limit", RoomUpdate{MaxMembers: ptr(-1)}},
+		{"limit below members", RoomUpdate{MaxMembers: ptr(3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, errMsg := h.updateRoom("general", "alice", tt.upd); errMsg == "" {
+				t.Error("expected an error")
+			}
+		})
+	}
+	if _, _, errMsg := h.updateRoom("general", "alice", RoomUpdate{Topic: ptr(strings.Repeat("é", maxTopicLength))}); errMsg != "" {
+		t.Errorf("expected a topic of exactly %d characters to be accepted: %s", maxTopicLength, errMsg)
+	}
+}
+
+// TestRoomCapacity checks that a full room turns new members away without
+// using up their invitation.
+func TestRoomCapacity(t *testing.T) {
+	h := newRoleTestHub(t)
+	h.updateRoom("general", "alice", RoomUpdate{MaxMembers: ptr(4)})
+	if _, errMsg := h.inviteToRoom("general", "alice", "erin"); errMsg != "" {
+		t.Fatalf("unexpected error inviting: %s", errMsg)
+	}
+	if _, _, errMsg := h.acceptInvite("general", "erin"); !strings.Contains(errMsg, "full") {
+		t.Fatalf("expected the room to be full, got %q", errMsg)
+	}
+	h.leaveRoom("general", "dave")
+	if _, _, errMsg := h.acceptInvite("general", "erin"); errMsg != "" {
+		t.Errorf("expected the invitation to still be good once there was space: %s", errMsg)
+	}
+
+	// Two people accepting at once for the last place: one gets in, and the
+	// other keeps their invitation.
+	h.leaveRoom("general", "carol")
+	h.inviteToRoom("general", "alice", "frank")
+	h.inviteToRoom("general", "alice", "grace")
+	var wg sync.WaitGroup
+	for _, user := range []string{"frank", "grace"} {
+		wg.Go(func() { h.acceptInvite("general", user) })
+	}
+	wg.Wait()
+	if n := len(h.rooms["general"].Members); n != 4 {
+		t.Errorf("expected the room to be exactly full, got %d members", n)
+	}
+	if pending := len(h.invitations.pendingFor("frank")) + len(h.invitations.pendingFor("grace")); pending != 1 {
+		t.Errorf("expected the one left out to keep their invitation, got %d pending", pending)
+	}
+}
+
+// TestRoomInfoViaWebSocket updates a room over WebSocket, checks that every
+// member hears about it, that posting can be limited to admins, and that a
+// private room's info is hidden from outsiders.
+func TestRoomInfoViaWebSocket(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dial := func(user string) *websocket.Conn {
+		c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, user), nil)
+		if err != nil {
+			t.Fatalf("%s failed to dial: %v", user, err)
+		}
+		t.Cleanup(func() { c.Close(websocket.StatusNormalClosure, "") })
+		return c
+	}
+	alice, bob, carol := dial("alice"), dial("bob"), dial("carol")
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+	send(alice, Message{Type: "create_room", Content: "general"})
+	send(alice, Message{Type: "invite", Room: "general", Recipient: "bob"})
+	readUntil(t, ctx, bob, "invited")
+	send(bob, Message{Type: "accept_invite", Room: "general"})
+	readUntil(t, ctx, alice, "member_joined")
+
+	send(alice, Message{Type: "update_room", Room: "general", Update: &RoomUpdate{
+		Topic:      ptr("release planning"),
+		WhoCanPost: ptr(AudienceAdmins),
+	}})
+	for name, c := range map[string]*websocket.Conn{"alice": alice, "bob": bob} {
+		got := readUntil(t, ctx, c, "room_updated")
+		if got.Details == nil || got.Details.Topic != "release planning" || !strings.Contains(got.Content, "let admins post") {
+			t.Errorf("unexpected notice for %s: %+v", name, got)
+		}
+	}
+
+	send(bob, Message{Type: "room_msg", Room: "general", Content: "hello"})
+	if got := readUntil(t, ctx, bob, "error"); !strings.Contains(got.Content, "not allowed to post") {
+		t.Errorf("unexpected error %+v", got)
+	}
+
+	send(bob, Message{Type: "room_info", Room: "general"})
+	got := readUntil(t, ctx, bob, "room_info")
+	if d := got.Details; d == nil || d.CreatedBy != "alice" || d.MemberCount != 2 || len(d.Members) != 2 || d.Members[0].Role != RoleOwner {
+		t.Errorf("unexpected room info %+v", got.Details)
+	}
+	send(carol, Message{Type: "room_info", Room: "general"})
+	if got := readUntil(t, ctx, carol, "error"); !strings.Contains(got.Content, "does not exist") {
+		t.Errorf("expected a private room to be hidden, got %+v", got)
+	}
+}
@@ -0,0 +1,150 @@
+// This file implements graceful shutdown. When the server is asked to stop
+// (SIGINT from Ctrl-C, or SIGTERM from a process manager), it:
+//
+//  1. stops accepting new WebSocket connections (new upgrades get a 503 with
+//     a Retry-After header),
+//  2. tells every connected client "server_shutdown", with a hint for how
+//     long to wait before reconnecting,
+//  3. lets each connection's writer finish everything already queued, then
+//     closes the socket with StatusGoingAway,
+//  4. waits for every connection's read loop to exit, so no handler is still
+//     writing to the message store, and
+//  5. closes the message store.
+//
+// All of this happens within a deadline. Connections that have not finished
+// by then are cut off.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Using a context deadline to bound a multi-step operation
+//   - sync.WaitGroup to wait for a batch of goroutines
+//   - Closing a channel to broadcast "done" to any number of waiters
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// shutdownRetryAfter is the reconnect delay suggested to clients when
+	// the server shuts down; a restart usually takes about this long.
+	shutdownRetryAfter = 5 * time.Second
+
+	// defaultShutdownTimeout bounds how long a graceful shutdown may take.
+	defaultShutdownTimeout = 15 * time.Second
+)
+
+// Shutdown gracefully disconnects every client and closes the message store.
+// It returns ctx's error if the deadline passed before every connection had
+// closed; the store is closed either way.
+//
+// Shutdown does not stop the HTTP listener; main does that with
+// http.Server.Shutdown afterwards. Until then, upgrade requests are answered
+// with 503 (see wsHandler).
+func (s *Server) Shutdown(ctx context.Context) error {
+	notice := Message{
+		Type:       "server_shutdown",
+		Sender:     "server",
+		Content:    fmt.Sprintf("server is shutting down; reconnect in %v", shutdownRetryAfter),
+		RetryAfter: int(shutdownRetryAfter.Seconds()),
+	}
+	err := s.hub.shutdown(ctx, notice)
+	if cerr := s.store.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// shutdown stops the hub from accepting connections, sends notice to every
+// connected device, closes them all, and waits until each has unregistered.
+//
+// LEARNING POINT — Closing a Channel as a Broadcast:
+// A receive from a closed channel never blocks. So closing h.drained wakes
+// up every goroutine waiting on it at once, and any that start waiting
+// later return immediately — which a single value sent on the channel could
+// not do.
+func (h *Hub) shutdown(ctx context.Context, notice Message) error {
+	h.mu.Lock()
+	h.closing = true
+	h.drained = make(chan struct{})
+	var conns []*connection
+	for id := range h.clients {
+		conns = append(conns, h.snapshot(id)...)
+	}
+	if len(h.clients) == 0 {
+		h.markDrained()
+	}
+	h.mu.Unlock()
+
+	slog.Info("shutting down", "connections", len(conns))
+	h.events.close()
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+
+	// Close the connections in parallel, so one slow client cannot use up
+	// the whole deadline before the others have even been told.
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn.goAway(ctx, data)
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-h.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// markDrained closes h.drained if it is not closed already. The caller must
+// hold h.mu.
+func (h *Hub) markDrained() {
+	select {
+	case <-h.drained:
+	default:
+		close(h.drained)
+	}
+}
+
+// accepting reports whether the hub still takes new connections.
+func (h *Hub) accepting() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return !h.closing
+}
+
+// goAway queues a final notice behind everything already waiting for this
+// connection, then a close. It returns once the writer has closed the socket,
+// or when ctx expires, in which case the socket is closed immediately.
+//
+// Unlike enqueue, goAway waits for room in the queue rather than applying the
+// slow-consumer policy: a shutdown is exactly when we want to flush what is
+// already queued, not throw it away.
+func (c *connection) goAway(ctx context.Context, notice []byte) {
+	for _, out := range []outbound{{data: notice, kind: "server_shutdown"}, {goingAway: true}} {
+		select {
+		case c.send <- out:
+		case <-c.ctx.Done():
+			return
+		case <-ctx.Done():
+			c.ws.CloseNow()
+			return
+		}
+	}
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		c.ws.CloseNow()
+	}
+}
@@ -0,0 +1,92 @@
+// This file contains tests for graceful shutdown (defined in shutdown.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - websocket.CloseStatus to inspect how a connection was closed
+//   - Running the code under test in a goroutine and collecting its result
+//     on a channel
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TestGracefulShutdown verifies that a shutdown flushes messages already
+// queued for a client, then tells it to come back later, closes it with
+// StatusGoingAway, and refuses new connections.
+func TestGracefulShutdown(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.CloseNow()
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.CloseNow()
+
+	// Wait for bob's ack so the message is known to be queued for alice
+	// before the shutdown starts.
+	data, _ := json.Marshal(Message{Recipient: "alice", Content: "last one"})
+	bob.Write(ctx, websocket.MessageText, data)
+	readUntil(t, ctx, bob, "ack")
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(ctx) }()
+
+	if got := readUntil(t, ctx, alice, ""); got.Content != "last one" {
+		t.Errorf("expected the queued message before the shutdown notice, got %+v", got)
+	}
+	notice := readUntil(t, ctx, alice, "server_shutdown")
+	if notice.RetryAfter <= 0 {
+		t.Errorf("expected a retry hint, got %+v", notice)
+	}
+	// Keep reading so the close handshake can complete.
+	for {
+		if _, _, err = alice.Read(ctx); err != nil {
+			break
+		}
+	}
+	if status := websocket.CloseStatus(err); status != websocket.StatusGoingAway {
+		t.Errorf("expected StatusGoingAway, got %v (%v)", status, err)
+	}
+	// bob has to keep reading too, or his close handshake never completes.
+	go func() {
+		for {
+			if _, _, err := bob.Read(ctx); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := <-done; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+	if conns := s.hub.connectionStats(); len(conns) != 0 {
+		t.Errorf("expected every connection to be unregistered, got %+v", conns)
+	}
+
+	_, resp, err := websocket.Dial(ctx, withToken(t, s, wsURL, "carol"), nil)
+	if err == nil {
+		t.Fatal("expected new connections to be refused during shutdown")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After, got %v", resp)
+	}
+}
@@ -0,0 +1,381 @@
+// This file defines the MessageStore, the server's durable record of every
+// direct and room message it accepts. The server appends each message to the
+// store before fanning it out, so chat history survives restarts and can be
+// audited later.
+//
+// Two implementations are provided:
+//   - MemoryStore keeps everything in a slice. It is fast and is what the
+//     tests use, but it forgets everything when the process exits.
+//   - FileStore writes an append-only log to disk (one JSON record per line)
+//     and keeps an in-memory index from conversation to log offsets, which it
+//     rebuilds by scanning the log when it is opened.
+//
+// KEY GO CONCEPTS IN THIS FILE:
+//   - Interfaces as pluggable extension points
+//   - Compile-time interface satisfaction checks (var _ I = (*T)(nil))
+//   - os.OpenFile flags for append-only files
+//   - bufio.Reader and ReadAt for reading records back
+//   - Wrapping errors with fmt.Errorf and %w
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+)
+
+// StoredMessage is a message as recorded in a MessageStore.
+//
+// Cursor is a store-wide position that increases by one for every append. It
+// lets a caller remember "how far have I read" and later ask for everything
+// after that point with Since.
+type StoredMessage struct {
+	Cursor       uint64  `json:"cursor"`
+	Conversation string  `json:"conversation"`
+	Message      Message `json:"message"`
+}
+
+// MessageStore is the persistence boundary for chat messages.
+//
+// Besides the store-wide cursor, Append assigns each message its
+// per-conversation sequence number (Message.Seq): 1 for the first message in
+// a conversation, 2 for the next, and so on. Because the store is the one
+// component that sees every message in order and survives restarts, it is
+// the natural owner of that counter.
+//
+// LEARNING POINT — Interfaces as Extension Points:
+// The server only ever talks to this interface, never to MemoryStore or
+// FileStore directly. Swapping in a different backend (SQLite, Postgres, an
+// object store) means writing one new type with these methods — no handler
+// code changes. Go interfaces are satisfied implicitly, so the new type does
+// not even need to mention MessageStore.
+type MessageStore interface {
+	// Append records msg in the given conversation and returns the stored
+	// record, including its assigned cursor and sequence number.
+	Append(conversation string, msg Message) (StoredMessage, error)
+
+	// AppendAll records several messages at once: either all of them are
+	// stored or none is. Each entry gives a Conversation and a Message; the
+	// returned records have their cursors and sequence numbers assigned.
+	AppendAll(entries []StoredMessage) ([]StoredMessage, error)
+
+	// Conversation returns every message recorded in a conversation, oldest
+	// first.
+	Conversation(conversation string) ([]StoredMessage, error)
+
+	// Since returns the messages in a conversation whose cursor is strictly
+	// greater than cursor, oldest first. Since(conv, 0) is equivalent to
+	// Conversation(conv).
+	Since(conversation string, cursor uint64) ([]StoredMessage, error)
+
+	// Ping reports whether the store can currently be used. The readiness
+	// check (see health.go) calls it.
+	Ping() error
+
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// directConversation returns the conversation key for a direct message
+// between two users. The pair is sorted so that alice->bob and bob->alice land
+// in the same conversation.
+func directConversation(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return "dm:" + a + ":" + b
+}
+
+// roomConversation returns the conversation key for a room.
+func roomConversation(room string) string {
+	return "room:" + room
+}
+
+// LEARNING POINT — Compile-Time Interface Checks:
+// These lines never run any code. Assigning a typed nil pointer to a variable
+// of the interface type forces the compiler to verify that the type has every
+// method the interface requires. If someone later changes a method signature,
+// the build fails here with a clear message instead of somewhere far away.
+var (
+	_ MessageStore = (*MemoryStore)(nil)
+	_ MessageStore = (*FileStore)(nil)
+)
+
+// MemoryStore is a MessageStore that keeps messages in memory only.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	messages []StoredMessage
+	index    map[string][]int // conversation -> positions in messages
+}
+
+// NewMemoryStore creates an empty in-memory message store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{index: make(map[string][]int)}
+}
+
+// Append records a message in memory.
+func (m *MemoryStore) Append(conversation string, msg Message) (StoredMessage, error) {
+	recs, err := m.AppendAll([]StoredMessage{{Conversation: conversation, Message: msg}})
+	if err != nil {
+		return StoredMessage{}, err
+	}
+	return recs[0], nil
+}
+
+// AppendAll records several messages in memory.
+func (m *MemoryStore) AppendAll(entries []StoredMessage) ([]StoredMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	recs := make([]StoredMessage, len(entries))
+	for i, e := range entries {
+		e.Message.Seq = uint64(len(m.index[e.Conversation])) + 1
+		e.Cursor = uint64(len(m.messages)) + 1
+		m.index[e.Conversation] = append(m.index[e.Conversation], len(m.messages))
+		m.messages = append(m.messages, e)
+		recs[i] = e
+	}
+	return recs, nil
+}
+
+// Conversation returns every message in a conversation.
+func (m *MemoryStore) Conversation(conversation string) ([]StoredMessage, error) {
+	return m.Since(conversation, 0)
+}
+
+// Since returns the messages in a conversation after the given cursor.
+func (m *MemoryStore) Since(conversation string, cursor uint64) ([]StoredMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []StoredMessage
+	for _, pos := range m.index[conversation] {
+		if m.messages[pos].Cursor > cursor {
+			out = append(out, m.messages[pos])
+		}
+	}
+	return out, nil
+}
+
+// Ping always succeeds for the in-memory store.
+func (m *MemoryStore) Ping() error {
+	return nil
+}
+
+// Close is a no-op for the in-memory store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// logEntry locates one record inside the FileStore's log file.
+type logEntry struct {
+	cursor uint64
+	offset int64
+	length int64
+}
+
+// FileStore is a MessageStore backed by an append-only log file.
+//
+// LEARNING POINT — Append-Only Logs:
+// Never rewriting existing bytes makes the file format trivially crash-safe:
+// a crash can at worst leave a half-written last line, which OpenFileStore
+// detects and discards. Reads never need to lock out writers for long
+// because old records never change. Many real databases (Kafka, the
+// write-ahead log in Postgres) are built on the same idea.
+type FileStore struct {
+	mu     sync.RWMutex
+	file   *os.File
+	size   int64
+	cursor uint64
+	index  map[string][]logEntry
+
+	// broken is set if a failed append couldn't be cleaned up (see
+	// discardTail). Appending again could then corrupt the log, so every
+	// later Append and Ping fails with it instead.
+	broken error
+}
+
+// OpenFileStore opens (or creates) the log at path and rebuilds the
+// conversation index by scanning it from the beginning.
+func OpenFileStore(path string) (*FileStore, error) {
+	// LEARNING POINT — os.OpenFile Flags:
+	// O_CREATE creates the file if it doesn't exist and O_RDWR lets us read
+	// records back. We deliberately do NOT use O_APPEND: instead we track the
+	// end of the file ourselves and use WriteAt, which lets Open truncate a
+	// torn final record left behind by a crash.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open message log: %w", err)
+	}
+	s := &FileStore{file: f, index: make(map[string][]logEntry)}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load scans the log, populating the index and the next cursor.
+func (s *FileStore) load() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A trailing record without a newline was torn by a crash
+			// mid-write. Drop it so the next append starts on a clean line.
+			if len(line) > 0 {
+				slog.Warn("discarding incomplete record at end of message log", "bytes", len(line))
+				if err := s.file.Truncate(offset); err != nil {
+					return fmt.Errorf("truncate message log: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read message log: %w", err)
+		}
+		var rec StoredMessage
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("decode message log at offset %d: %w", offset, err)
+		}
+		s.index[rec.Conversation] = append(s.index[rec.Conversation], logEntry{
+			cursor: rec.Cursor,
+			offset: offset,
+			length: int64(len(line)),
+		})
+		if rec.Cursor > s.cursor {
+			s.cursor = rec.Cursor
+		}
+		offset += int64(len(line))
+	}
+	s.size = offset
+	return nil
+}
+
+// Append writes a record to the end of the log and syncs it to disk.
+func (s *FileStore) Append(conversation string, msg Message) (StoredMessage, error) {
+	recs, err := s.AppendAll([]StoredMessage{{Conversation: conversation, Message: msg}})
+	if err != nil {
+		return StoredMessage{}, err
+	}
+	return recs[0], nil
+}
+
+// AppendAll writes several records to the end of the log with a single
+// write and a single sync, so sending one message to many people costs one
+// trip to the disk rather than one per person.
+func (s *FileStore) AppendAll(entries []StoredMessage) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken != nil {
+		return nil, s.broken
+	}
+	// Nothing is added to the index until the whole batch is on disk, so
+	// the sequence numbers of a conversation that appears more than once in
+	// it are counted here.
+	added := make(map[string]int)
+	recs := make([]StoredMessage, len(entries))
+	lengths := make([]int64, len(entries))
+	var data []byte
+	for i, e := range entries {
+		added[e.Conversation]++
+		e.Message.Seq = uint64(len(s.index[e.Conversation]) + added[e.Conversation])
+		e.Cursor = s.cursor + uint64(i) + 1
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, fmt.Errorf("encode message: %w", err)
+		}
+		line = append(line, '\n')
+		data = append(data, line...)
+		recs[i], lengths[i] = e, int64(len(line))
+	}
+	if _, err := s.file.WriteAt(data, s.size); err != nil {
+		return nil, s.discardTail(fmt.Errorf("write message log: %w", err))
+	}
+	// Sync forces the OS to flush the write to stable storage. Without it, a
+	// power failure could lose messages the server already acknowledged.
+	if err := s.file.Sync(); err != nil {
+		return nil, s.discardTail(fmt.Errorf("sync message log: %w", err))
+	}
+	for i, rec := range recs {
+		s.index[rec.Conversation] = append(s.index[rec.Conversation], logEntry{
+			cursor: rec.Cursor,
+			offset: s.size,
+			length: lengths[i],
+		})
+		s.size += lengths[i]
+		s.cursor = rec.Cursor
+	}
+	return recs, nil
+}
+
+// discardTail cuts the log back to the end of the last complete record after
+// a failed Append, and returns cause. Whatever part of the record reached
+// the file would otherwise stay there: the next, shorter record would
+// overwrite only its start, leaving a newline-terminated fragment that load
+// can't decode, and the server could never open the log again. If even the
+// truncation fails, the store is marked broken. The caller must hold s.mu.
+func (s *FileStore) discardTail(cause error) error {
+	if err := s.file.Truncate(s.size); err != nil {
+		s.broken = fmt.Errorf("message log unusable after a failed write: %w", errors.Join(cause, err))
+		slog.Error("truncate message log", "err", err)
+		return s.broken
+	}
+	return cause
+}
+
+// Conversation returns every message in a conversation.
+func (s *FileStore) Conversation(conversation string) ([]StoredMessage, error) {
+	return s.Since(conversation, 0)
+}
+
+// Since reads back the messages in a conversation after the given cursor.
+//
+// LEARNING POINT — Binary Search with sort.Search:
+// Index entries are appended in cursor order, so they are already sorted.
+// sort.Search finds the first entry with a cursor above the requested one in
+// O(log n) instead of scanning the whole conversation.
+func (s *FileStore) Since(conversation string, cursor uint64) ([]StoredMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := s.index[conversation]
+	start := sort.Search(len(entries), func(i int) bool {
+		return entries[i].cursor > cursor
+	})
+	out := make([]StoredMessage, 0, len(entries)-start)
+	for _, e := range entries[start:] {
+		buf := make([]byte, e.length)
+		if _, err := s.file.ReadAt(buf, e.offset); err != nil {
+			return nil, fmt.Errorf("read message log at offset %d: %w", e.offset, err)
+		}
+		var rec StoredMessage
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			return nil, fmt.Errorf("decode message log at offset %d: %w", e.offset, err)
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// Ping checks that the log file is still open and accessible.
+func (s *FileStore) Ping() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.broken != nil {
+		return s.broken
+	}
+	_, err := s.file.Stat()
+	return err
+}
+
+// Close closes the underlying log file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
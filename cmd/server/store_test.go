@@ -0,0 +1,204 @@
+// This file contains unit tests for the MessageStore implementations (defined
+// in store.go).
+//
+// KEY GO TESTING CONCEPTS IN THIS FILE:
+//   - Table-driven tests that run the same checks against several
+//     implementations of one interface
+//   - t.Run for named subtests
+//   - t.TempDir for throwaway files that are cleaned up automatically
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMessageStores runs the same behavioral checks against every
+// MessageStore implementation.
+//
+// LEARNING POINT — Testing an Interface, Not an Implementation:
+// Each table entry supplies a constructor. The test body only uses the
+// MessageStore interface, so any new backend can be added to the table and
+// immediately gets the full suite for free.
+func TestMessageStores(t *testing.T) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) MessageStore
+	}{
+		{"memory", func(t *testing.T) MessageStore { return NewMemoryStore() }},
+		{"file", func(t *testing.T) MessageStore {
+			s, err := OpenFileStore(filepath.Join(t.TempDir(), "messages.log"))
+			if err != nil {
+				t.Fatalf("open file store: %v", err)
+			}
+			return s
+		}},
+	}
+
+	for _, tc := range stores {
+		// t.Run starts a named subtest. Failures are reported as
+		// TestMessageStores/memory, TestMessageStores/file, and so on.
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.open(t)
+			defer s.Close()
+
+			dm := directConversation("alice", "bob")
+			room := roomConversation("general")
+
+			first, err := s.Append(dm, Message{Sender: "alice", Recipient: "bob", Content: "hi"})
+			if err != nil {
+				t.Fatalf("append: %v", err)
+			}
+			s.Append(room, Message{Type: "room_msg", Sender: "alice", Room: "general", Content: "hello all"})
+			s.Append(dm, Message{Sender: "bob", Recipient: "alice", Content: "hey"})
+
+			all, err := s.Conversation(dm)
+			if err != nil {
+				t.Fatalf("conversation: %v", err)
+			}
+			if len(all) != 2 {
+				t.Fatalf("expected 2 direct messages, got %d", len(all))
+			}
+			if all[0].Message.Content != "hi" || all[1].Message.Content != "hey" {
+				t.Errorf("messages out of order: %+v", all)
+			}
+			// The room message in between must not affect the direct
+			// conversation's sequence numbers.
+			if all[0].Message.Seq != 1 || all[1].Message.Seq != 2 {
+				t.Errorf("expected seq 1 and 2, got %d and %d", all[0].Message.Seq, all[1].Message.Seq)
+			}
+
+			since, err := s.Since(dm, first.Cursor)
+			if err != nil {
+				t.Fatalf("since: %v", err)
+			}
+			if len(since) != 1 || since[0].Message.Content != "hey" {
+				t.Errorf("expected only the reply after the first cursor, got %+v", since)
+			}
+
+			if rooms, _ := s.Conversation(room); len(rooms) != 1 {
+				t.Errorf("expected 1 room message, got %d", len(rooms))
+			}
+
+			// A batch numbers its messages as if they had been appended one
+			// by one, even when it holds two for the same conversation.
+			recs, err := s.AppendAll([]StoredMessage{
+				{Conversation: dm, Message: Message{Sender: "alice", Content: "one"}},
+				{Conversation: room, Message: Message{Sender: "alice", Content: "two"}},
+				{Conversation: dm, Message: Message{Sender: "alice", Content: "three"}},
+			})
+			if err != nil {
+				t.Fatalf("append all: %v", err)
+			}
+			if recs[0].Message.Seq != 3 || recs[1].Message.Seq != 2 || recs[2].Message.Seq != 4 {
+				t.Errorf("expected seq 3, 2 and 4, got %+v", recs)
+			}
+			if all, _ := s.Since(dm, since[0].Cursor); len(all) != 2 || all[1].Cursor != recs[2].Cursor {
+				t.Errorf("expected the batch's 2 direct messages after the reply, got %+v", all)
+			}
+		})
+	}
+}
+
+// TestDirectConversationIsSymmetric verifies that both directions of a
+// direct chat share one conversation key.
+func TestDirectConversationIsSymmetric(t *testing.T) {
+	if directConversation("alice", "bob") != directConversation("bob", "alice") {
+		t.Error("expected alice->bob and bob->alice to share a conversation")
+	}
+}
+
+// TestFileStoreReopen verifies that messages survive closing and reopening
+// the log, and that cursors keep increasing across restarts.
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+	conv := roomConversation("general")
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	s.Append(conv, Message{Content: "before restart"})
+	s.Close()
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+
+	rec, err := s.Append(conv, Message{Content: "after restart"})
+	if err != nil {
+		t.Fatalf("append after reopen: %v", err)
+	}
+	if rec.Cursor != 2 || rec.Message.Seq != 2 {
+		t.Errorf("expected cursor 2 and seq 2 after reopen, got %d and %d", rec.Cursor, rec.Message.Seq)
+	}
+
+	msgs, _ := s.Conversation(conv)
+	if len(msgs) != 2 || msgs[0].Message.Content != "before restart" {
+		t.Errorf("expected history to survive reopen, got %+v", msgs)
+	}
+}
+
+// TestFileStoreTornRecord verifies that a partial record left by a crash is
+// discarded instead of preventing the store from opening.
+func TestFileStoreTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+
+	s, _ := OpenFileStore(path)
+	s.Append(roomConversation("general"), Message{Content: "complete"})
+	s.Close()
+
+	// Simulate a crash halfway through writing the next record.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	f.WriteString(`{"cursor":2,"conversation":"room:gen`)
+	f.Close()
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("expected torn record to be tolerated, got %v", err)
+	}
+	defer s.Close()
+
+	rec, _ := s.Append(roomConversation("general"), Message{Content: "next"})
+	if rec.Cursor != 2 {
+		t.Errorf("expected cursor 2 after discarding torn record, got %d", rec.Cursor)
+	}
+	if msgs, _ := s.Conversation(roomConversation("general")); len(msgs) != 2 {
+		t.Errorf("expected 2 messages, got %d", len(msgs))
+	}
+}
+
+// TestFileStoreFailedAppend verifies that part of a record left in the file
+// by a failed append is cut off, so the next append can't bury it in the log.
+func TestFileStoreFailedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+
+	s, _ := OpenFileStore(path)
+	s.Append(roomConversation("general"), Message{Content: "complete"})
+
+	// Simulate a write that got a long record onto disk but then failed to
+	// sync, as Append would see it.
+	s.mu.Lock()
+	s.file.WriteAt([]byte(`{"cursor":2,"conversation":"room:general","message":{"content":"a long message"}`+"\n"), s.size)
+	err := s.discardTail(errors.New("sync failed"))
+	s.mu.Unlock()
+	if err == nil || s.broken != nil {
+		t.Fatalf("expected the append's error and a usable store, got %v, %v", err, s.broken)
+	}
+
+	s.Append(roomConversation("general"), Message{Content: "hi"})
+	s.Close()
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("expected the log to reopen, got %v", err)
+	}
+	defer s.Close()
+	if msgs, _ := s.Conversation(roomConversation("general")); len(msgs) != 2 || msgs[1].Message.Content != "hi" {
+		t.Errorf("expected the 2 complete messages, got %+v", msgs)
+	}
+}
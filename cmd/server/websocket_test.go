@@ -17,6 +17,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -47,7 +48,7 @@ import (
 // strings.Replace converts the scheme. In production, you'd use "wss://" for
 // secure WebSockets (analogous to "https://").
 func TestWebSocketUpgrade(t *testing.T) {
-	s := &Server{hub: NewHub()}
+	s := newTestServer()
 	mux := SetupRouter(s)
 
 	// Start a real HTTP server on a random port.
@@ -55,11 +56,11 @@ func TestWebSocketUpgrade(t *testing.T) {
 	defer server.Close() // Shut down the server when the test ends.
 
 	// Convert http:// to ws:// for WebSocket dialing.
-	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws?user=testuser"
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
 
 	// context.Background() as the root context for the WebSocket connection.
 	ctx := context.Background()
-	c, _, err := websocket.Dial(ctx, wsURL, nil)
+	c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "testuser"), nil)
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -79,7 +80,7 @@ func TestWebSocketUpgrade(t *testing.T) {
 // it exercises the full stack: WebSocket read -> JSON parse -> hub lookup ->
 // WebSocket write.
 func TestMessageDelivery(t *testing.T) {
-	s := &Server{hub: NewHub()}
+	s := newTestServer()
 	mux := SetupRouter(s)
 	server := httptest.NewServer(mux)
 	defer server.Close()
@@ -89,14 +90,14 @@ func TestMessageDelivery(t *testing.T) {
 	ctx := context.Background()
 
 	// Connect Alice
-	c1, _, err := websocket.Dial(ctx, wsURL+"?user=alice", nil)
+	c1, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
 	if err != nil {
 		t.Fatalf("alice failed to dial: %v", err)
 	}
 	defer c1.Close(websocket.StatusNormalClosure, "")
 
 	// Connect Bob
-	c2, _, err := websocket.Dial(ctx, wsURL+"?user=bob", nil)
+	c2, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
 	if err != nil {
 		t.Fatalf("bob failed to dial: %v", err)
 	}
@@ -126,11 +127,17 @@ func TestMessageDelivery(t *testing.T) {
 		t.Errorf("expected text message, got %v", typ)
 	}
 
-	// Verify the raw payload matches exactly. The server forwards the original
-	// bytes for direct messages, so the payload should be identical.
-	expected := `{"sender": "alice", "recipient": "bob", "content": "Hello Bob!"}`
-	if string(p) != expected {
-		t.Errorf("expected %s, got %s", expected, string(p))
+	// The server re-encodes direct messages after stamping them, so compare
+	// fields rather than raw bytes.
+	var received Message
+	if err := json.Unmarshal(p, &received); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+	if received.Sender != "alice" || received.Recipient != "bob" || received.Content != "Hello Bob!" {
+		t.Errorf("unexpected message: %+v", received)
+	}
+	if received.ID == "" || received.Timestamp.IsZero() || received.Seq != 1 {
+		t.Errorf("expected server-assigned id, timestamp and seq 1, got %+v", received)
 	}
 }
 
@@ -142,15 +149,15 @@ func TestMessageDelivery(t *testing.T) {
 // and verify the fields. This is analogous to HTTP request/response testing
 // but over a persistent WebSocket connection.
 func TestCreateRoomViaWebSocket(t *testing.T) {
-	s := &Server{hub: NewHub()}
+	s := newTestServer()
 	mux := SetupRouter(s)
 	server := httptest.NewServer(mux)
 	defer server.Close()
 
-	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws?user=alice"
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
 	ctx := context.Background()
 
-	c, _, err := websocket.Dial(ctx, wsURL, nil)
+	c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -201,7 +208,7 @@ func TestCreateRoomViaWebSocket(t *testing.T) {
 // If you don't read acks, subsequent reads would return the ack instead of
 // the expected message, causing confusing test failures.
 func TestInviteAndRoomMessage(t *testing.T) {
-	s := &Server{hub: NewHub()}
+	s := newTestServer()
 	mux := SetupRouter(s)
 	server := httptest.NewServer(mux)
 	defer server.Close()
@@ -210,14 +217,14 @@ func TestInviteAndRoomMessage(t *testing.T) {
 	ctx := context.Background()
 
 	// Connect Alice
-	alice, _, err := websocket.Dial(ctx, wsURL+"?user=alice", nil)
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
 	if err != nil {
 		t.Fatalf("alice failed to dial: %v", err)
 	}
 	defer alice.Close(websocket.StatusNormalClosure, "")
 
 	// Connect Bob
-	bob, _, err := websocket.Dial(ctx, wsURL+"?user=bob", nil)
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
 	if err != nil {
 		t.Fatalf("bob failed to dial: %v", err)
 	}
@@ -253,12 +260,19 @@ func TestInviteAndRoomMessage(t *testing.T) {
 		t.Errorf("expected room 'devteam', got %q", notification.Room)
 	}
 
-	// Step 3: Alice sends a room message.
+	// Step 3: Bob accepts. Both of them hear that he joined.
+	acceptMsg := Message{Type: "accept_invite", Room: "devteam"}
+	data, _ = json.Marshal(acceptMsg)
+	bob.Write(ctx, websocket.MessageText, data)
+	readUntil(t, ctx, bob, "member_joined")
+	readUntil(t, ctx, alice, "member_joined")
+
+	// Step 4: Alice sends a room message.
 	roomMsg := Message{Type: "room_msg", Sender: "alice", Room: "devteam", Content: "Hello team!"}
 	data, _ = json.Marshal(roomMsg)
 	alice.Write(ctx, websocket.MessageText, data)
 
-	// Bob should receive the room message (since he was invited).
+	// Bob should receive the room message (since he accepted).
 	_, p, err = bob.Read(ctx)
 	if err != nil {
 		t.Fatalf("bob failed to read room message: %v", err)
@@ -295,7 +309,7 @@ func TestInviteAndRoomMessage(t *testing.T) {
 // cancel function should always be deferred to release resources, even if the
 // timeout fires first.
 func TestRoomMessageNotDeliveredToNonMembers(t *testing.T) {
-	s := &Server{hub: NewHub()}
+	s := newTestServer()
 	mux := SetupRouter(s)
 	server := httptest.NewServer(mux)
 	defer server.Close()
@@ -304,10 +318,10 @@ func TestRoomMessageNotDeliveredToNonMembers(t *testing.T) {
 	ctx := context.Background()
 
 	// Connect Alice and Charlie.
-	alice, _, _ := websocket.Dial(ctx, wsURL+"?user=alice", nil)
+	alice, _, _ := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
 	defer alice.Close(websocket.StatusNormalClosure, "")
 
-	charlie, _, _ := websocket.Dial(ctx, wsURL+"?user=charlie", nil)
+	charlie, _, _ := websocket.Dial(ctx, withToken(t, s, wsURL, "charlie"), nil)
 	defer charlie.Close(websocket.StatusNormalClosure, "")
 
 	// Alice creates a room (only Alice is a member, Charlie is NOT invited).
@@ -333,3 +347,546 @@ func TestRoomMessageNotDeliveredToNonMembers(t *testing.T) {
 	// If err is non-nil (timeout), the test passes — Charlie correctly
 	// did not receive the private room message.
 }
+
+// TestMessagesArePersisted verifies that direct and room messages are written
+// to the MessageStore, even when nobody is online to receive them.
+func TestMessagesArePersisted(t *testing.T) {
+	s := newTestServer()
+	store := s.store
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx := context.Background()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+
+	data, _ := json.Marshal(Message{Type: "create_room", Content: "general"})
+	alice.Write(ctx, websocket.MessageText, data)
+	alice.Read(ctx) // consume the ack
+
+	addAccount(t, s, "bob")
+	data, _ = json.Marshal(Message{Type: "room_msg", Sender: "alice", Room: "general", Content: "anyone here?"})
+	alice.Write(ctx, websocket.MessageText, data)
+	data, _ = json.Marshal(Message{Sender: "alice", Recipient: "bob", Content: "are you there?"})
+	alice.Write(ctx, websocket.MessageText, data)
+
+	// The server handles each connection's messages in order, so once a
+	// create_room ack comes back both earlier messages have been stored.
+	data, _ = json.Marshal(Message{Type: "create_room", Content: "sync"})
+	alice.Write(ctx, websocket.MessageText, data)
+	readUntil(t, ctx, alice, "room_created")
+
+	if msgs, _ := store.Conversation(roomConversation("general")); len(msgs) != 1 {
+		t.Errorf("expected 1 stored room message, got %d", len(msgs))
+	}
+	if msgs, _ := store.Conversation(directConversation("alice", "bob")); len(msgs) != 1 {
+		t.Errorf("expected 1 stored direct message, got %d", len(msgs))
+	}
+}
+
+// TestOfflineDirectMessageQueued verifies that a direct message to an offline
+// user is acknowledged as queued and delivered when the user connects.
+func TestOfflineDirectMessageQueued(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	addAccount(t, s, "bob")
+
+	for _, text := range []string{"first", "second"} {
+		data, _ := json.Marshal(Message{Sender: "alice", Recipient: "bob", Content: text})
+		alice.Write(ctx, websocket.MessageText, data)
+
+		ack := readUntil(t, ctx, alice, "ack")
+		status := readUntil(t, ctx, alice, "queued")
+		if status.Recipient != "bob" || status.ID != ack.ID {
+			t.Errorf("expected queued status for bob's message %s, got %+v", ack.ID, status)
+		}
+	}
+
+	// Bob connects and should receive both messages, in order.
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+
+	for _, want := range []string{"first", "second"} {
+		_, p, err := bob.Read(ctx)
+		if err != nil {
+			t.Fatalf("bob failed to read queued message: %v", err)
+		}
+		var got Message
+		json.Unmarshal(p, &got)
+		if got.Content != want || got.Sender != "alice" {
+			t.Errorf("expected %q from alice, got %+v", want, got)
+		}
+	}
+}
+
+// TestDirectMessageToUnknownUser verifies that a message to someone without
+// an account is refused rather than queued for a user who can never collect
+// it.
+func TestDirectMessageToUnknownUser(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+
+	data, _ := json.Marshal(Message{Recipient: "nobody", Content: "hello?"})
+	alice.Write(ctx, websocket.MessageText, data)
+	if got := readUntil(t, ctx, alice, "error"); !strings.Contains(got.Content, "no user") {
+		t.Errorf("unexpected reply %+v", got)
+	}
+	if queued := s.hub.offline.drain("nobody"); len(queued) != 0 {
+		t.Errorf("expected nothing queued for an unknown user, got %d messages", len(queued))
+	}
+}
+
+// TestOfflineRoomMemberCatchesUp verifies that a room member who was offline
+// while messages were sent receives them after reconnecting.
+func TestOfflineRoomMemberCatchesUp(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+
+	// Bob is added to the room without ever having connected.
+	s.hub.createRoom("devteam", "alice")
+	s.hub.addToRoom("devteam", "alice", "bob")
+
+	data, _ := json.Marshal(Message{Type: "room_msg", Room: "devteam", Content: "standup in 5"})
+	alice.Write(ctx, websocket.MessageText, data)
+
+	// The ack means the room message has been handled.
+	readUntil(t, ctx, alice, "ack")
+
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+
+	_, p, err := bob.Read(ctx)
+	if err != nil {
+		t.Fatalf("bob failed to read missed room message: %v", err)
+	}
+	var got Message
+	json.Unmarshal(p, &got)
+	if got.Type != "room_msg" || got.Room != "devteam" || got.Content != "standup in 5" {
+		t.Errorf("expected queued room message, got %+v", got)
+	}
+}
+
+// TestLeaveKickAndDeleteRoom walks a room through leave_room, kick and
+// delete_room, checking who is told about each.
+func TestLeaveKickAndDeleteRoom(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conns := map[string]*websocket.Conn{}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		c, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, user), nil)
+		if err != nil {
+			t.Fatalf("%s failed to dial: %v", user, err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+		conns[user] = c
+	}
+	alice, bob, carol := conns["alice"], conns["bob"], conns["carol"]
+	send := func(c *websocket.Conn, msg Message) {
+		data, _ := json.Marshal(msg)
+		c.Write(ctx, websocket.MessageText, data)
+	}
+
+	send(alice, Message{Type: "create_room", Content: "general"})
+	send(alice, Message{Type: "invite", Room: "general", Recipient: "bob"})
+	send(alice, Message{Type: "invite", Room: "general", Recipient: "carol"})
+	readUntil(t, ctx, bob, "invited")
+	readUntil(t, ctx, carol, "invited")
+	send(bob, Message{Type: "accept_invite", Room: "general"})
+	readUntil(t, ctx, bob, "member_joined")
+	send(carol, Message{Type: "accept_invite", Room: "general"})
+	readUntil(t, ctx, carol, "member_joined")
+
+	// Plain members may not kick.
+	send(bob, Message{Type: "kick", Room: "general", Recipient: "carol"})
+	if got := readUntil(t, ctx, bob, "error"); !strings.Contains(got.Content, "not allowed") {
+		t.Errorf("unexpected error %+v", got)
+	}
+
+	send(carol, Message{Type: "leave_room", Room: "general"})
+	for name, c := range map[string]*websocket.Conn{"alice": alice, "bob": bob, "carol": carol} {
+		if got := readUntil(t, ctx, c, "member_left"); got.Sender != "carol" {
+			t.Errorf("expected %s to be told carol left, got %+v", name, got)
+		}
+	}
+
+	send(alice, Message{Type: "kick", Room: "general", Recipient: "bob"})
+	for name, c := range map[string]*websocket.Conn{"alice": alice, "bob": bob} {
+		if got := readUntil(t, ctx, c, "member_removed"); got.Recipient != "bob" {
+			t.Errorf("expected %s to be told bob was removed, got %+v", name, got)
+		}
+	}
+	send(alice, Message{Type: "delete_room", Room: "general"})
+	readUntil(t, ctx, alice, "room_deleted")
+	if s.hub.getRoomMembers("general", "alice") != nil {
+		t.Error("expected the room to be gone")
+	}
+}
+
+// newTestServer returns a Server wired with in-memory dependencies and a
+// fixed token secret. The user registry uses a tiny hash work factor so
+// tests that create accounts stay fast.
+func newTestServer() *Server {
+	users, _ := OpenUserRegistry("")
+	users.iterations = 1
+	return &Server{
+		hub:    NewHub(),
+		store:  NewMemoryStore(),
+		tokens: NewTokenIssuer([]byte("test-secret"), time.Hour),
+		users:  users,
+		config: DefaultConfig(),
+	}
+}
+
+// testPassword is the password withToken gives every account it creates.
+const testPassword = "correct horse"
+
+// addAccount creates an account for user if there isn't one yet, so that
+// messages and invitations can be sent to them before they first connect.
+func addAccount(t *testing.T, s *Server, user string) {
+	t.Helper()
+	if !s.users.Active(user) {
+		if err := s.users.Create(user, testPassword); err != nil {
+			t.Fatalf("failed to create account for %s: %v", user, err)
+		}
+	}
+}
+
+// withToken returns wsURL with a freshly issued token for user appended as a
+// query parameter, ready to pass to websocket.Dial. The user's account is
+// created first if it doesn't exist yet.
+func withToken(t *testing.T, s *Server, wsURL, user string) string {
+	t.Helper()
+	addAccount(t, s, user)
+	token, _, err := s.tokens.Issue(user)
+	if err != nil {
+		t.Fatalf("failed to issue token for %s: %v", user, err)
+	}
+	return wsURL + "?token=" + token
+}
+
+// readUntil reads messages from c, skipping any whose type is not typ, and
+// returns the first one that matches. It fails the test if the read fails
+// (for example, because ctx expired first).
+//
+// LEARNING POINT — t.Helper():
+// Calling t.Helper() marks this function as a test helper. When an assertion
+// inside it fails, Go reports the line number in the calling test rather than
+// the line inside the helper, which is where you actually want to look.
+func readUntil(t *testing.T, ctx context.Context, c *websocket.Conn, typ string) Message {
+	t.Helper()
+	for {
+		_, p, err := c.Read(ctx)
+		if err != nil {
+			t.Fatalf("failed waiting for %q message: %v", typ, err)
+		}
+		var msg Message
+		if err := json.Unmarshal(p, &msg); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+// TestAckCarriesServerStamps verifies that the sender is acknowledged with
+// the server-assigned ID, timestamp and per-conversation sequence number.
+func TestAckCarriesServerStamps(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	addAccount(t, s, "bob")
+
+	var acks []Message
+	for _, text := range []string{"one", "two"} {
+		// A client-supplied ID must be ignored.
+		data, _ := json.Marshal(Message{ID: "forged", Sender: "alice", Recipient: "bob", Content: text})
+		alice.Write(ctx, websocket.MessageText, data)
+		acks = append(acks, readUntil(t, ctx, alice, "ack"))
+	}
+
+	if acks[0].ID == "" || acks[0].ID == "forged" || acks[0].ID == acks[1].ID {
+		t.Errorf("expected distinct server-assigned ids, got %q and %q", acks[0].ID, acks[1].ID)
+	}
+	if acks[0].Seq != 1 || acks[1].Seq != 2 {
+		t.Errorf("expected seq 1 then 2, got %d then %d", acks[0].Seq, acks[1].Seq)
+	}
+	if acks[0].Timestamp.IsZero() || acks[1].Timestamp.Before(acks[0].Timestamp) {
+		t.Errorf("expected non-decreasing timestamps, got %v then %v", acks[0].Timestamp, acks[1].Timestamp)
+	}
+}
+
+// TestDeliveryAndReadReceipts walks a direct message through all three ticks:
+// ack (sent), delivered, and read.
+func TestDeliveryAndReadReceipts(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+
+	data, _ := json.Marshal(Message{Sender: "alice", Recipient: "bob", Content: "did you get this?"})
+	alice.Write(ctx, websocket.MessageText, data)
+
+	ack := readUntil(t, ctx, alice, "ack")
+	delivered := readUntil(t, ctx, alice, "delivered")
+	if delivered.ID != ack.ID || delivered.Recipient != "bob" {
+		t.Errorf("expected delivered receipt for %s to bob, got %+v", ack.ID, delivered)
+	}
+
+	got := readUntil(t, ctx, bob, "")
+	data, _ = json.Marshal(Message{Type: "read", ID: got.ID})
+	bob.Write(ctx, websocket.MessageText, data)
+
+	read := readUntil(t, ctx, alice, "read")
+	if read.ID != ack.ID || read.Sender != "bob" {
+		t.Errorf("expected read receipt for %s from bob, got %+v", ack.ID, read)
+	}
+	if read.Receipt == nil || *read.Receipt != (Receipt{Delivered: 1, Read: 1, Total: 1}) {
+		t.Errorf("expected read 1/1, got %+v", read.Receipt)
+	}
+}
+
+// TestRoomDeliveryReceiptCounts verifies that room receipts report how many
+// members a message has reached, including members who were offline at send
+// time and connect later.
+func TestRoomDeliveryReceiptCounts(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice failed to dial: %v", err)
+	}
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+
+	s.hub.createRoom("devteam", "alice")
+	s.hub.addToRoom("devteam", "alice", "bob")
+	s.hub.addToRoom("devteam", "alice", "carol")
+
+	data, _ := json.Marshal(Message{Type: "room_msg", Room: "devteam", Content: "ship it"})
+	alice.Write(ctx, websocket.MessageText, data)
+
+	first := readUntil(t, ctx, alice, "delivered")
+	if first.Receipt == nil || *first.Receipt != (Receipt{Delivered: 1, Total: 2}) {
+		t.Fatalf("expected delivered 1/2 while carol is offline, got %+v", first.Receipt)
+	}
+
+	carol, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "carol"), nil)
+	if err != nil {
+		t.Fatalf("carol failed to dial: %v", err)
+	}
+	defer carol.Close(websocket.StatusNormalClosure, "")
+
+	second := readUntil(t, ctx, alice, "delivered")
+	if second.Recipient != "carol" || second.Receipt == nil || second.Receipt.Delivered != 2 {
+		t.Errorf("expected delivered 2/2 after carol connects, got %+v", second)
+	}
+}
+
+// TestWebSocketRequiresToken verifies that connections without a valid token
+// are refused before the upgrade.
+func TestWebSocketRequiresToken(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx := context.Background()
+
+	for _, url := range []string{wsURL, wsURL + "?user=alice", wsURL + "?token=garbage"} {
+		_, resp, err := websocket.Dial(ctx, url, nil)
+		if err == nil {
+			t.Errorf("expected dial to %s to fail", url)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected 401 for %s, got %v", url, resp)
+		}
+	}
+}
+
+// TestSenderIsAuthenticatedUser verifies that the server ignores the Sender a
+// client claims and uses the identity from its token instead.
+func TestSenderIsAuthenticatedUser(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mallory, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "mallory"), nil)
+	if err != nil {
+		t.Fatalf("mallory failed to dial: %v", err)
+	}
+	defer mallory.Close(websocket.StatusNormalClosure, "")
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+
+	data, _ := json.Marshal(Message{Sender: "alice", Recipient: "bob", Content: "trust me"})
+	mallory.Write(ctx, websocket.MessageText, data)
+
+	got := readUntil(t, ctx, bob, "")
+	if got.Sender != "mallory" {
+		t.Errorf("expected sender to be rewritten to mallory, got %q", got.Sender)
+	}
+}
+
+// TestWebSocketRejectsDisabledAccount verifies that a valid token is not
+// enough to connect once the account behind it has been disabled.
+func TestWebSocketRejectsDisabledAccount(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	url := withToken(t, s, wsURL, "alice")
+	s.users.Disable("alice")
+
+	_, resp, err := websocket.Dial(context.Background(), url, nil)
+	if err == nil {
+		t.Fatal("expected dial for disabled account to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403, got %v", resp)
+	}
+}
+
+// TestMultipleDevices verifies that a user connected from two devices
+// receives messages on both, and that each device sees what the other sent.
+func TestMultipleDevices(t *testing.T) {
+	s := newTestServer()
+	server := httptest.NewServer(SetupRouter(s))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/ws"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	phone, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice's phone failed to dial: %v", err)
+	}
+	defer phone.Close(websocket.StatusNormalClosure, "")
+	laptop, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "alice"), nil)
+	if err != nil {
+		t.Fatalf("alice's laptop failed to dial: %v", err)
+	}
+	defer laptop.Close(websocket.StatusNormalClosure, "")
+	bob, _, err := websocket.Dial(ctx, withToken(t, s, wsURL, "bob"), nil)
+	if err != nil {
+		t.Fatalf("bob failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.StatusNormalClosure, "")
+
+	// Sent from the laptop: the phone gets a copy, and only the laptop gets
+	// the ack.
+	data, _ := json.Marshal(Message{Recipient: "bob", Content: "from my laptop"})
+	laptop.Write(ctx, websocket.MessageText, data)
+	ack := readUntil(t, ctx, laptop, "ack")
+
+	echo := readUntil(t, ctx, phone, "")
+	if echo.ID != ack.ID || echo.Sender != "alice" || echo.Recipient != "bob" {
+		t.Errorf("expected the phone to get a copy of %s, got %+v", ack.ID, echo)
+	}
+	if got := readUntil(t, ctx, bob, ""); got.Content != "from my laptop" {
+		t.Errorf("expected bob to get the message, got %+v", got)
+	}
+
+	// A reply reaches both devices.
+	data, _ = json.Marshal(Message{Recipient: "alice", Content: "got it"})
+	bob.Write(ctx, websocket.MessageText, data)
+	for name, c := range map[string]*websocket.Conn{"phone": phone, "laptop": laptop} {
+		if got := readUntil(t, ctx, c, ""); got.Content != "got it" {
+			t.Errorf("expected the %s to get bob's reply, got %+v", name, got)
+		}
+	}
+}
@@ -2,4 +2,4 @@ module whatsapp-clone
 
 go 1.25.5
 
-require nhooyr.io/websocket v1.8.17 // indirect
+require nhooyr.io/websocket v1.8.17